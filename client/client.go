@@ -8,6 +8,7 @@ import (
   "encoding/json"
   "errors"
   "fmt"
+  rerrors "github.com/halokid/rpcx-plus/errors"
   logs "github.com/halokid/rpcx-plus/log"
   "github.com/halokid/rpcx-plus/protocol"
   "github.com/halokid/rpcx-plus/share"
@@ -26,7 +27,6 @@ import (
   "strings"
   "sync"
   "time"
-  "unsafe"
 )
 
 const (
@@ -50,6 +50,14 @@ func (e ServiceError) Error() string {
   return string(e)
 }
 
+// serviceError returns the error of an error response, a structured *errors.Error if the server has sent a code.
+func serviceError(metadata map[string]string) error {
+  if serr := rerrors.FromMetadata(metadata); serr != nil {
+    return serr
+  }
+  return ServiceError(metadata[protocol.ServiceError])
+}
+
 // DefaultOption is a common option configuration for client.
 var DefaultOption = Option{
   Retries:        3,
@@ -58,8 +66,9 @@ var DefaultOption = Option{
   //ConnectTimeout: 2 * time.Second,
   SerializeType: protocol.MsgPack,
   //SerializeType:  protocol.JSON,
-  CompressType:  protocol.None,
-  BackupLatency: 10 * time.Millisecond,
+  FallbackSerializeTypes: []protocol.SerializeType{protocol.JSON, protocol.CBOR, protocol.Gob},
+  CompressType:           protocol.None,
+  BackupLatency:          10 * time.Millisecond,
 }
 
 // Breaker is a CircuitBreaker interface.
@@ -77,6 +86,10 @@ var CircuitBreaker Breaker = circuit.NewRateBreaker(0.95, 100)
 var (
   ErrShutdown         = errors.New("connection is shut down")
   ErrUnsupportedCodec = errors.New("unsupported codec")
+  // ErrServerOverloaded the server is too busy and has not handled the request.
+  ErrServerOverloaded = errors.New("server overloaded")
+  // ErrServerGoingAway the server is shutting down and has not handled the request.
+  ErrServerGoingAway = errors.New("server is going away")
 )
 
 const (
@@ -107,6 +120,8 @@ type RPCClient interface {
   Http2Call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error
   HttpCall(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error
   Http2CallGw(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error
+
+  NewStream(ctx context.Context, servicePath, serviceMethod string, args interface{}) (*Stream, error)
 }
 
 // Client represents a RPC client.
@@ -120,9 +135,12 @@ type Client struct {
   mutex        sync.Mutex // protects following
   seq          uint64
   pending      map[uint64]*Call
+  streams      map[uint64]*Stream
+  negotiated   *protocol.Handshake // agreed in the handshake
   closing      bool // user has called Close
   shutdown     bool // server has told us to stop
   pluginClosed bool // the plugin has been called
+  goingAway    bool // the server is shutting down
 
   Plugins PluginContainer
 
@@ -147,7 +165,8 @@ type Option struct {
   // Retries retries to send
   Retries int
 
-  // TLSConfig for tcp and quic
+  // TLSConfig for tcp and quic.
+  // Use share.CertReloader.ClientConfig to reload the certificates when their files change.
   TLSConfig *tls.Config
   // kcp.BlockCrypt
   Block interface{}
@@ -167,11 +186,41 @@ type Option struct {
   GenBreaker func() Breaker
 
   SerializeType protocol.SerializeType
-  CompressType  protocol.CompressType
+  // FallbackSerializeTypes are used in order by XClient with servers that advertise
+  // in their registry metadata that they do not support SerializeType.
+  FallbackSerializeTypes []protocol.SerializeType
+  CompressType           protocol.CompressType
+  // CompressThreshold is the payload size above which requests are compressed with CompressType.
+  // protocol.DefaultCompressThreshold is used if it is zero.
+  CompressThreshold int
 
   Heartbeat         bool
   HeartbeatInterval time.Duration
 
+  // DecodeLimits bounds the responses read from servers.
+  DecodeLimits protocol.DecodeLimits
+
+  // StreamWindow is the number of messages a server may send on a stream before this client reads them.
+  // protocol.DefaultStreamWindow is used if it is zero.
+  StreamWindow uint32
+
+  // Checksum appends a CRC32C trailer to requests, servers answer with checksums too.
+  // The server must support checksums. With Handshake, calls to servers that have not agreed them
+  // fail with a *FeatureError.
+  Checksum bool
+
+  // Handshake negotiates the protocol version, compressors, serializers and features
+  // with the server after connecting. Servers without handshake support are used as before.
+  // Cancel frames are only sent to servers that have agreed them, so without Handshake
+  // the handlers of the server are not cancelled when the context of a call is done.
+  // Likewise, draining servers only send going away messages to clients with Handshake,
+  // other clients find out when their requests are refused or the connection is closed.
+  Handshake bool
+
+  // Idempotent sends an idempotency key generated once per call of XClient with the call and its retries,
+  // so that servers with an IdempotencyPlugin run calls retried by Failover, Failtry, Failbackup or Fork once.
+  Idempotent bool
+
   Http2 bool
   Http  bool
 }
@@ -199,6 +248,19 @@ func (call *Call) done() {
   }
 }
 
+// compressThreshold returns the payload size above which requests are compressed.
+func (client *Client) compressThreshold() int {
+  if client.option.CompressThreshold > 0 {
+    return client.option.CompressThreshold
+  }
+  return protocol.DefaultCompressThreshold
+}
+
+// useChecksum returns whether requests carry a checksum.
+func (client *Client) useChecksum() bool {
+  return client.option.Checksum && client.hasFeature(protocol.FeatureChecksum)
+}
+
 // RegisterServerMessageChan registers the channel that receives server requests.
 func (client *Client) RegisterServerMessageChan(ch chan<- *protocol.Message) {
   client.ServerMessageChan = ch
@@ -216,6 +278,23 @@ func (client *Client) IsClosing() bool {
   return client.closing
 }
 
+// goAway marks the client closing when the server is shutting down.
+// The calls in flight are finished, new calls fail with ErrServerGoingAway.
+func (client *Client) goAway() {
+  logs.Infof("rpcx: server %s is going away", client.Conn.RemoteAddr())
+  client.mutex.Lock()
+  client.closing = true
+  client.goingAway = true
+  client.mutex.Unlock()
+}
+
+// isGoingAway returns whether the server has told the client that it is shutting down.
+func (client *Client) isGoingAway() bool {
+  client.mutex.Lock()
+  defer client.mutex.Unlock()
+  return client.goingAway
+}
+
 // IsShutdown client is shutdown or not.
 func (client *Client) IsShutdown() bool {
   client.mutex.Lock()
@@ -542,6 +621,7 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
     if call != nil {
       call.Error = ctx.Err()
       call.done()
+      client.sendCancel(*seq)
     }
 
     return ctx.Err()
@@ -561,6 +641,36 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
   return err
 }
 
+// sendCancel tells the server to cancel the context of the request seq,
+// so that it stops handling a call whose reply nobody waits for.
+// It is only sent to servers that have agreed cancel frames in the handshake, other servers
+// would take it for a oneway request. The frame has no service path and method anyway,
+// so that such a server fails to find a service instead of calling the method again.
+func (client *Client) sendCancel(seq uint64) {
+  if client.option.Http2 || client.option.Http || client.IsShutdown() {
+    return
+  }
+  if agreed := client.Negotiated(); agreed == nil || !agreed.HasFeature(protocol.FeatureCancel) {
+    return
+  }
+
+  req := protocol.GetPooledMsg()
+  req.SetMessageType(protocol.Request)
+  req.SetVersion(client.version())
+  req.SetSeq(seq)
+  req.SetOneway(true)
+  req.SetFrameType(protocol.FrameCancel)
+
+  if client.Plugins != nil {
+    client.Plugins.DoClientBeforeEncode(req)
+  }
+  _, err := req.EncodeTo(client.Conn)
+  if err != nil {
+    logs.Warnf("rpcx: failed to cancel request %d: %v", seq, err)
+  }
+  protocol.FreeMsg(req)
+}
+
 // SendRaw sends raw messages. You don't care args and replys.
 func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
   logs.Debugf("-->>> gateway call SendRaw...")
@@ -595,6 +705,16 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
     call.Metadata = rmeta
   }
   r.Metadata = rmeta
+  if client.hasFeature(protocol.FeatureDeadline) {
+    r.Metadata = share.WithDeadlineMetadata(ctx, rmeta)
+  }
+  r.SetVersion(client.version())
+  if client.useChecksum() {
+    r.SetChecksum(true)
+  }
+  if client.Plugins != nil {
+    client.Plugins.DoClientBeforeEncode(r)
+  }
 
   // fixme: done的channel长度只有10， 可能这里是一个性能瓶颈
   done := make(chan *Call, 10)
@@ -617,17 +737,16 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 
   // todo: 这里是压缩， 不是用序列化方法， 假如采用加密方式， Encode 会加密请求的数据
   logs.Debugf("Client.SendRaw request payload send server -->>> %+v", string(r.Payload))
-  data := r.Encode() // 请求的所有数据转化为[]byte
-  // todo: 一共有两个 client.Conn.Write(data) 执行，一个是在SenfRaw 给gateway 调用, 一个是在 Client.Send() 给常规的client调用
-  // todo: 返回服务端结果的关键就是这个 client.Conn.Write(data), 关键是在client执行Connect的时候，其中的 c.r = bufio.NewReaderSize(conn, ReaderBuffsize)
+  // todo: 一共有两个 写入conn 执行，一个是在SenfRaw 给gateway 调用, 一个是在 Client.Send() 给常规的client调用
+  // todo: 返回服务端结果的关键就是这个 r.EncodeTo(client.Conn), 关键是在client执行Connect的时候，其中的 c.r = bufio.NewReaderSize(conn, ReaderBuffsize)
   // todo: 会实时从conn中读取server的返回，然后写入c.r，再通过 go.c.inpunt() 去读取c.r, 最后写入call.reply, 完成整个client到server的调用
-  _, err := client.Conn.Write(data) // todo: if the service is only http2, here will panic, because the client.Conn is nil
+  _, err := r.EncodeTo(client.Conn) // todo: if the service is only http2, here will panic, because the client.Conn is nil
   //logs.Debug("client.Conn.Write err -----------------", err)
   //logs.Debugff("done 4 ----------------- %+v", done)
   //logs.Debugff("call.Done 2 ----------------- %+v", call.Done)
 
   if err != nil {
-    logs.Debug("client.Conn.Write(data) err -------", err)
+    logs.Debug("r.EncodeTo(client.Conn) err -------", err)
     client.mutex.Lock()
     call = client.pending[seq]
     delete(client.pending, seq)
@@ -664,6 +783,7 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
     if call != nil {
       call.Error = ctx.Err()
       call.done()
+      client.sendCancel(seq)
     }
     return nil, nil, ctx.Err()
 
@@ -760,10 +880,16 @@ func urlencode(data map[string]string) string {
 func (client *Client) send(ctx context.Context, call *Call) {
 
   // Register this call.
-  // client.Conn.Write(data) 发送数据给服务端
+  // req.EncodeTo(client.Conn) 发送数据给服务端
   logs.Debugf("call 1: %+v ==> %+v ==> %+v ==> %+v \n <===== one client call done =====>\n\n", reflect.TypeOf(call), call, call.Args, call.Reply)
 
   client.mutex.Lock() // 锁住client， mutex作为一个锁句柄，放在client里面作为属性，方便调用
+  if client.goingAway && !client.shutdown {
+    call.Error = ErrServerGoingAway
+    client.mutex.Unlock()
+    call.done()
+    return
+  }
   if client.shutdown || client.closing {
     call.Error = ErrShutdown
     client.mutex.Unlock()
@@ -779,6 +905,14 @@ func (client *Client) send(ctx context.Context, call *Call) {
     call.done()
     return
   }
+  if call.ServicePath != "" || call.ServiceMethod != "" {
+    if err := client.checkNegotiated(); err != nil {
+      call.Error = err
+      client.mutex.Unlock()
+      call.done()
+      return
+    }
+  }
 
   if client.pending == nil {
     client.pending = make(map[uint64]*Call)
@@ -796,7 +930,9 @@ func (client *Client) send(ctx context.Context, call *Call) {
   //req := protocol.NewMessage()
   req := protocol.GetPooledMsg()
   req.SetMessageType(protocol.Request) // 设置数据类型是request
+  req.SetVersion(client.version())
   req.SetSeq(seq)
+  req.SetChecksum(client.useChecksum())
   if call.Reply == nil {
     logs.Debug("call.Reply is nil, 不需要服务端返回 run here!!! -------------")
     req.SetOneway(true)
@@ -810,6 +946,9 @@ func (client *Client) send(ctx context.Context, call *Call) {
     if call.Metadata != nil {
       req.Metadata = call.Metadata
     }
+    if client.hasFeature(protocol.FeatureDeadline) {
+      req.Metadata = share.WithDeadlineMetadata(ctx, req.Metadata)
+    }
 
     req.ServicePath = call.ServicePath
     req.ServiceMethod = call.ServiceMethod
@@ -821,8 +960,8 @@ func (client *Client) send(ctx context.Context, call *Call) {
       call.done()
       return
     }
-    if len(data) > 1024 && client.option.CompressType != protocol.None {
-      // 加入byte的长度大于1024才需要压缩
+    if len(data) > client.compressThreshold() && client.option.CompressType != protocol.None {
+      // 加入byte的长度大于CompressThreshold才需要压缩
       req.SetCompressType(client.option.CompressType)
     }
 
@@ -832,8 +971,6 @@ func (client *Client) send(ctx context.Context, call *Call) {
   if client.Plugins != nil {
     client.Plugins.DoClientBeforeEncode(req)
   }
-  data := req.Encode()
-
   logs.Debugf("%+v call 6: %+v ==> %+v ==> %+v ==> %+v \n <===== one client call done =====>\n\n", time.Now(), reflect.TypeOf(call), call, call.Args, call.Reply)
 
   // todo: 发送给服务端之后，客户端是怎样获取返回的数据的呢？
@@ -844,15 +981,12 @@ func (client *Client) send(ctx context.Context, call *Call) {
   // todo: 2. 1建立连接之后， Connect函数的 c.r = bufio.NewReaderSize(conn, ReaderBuffsize)，会一直监听客户端和服务端连接的数据交互，只要服务端往客户端写数据， c.r就能获取到数据
   // todo: 3.  Connect函数的 go c.input() 一直在监听 client 的 接收数据， 处理接收数据， 然后把完成之后的call对象，写入本身这个call的 call.Done 属性, 然后client 的 call函数的 case call := <-Done: 就能获取chan 的输入， 完成整个client.call的流程
   // todo: 4.  那究竟是怎么把服务端的返回写入 call.Reply的呢？ 在 c.input函数里面， 通过 call = client.pending[seq]  取得每一次的call对象， 再通过 err = codec.Decode(data, call.Reply)，赋值给call.Reply, 然后因为开始客户端定义 reply := &Reply{},  是一个引用指针， 当 call.Reply = reply的时候， call.Reply 承接了这个指针， 当改变  call.Reply 的值时， 就会改变 &Reply{}, 就会改变 reply， 所以客户端可以用reply来捕获服务端的输出, 具体的范例在 testWriteToReply
-  _, err := client.Conn.Write(data) // todo: 向连接服务端的conn写入数据
+  // header和payload通过writev一起写入，不需要拼接成一个新的[]byte
+  n, err := req.EncodeTo(client.Conn) // todo: 向连接服务端的conn写入数据
 
   logs.Debugf("%+v call 7: %+v ==> %+v ==> %+v ==> %+v \n <===== one client call done =====>\n\n", time.Now(), reflect.TypeOf(call), call, call.Args, call.Reply)
 
-  //logs.Printf("client send data to serv: %+v -- %+v -- %+v", reflect.TypeOf(data), data, string(data[0:]))
-  logs.Debugf("client send data to serv: %+v ==> %+v ==> %+v", reflect.TypeOf(data), data, *(*string)(unsafe.Pointer(&data)))
-  logs.Debugf("%+v", *(*string)(unsafe.Pointer(&data)))
-  logs.Debugf(" --  " + string(data[:]) + "\n")
-  logs.Debugf("头16位是:--- " + string(data[:16]) + "\n")
+  logs.Debugf("client send data to serv, len: %+v", n)
 
   logs.Debugf("%+v call 8: %+v ==> %+v ==> %+v ==> %+v \n <===== one client call done =====>\n\n", time.Now(), reflect.TypeOf(call), call, call.Args, call.Reply)
 
@@ -913,10 +1047,20 @@ func (client *Client) input() {
     logs.Debugf(" ========= res.MessageStatusType() 1 ======== %+v", res.MessageStatusType())
 
     // todo: 包含序列化数据的过程， 如果客户端和服务端序列化方法不同， 会把产生的错误写进  res.MessageStatusType(). 如果是服务端检查到 序列化方法不对， 会返回给  client.r, 具体是会把 protol.Error 定义为1
-    err = res.Decode(client.r)
+    err = res.DecodeWithLimits(client.r, &client.option.DecodeLimits)
     logs.Debugf(" ========= res.MessageStatusType() 2 ======== %+v", res.MessageStatusType())
     //logs.Debugf("res.Payload 2 --------------------- %+v", res.Payload)
 
+    // a corrupted response only fails its call, the whole message has been read.
+    var cerr *protocol.ChecksumError
+    if errors.As(err, &cerr) {
+      client.failCorrupted(res, cerr)
+      err = nil
+      continue
+    }
+    if err == nil {
+      err = client.checkVersion(res)
+    }
     if err != nil {
       break
     }
@@ -924,6 +1068,15 @@ func (client *Client) input() {
       client.Plugins.DoClientAfterDecode(res)
     }
 
+    if res.FrameType() == protocol.FrameGoAway {
+      client.goAway()
+      continue
+    }
+    if res.IsStreamFrame() {
+      client.handleStreamFrame(res)
+      continue
+    }
+
     seq := res.Seq()
     var call *Call
     isServerMessage := (res.MessageType() == protocol.Request && !res.IsHeartbeat() && res.IsOneway())
@@ -954,7 +1107,17 @@ func (client *Client) input() {
       logs.Debugf(" ========= 序列化数据错误 go client.input() 2, client msg protocol Error ========")
       if len(res.Metadata) > 0 {
         call.ResMetadata = res.Metadata
-        call.Error = ServiceError(res.Metadata[protocol.ServiceError])
+        call.Error = serviceError(res.Metadata)
+        // the request has been corrupted on the way, it can be sent again.
+        if cerr := protocol.ChecksumErrorFromMetadata(res.Metadata); cerr != nil {
+          call.Error = cerr
+        }
+        if res.Metadata[protocol.ServiceOverloaded] != "" {
+          call.Error = ErrServerOverloaded
+        }
+        if res.Metadata[protocol.ServiceGoingAway] != "" {
+          call.Error = ErrServerGoingAway
+        }
       }
 
       if call.Raw {
@@ -1038,12 +1201,37 @@ func (client *Client) input() {
   }
 
   client.mutex.Unlock()
+  client.failStreams(err)
 
   if err != nil && err != io.EOF && !closing {
     logs.Error("rpcx: client protocol error:", err)
   }
 }
 
+// failCorrupted fails the call or the stream of res, whose checksum does not match.
+func (client *Client) failCorrupted(res *protocol.Message, err *protocol.ChecksumError) {
+  logs.Warnf("rpcx: corrupted response %d from %s: %v", res.Seq(), client.Conn.RemoteAddr(), err)
+  if res.IsStreamFrame() {
+    client.mutex.Lock()
+    st := client.streams[res.Seq()]
+    delete(client.streams, res.Seq())
+    client.mutex.Unlock()
+    if st != nil {
+      st.finish(err, nil)
+    }
+    return
+  }
+
+  client.mutex.Lock()
+  call := client.pending[res.Seq()]
+  delete(client.pending, res.Seq())
+  client.mutex.Unlock()
+  if call != nil {
+    call.Error = err
+    call.done()
+  }
+}
+
 func (client *Client) handleServerRequest(msg *protocol.Message) {
   defer func() {
     if r := recover(); r != nil {
@@ -1113,10 +1301,12 @@ func (client *Client) Close() error {
 
   if client.closing || client.shutdown {
     client.mutex.Unlock()
+    client.failStreams(ErrShutdown)
     return ErrShutdown
   }
 
   client.closing = true
   client.mutex.Unlock()
+  client.failStreams(ErrShutdown)
   return err
 }
@@ -2,14 +2,34 @@ package client
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	testutils "github.com/halokid/rpcx-plus/_testutils"
+	rerrors "github.com/halokid/rpcx-plus/errors"
 	"github.com/halokid/rpcx-plus/protocol"
 	"github.com/halokid/rpcx-plus/server"
+	"github.com/halokid/rpcx-plus/serverplugin"
+	"github.com/halokid/rpcx-plus/share"
+	"golang.org/x/net/http2"
 )
 
 type Args struct {
@@ -182,3 +202,995 @@ func TestClient_Res_Reset(t *testing.T) {
 		t.Fatalf("data has been set to empty after response has been reset: %v", data)
 	}
 }
+
+type Echo int
+
+func (t *Echo) Echo(ctx context.Context, args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func TestClient_Compress(t *testing.T) {
+	s := server.NewServer(server.WithCompressThreshold(16))
+	s.RegisterName("Echo", new(Echo), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+	args := strings.Repeat("rpcx", 64)
+
+	for _, ct := range []protocol.CompressType{protocol.Gzip, protocol.Zstd, protocol.Snappy, protocol.LZ4} {
+		option := DefaultOption
+		option.CompressType = ct
+		option.CompressThreshold = 16
+		client := &Client{
+			option: option,
+		}
+
+		err := client.Connect("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+
+		var reply string
+		err = client.Call(context.Background(), "Echo", "Echo", &args, &reply)
+		client.Close()
+		if err != nil {
+			t.Fatalf("compress type %d: failed to call: %v", ct, err)
+		}
+		if reply != args {
+			t.Fatalf("compress type %d: got wrong reply %q", ct, reply)
+		}
+	}
+}
+
+type Sleeper struct {
+	cancelled chan struct{}
+}
+
+func (t *Sleeper) Sleep(ctx context.Context, args *Args, reply *Reply) error {
+	select {
+	case <-ctx.Done():
+		close(t.cancelled)
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		return nil
+	}
+}
+
+func TestClient_Codecs(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+
+	for _, st := range []protocol.SerializeType{protocol.CBOR, protocol.BSON, protocol.Gob} {
+		option := DefaultOption
+		option.SerializeType = st
+		client := &Client{
+			option: option,
+		}
+
+		err := client.Connect("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+
+		reply := &Reply{}
+		err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+		client.Close()
+		if err != nil {
+			t.Fatalf("serialize type %d: failed to call: %v", st, err)
+		}
+		if reply.C != 200 {
+			t.Fatalf("serialize type %d: expect 200 but got %d", st, reply.C)
+		}
+	}
+}
+
+func TestClient_Cancel(t *testing.T) {
+	sleeper := &Sleeper{cancelled: make(chan struct{})}
+	s := server.NewServer()
+	s.RegisterName("Sleeper", sleeper, "")
+	s.RegisterName("LegacySleeper", &Sleeper{cancelled: make(chan struct{})}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+
+	// cancel frames are only sent to servers that have agreed them in the handshake.
+	legacy := &Client{
+		option:  DefaultOption,
+		Plugins: NewPluginContainer(),
+	}
+	legacyFrames := &cancelFramePlugin{}
+	legacy.Plugins.Add(legacyFrames)
+	if err := legacy.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer legacy.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	err := legacy.Call(ctx, "LegacySleeper", "Sleep", &Args{}, &Reply{})
+	if err != context.Canceled {
+		t.Fatalf("expect context.Canceled but got %v", err)
+	}
+	if len(legacyFrames.paths) != 0 {
+		t.Fatalf("expect no cancel frame without handshake but got %d", len(legacyFrames.paths))
+	}
+
+	option := DefaultOption
+	option.Handshake = true
+	client := &Client{
+		option:  option,
+		Plugins: NewPluginContainer(),
+	}
+	frames := &cancelFramePlugin{}
+	client.Plugins.Add(frames)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	err = client.Call(ctx, "Sleeper", "Sleep", &Args{}, &Reply{})
+	if err != context.Canceled {
+		t.Fatalf("expect context.Canceled but got %v", err)
+	}
+
+	select {
+	case <-sleeper.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the handler has not been cancelled")
+	}
+	if len(frames.paths) != 1 || frames.paths[0] != "" {
+		t.Fatalf("expect one cancel frame without service path but got %q", frames.paths)
+	}
+}
+
+// cancelFramePlugin records the service paths of the cancel frames sent by a client.
+type cancelFramePlugin struct {
+	paths []string
+}
+
+func (p *cancelFramePlugin) ClientBeforeEncode(req *protocol.Message) error {
+	if req.FrameType() == protocol.FrameCancel {
+		p.paths = append(p.paths, req.ServicePath+req.ServiceMethod)
+	}
+	return nil
+}
+
+func TestClient_Handshake(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+
+	option := DefaultOption
+	option.Handshake = true
+	client := &Client{
+		option: option,
+	}
+
+	err := client.Connect("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	agreed := client.Negotiated()
+	if agreed == nil {
+		t.Fatal("the handshake has not been agreed")
+	}
+	if agreed.Version != protocol.ProtocolVersion || !agreed.HasFeature(protocol.FeatureStream) {
+		t.Errorf("unexpected handshake %+v", agreed)
+	}
+
+	reply := &Reply{}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+}
+
+func TestClient_HandshakeLegacyServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// servers without handshake support echo heartbeats.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := protocol.Read(conn)
+		if err != nil {
+			return
+		}
+		req.SetMessageType(protocol.Response)
+		conn.Write(req.Encode())
+		time.Sleep(time.Second)
+	}()
+
+	option := DefaultOption
+	option.Handshake = true
+	client := &Client{
+		option: option,
+	}
+
+	err = client.Connect("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	agreed := client.Negotiated()
+	if agreed == nil || agreed.Agreed || agreed.Version != 0 || len(agreed.Features) != 0 {
+		t.Fatalf("expect nothing to be agreed with a legacy server but got %+v", agreed)
+	}
+
+	// the features of the protocol extensions are not used with a legacy server.
+	var ferr *FeatureError
+	if _, err := client.NewStream(context.Background(), "Arith", "Mul", &Args{}); !errors.As(err, &ferr) || !errors.Is(err, ErrStreamNotSupported) {
+		t.Errorf("expect a FeatureError for streams but got %v", err)
+	}
+	client.option.Checksum = true
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{}, &Reply{}); !errors.As(err, &ferr) || ferr.Feature != protocol.FeatureChecksum {
+		t.Errorf("expect a FeatureError for checksums but got %v", err)
+	}
+}
+
+func TestClient_HandshakeVersion(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	option := DefaultOption
+	option.Handshake = true
+	client := &Client{
+		option:  option,
+		Plugins: NewPluginContainer(),
+	}
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// requests are sent with the agreed version, responses come back with it.
+	reply := &Reply{}
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+
+	// the server rejects requests of another version.
+	client.Plugins.Add(versionPlugin{})
+	err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err == nil || !strings.Contains(err.Error(), "protocol version") {
+		t.Fatalf("expect a protocol version error but got %v", err)
+	}
+}
+
+// versionPlugin sends requests with version 0.
+type versionPlugin struct{}
+
+func (versionPlugin) ClientBeforeEncode(req *protocol.Message) error {
+	req.SetVersion(0)
+	return nil
+}
+
+// corruptConn flips a payload byte of the first message written to it.
+type corruptConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *corruptConn) Write(b []byte) (int, error) {
+	c.once.Do(func() {
+		b = append([]byte{}, b...)
+		b[len(b)-5] ^= 0xFF
+	})
+	return c.Conn.Write(b)
+}
+
+type corruptPlugin struct{}
+
+func (corruptPlugin) ConnCreated(conn net.Conn) (net.Conn, error) {
+	return &corruptConn{Conn: conn}, nil
+}
+
+func TestClient_Checksum(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+
+	option := DefaultOption
+	option.Checksum = true
+	client := &Client{
+		option:  option,
+		Plugins: NewPluginContainer(),
+	}
+	client.Plugins.Add(corruptPlugin{})
+
+	err := client.Connect("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	reply := &Reply{}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if !isChecksumError(err) {
+		t.Fatalf("expect a checksum error but got %v", err)
+	}
+
+	// the connection is still used after a corrupted request.
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+}
+
+type corruptResponsePlugin struct{}
+
+func (corruptResponsePlugin) HandleConnAccept(conn net.Conn) (net.Conn, bool) {
+	return &corruptConn{Conn: conn}, true
+}
+
+func TestClient_ChecksumResponse(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	s.Plugins.Add(corruptResponsePlugin{})
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	option := DefaultOption
+	option.Checksum = true
+	client := &Client{option: option}
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	reply := &Reply{}
+	err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if !isChecksumError(err) {
+		t.Fatalf("expect a checksum error but got %v", err)
+	}
+
+	// only the call of the corrupted response fails, the connection is kept.
+	if client.IsShutdown() {
+		t.Fatal("expect the client not to be shut down")
+	}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+}
+
+func TestClient_Overloaded(t *testing.T) {
+	sleeper := &Sleeper{cancelled: make(chan struct{})}
+	s := server.NewServer(server.WithWorkerPool(1, 0))
+	s.RegisterName("Sleeper", sleeper, "")
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+
+	// the handshake agrees the cancel frames that free the worker
+	option := DefaultOption
+	option.Handshake = true
+	client := &Client{
+		option: option,
+	}
+
+	err := client.Connect("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// the only worker sleeps until the call is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call(ctx, "Sleeper", "Sleep", &Args{}, &Reply{})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	reply := &Reply{}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err != ErrServerOverloaded {
+		t.Fatalf("expect ErrServerOverloaded but got %v", err)
+	}
+
+	cancel()
+	<-done
+	<-sleeper.cancelled
+	time.Sleep(100 * time.Millisecond)
+
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+}
+
+// Napper sleeps without watching its ctx, then sets response metadata.
+type Napper int
+
+func (t *Napper) Nap(ctx context.Context, args *Args, reply *Reply) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return errors.New("ctx is not a *share.Context")
+	}
+	time.Sleep(time.Duration(args.A) * time.Millisecond)
+	sctx.Value(share.ResMetaDataKey).(map[string]string)["napped"] = "true"
+	return nil
+}
+
+func TestClient_MethodPolicy(t *testing.T) {
+	s := server.NewServer(server.WithWorkerPool(2, 0),
+		server.WithMethodPolicy("Napper", "Nap", server.MethodPolicy{Timeout: 200 * time.Millisecond, MaxConcurrency: 1, MaxQueue: 4}))
+	s.RegisterName("Napper", new(Napper), "")
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// the timeout is answered when it fires, the handler keeps its slot until it returns
+	start := time.Now()
+	err = client.Call(context.Background(), "Napper", "Nap", &Args{A: 1000}, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.DeadlineExceeded || time.Since(start) > 600*time.Millisecond {
+		t.Fatalf("expect a DeadlineExceeded error after 200ms but got %v after %v", err, time.Since(start))
+	}
+
+	// the calls waiting for the slot do not hold the workers of the pool
+	queued := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resMetadata := make(map[string]string)
+			ctx := context.WithValue(context.Background(), share.ResMetaDataKey, resMetadata)
+			err := client.Call(ctx, "Napper", "Nap", &Args{A: 10}, &Reply{})
+			if err == nil && resMetadata["napped"] != "true" {
+				err = errors.New("expect the response metadata of the handler")
+			}
+			queued <- err
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	reply := &Reply{}
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d, %v", reply.C, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-queued; err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+	}
+}
+
+type Validator int
+
+func (t *Validator) Check(ctx context.Context, args *Args, reply *Reply) error {
+	if args.B == 0 {
+		return rerrors.New(rerrors.InvalidArgument, "invalid args", &rerrors.BadRequest{
+			FieldViolations: []rerrors.FieldViolation{{Field: "B", Description: "must not be 0"}},
+		})
+	}
+	return nil
+}
+
+func TestClient_StructuredError(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Validator", new(Validator), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	err = client.Call(context.Background(), "Validator", "Check", &Args{A: 1}, &Reply{})
+	var serr *rerrors.Error
+	if !errors.As(err, &serr) {
+		t.Fatalf("expect a structured error but got %T: %v", err, err)
+	}
+	if serr.Code != rerrors.InvalidArgument || serr.Message != "invalid args" {
+		t.Fatalf("unexpected error: %v %s", serr.Code, serr.Message)
+	}
+	if len(serr.Details) != 1 {
+		t.Fatalf("expect 1 detail but got %v", serr.Details)
+	}
+	br, ok := serr.Details[0].(*rerrors.BadRequest)
+	if !ok || len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "B" {
+		t.Fatalf("unexpected detail: %#v", serr.Details[0])
+	}
+
+	err = client.Call(context.Background(), "Validator", "Missing", &Args{A: 1}, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.Unimplemented {
+		t.Fatalf("expect Unimplemented but got %v", err)
+	}
+}
+
+type Panicker int
+
+func (t *Panicker) Panic(ctx context.Context, args *Args, reply *Reply) error {
+	panic("boom")
+}
+
+type panicPlugin struct{}
+
+func (panicPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if r.ServiceMethod == "Mul" && r.Metadata["panic"] != "" {
+		panic("plugin panic")
+	}
+	return nil
+}
+
+func TestClient_Panic(t *testing.T) {
+	s := server.NewServer()
+	s.Plugins.Add(panicPlugin{})
+	s.RegisterName("Panicker", new(Panicker), "")
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	err = client.Call(context.Background(), "Panicker", "Panic", &Args{}, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.Internal {
+		t.Fatalf("expect an Internal error but got %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"panic": "1"})
+	err = client.Call(ctx, "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.Internal {
+		t.Fatalf("expect an Internal error but got %v", err)
+	}
+
+	// the connection survives the panics
+	reply := &Reply{}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+}
+
+type Budget int
+
+func (t *Budget) Left(ctx context.Context, args *Args, reply *Reply) error {
+	deadline, ok := ctx.Deadline()
+	if ok {
+		reply.C = int(time.Until(deadline) / time.Millisecond)
+	}
+	return nil
+}
+
+func TestClient_Deadline(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Budget", new(Budget), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+
+	client := &Client{
+		option: DefaultOption,
+	}
+
+	err := client.Connect("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reply := &Reply{}
+	err = client.Call(ctx, "Budget", "Left", &Args{}, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C <= 1000 || reply.C > 2000 {
+		t.Fatalf("expect about 2000ms left in the handler but got %dms", reply.C)
+	}
+
+	// a request that arrives after its deadline is rejected.
+	ctx = context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.DeadlineKey: "-1s"})
+	err = client.Call(ctx, "Budget", "Left", &Args{}, &Reply{})
+	if err == nil || err.Error() != server.ErrRequestExpired.Error() {
+		t.Fatalf("expect %v but got %v", server.ErrRequestExpired, err)
+	}
+}
+
+func BenchmarkClient_Call(b *testing.B) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		b.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	args := &Args{A: 10, B: 20}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reply := &Reply{}
+		err = client.Call(context.Background(), "Arith", "Mul", args, reply)
+		if err != nil {
+			b.Fatalf("failed to call: %v", err)
+		}
+	}
+}
+
+type Whoami int
+
+func (t *Whoami) SPIFFEID(ctx context.Context, args *Args, reply *string) error {
+	identity := server.PeerIdentityFromContext(ctx)
+	if identity == nil {
+		return errors.New("no peer identity")
+	}
+	*reply = identity.SPIFFEID
+	return nil
+}
+
+// writeCert writes a certificate signed by ca, or a self signed CA if ca is nil, and its key in dir.
+func writeCert(t *testing.T, dir, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if ca == nil {
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestClient_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "rpcx test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	writeCert(t, dir, "server", ca, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert := func(id string) {
+		spiffeID, _ := url.Parse("spiffe://example.org/" + id)
+		writeCert(t, dir, "client", ca, caKey, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: id},
+			URIs:        []*url.URL{spiffeID},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+	}
+	clientCert("first")
+
+	serverCerts, err := share.NewCertReloader(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"), 0)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	defer serverCerts.Close()
+	clientCerts, err := share.NewCertReloader(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"), 0)
+	if err != nil {
+		t.Fatalf("failed to load client certificate: %v", err)
+	}
+	defer clientCerts.Close()
+
+	s := server.NewServer(server.WithCertReloader(serverCerts))
+	s.RegisterName("Whoami", new(Whoami), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	// no name is sent to servers dialed by IP address, they are verified against ServerName
+	named := &tls.Config{ServerName: "127.0.0.1"}
+	call := func() (string, error) {
+		opt := DefaultOption
+		opt.TLSConfig = clientCerts.ClientConfig(named)
+		client := &Client{option: opt}
+		if err := client.Connect("tcp", s.Address().String()); err != nil {
+			return "", err
+		}
+		defer client.Close()
+		var reply string
+		err := client.Call(context.Background(), "Whoami", "SPIFFEID", &Args{}, &reply)
+		return reply, err
+	}
+
+	if id, err := call(); err != nil || id != "spiffe://example.org/first" {
+		t.Fatalf("expect spiffe://example.org/first but got %q, %v", id, err)
+	}
+
+	// the server is not verified without a name or with another one
+	for _, base := range []*tls.Config{nil, {ServerName: "example.org"}} {
+		opt := DefaultOption
+		opt.TLSConfig = clientCerts.ClientConfig(base)
+		client := &Client{option: opt}
+		if err := client.Connect("tcp", s.Address().String()); err == nil {
+			client.Close()
+			t.Fatalf("expect an error with ServerName %q", opt.TLSConfig.ServerName)
+		}
+	}
+
+	// the rotated certificate is used by new connections without a restart
+	clientCert("second")
+	if err := clientCerts.Reload(); err != nil {
+		t.Fatalf("failed to reload client certificate: %v", err)
+	}
+	if id, err := call(); err != nil || id != "spiffe://example.org/second" {
+		t.Fatalf("expect spiffe://example.org/second but got %q, %v", id, err)
+	}
+
+	// the gateway and JSON-RPC requests on the same port have the identity too
+	post := func(rt http.RoundTripper, url string, header http.Header, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := (&http.Client{Transport: rt, Timeout: 5 * time.Second}).Do(req)
+		if err != nil {
+			t.Fatalf("failed to post to %s: %v", url, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+	// cmux dispatches connections, not requests, so every request has its own connection
+	https := &http.Transport{TLSClientConfig: clientCerts.ClientConfig(named), DisableKeepAlives: true}
+	gatewayHeader := http.Header{
+		"X-Rpcx-Servicepath":   {"Whoami"},
+		"X-Rpcx-Servicemethod": {"SPIFFEID"},
+		"X-Rpcx-Serializetype": {"1"},
+	}
+	if resp, body := post(https, "https://"+s.Address().String()+"/", gatewayHeader, "{}"); body != `"spiffe://example.org/second"` {
+		t.Fatalf("expect the identity in the gateway but got %q, %v", body, resp.Header)
+	}
+	jsonrpcHeader := http.Header{"X-Jsonrpc-2.0": {"true"}}
+	if _, body := post(https, "https://"+s.Address().String()+"/", jsonrpcHeader,
+		`{"jsonrpc": "2.0", "id": 1, "method": "Whoami.SPIFFEID", "params": {}}`); !strings.Contains(body, `"result":"spiffe://example.org/second"`) {
+		t.Fatalf("expect the identity in JSON-RPC but got %s", body)
+	}
+
+	// the http2 listener serves TLS only with WithHTTP2TLS, and offers h2 to clients with certificates
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	s2 := server.NewServer(server.WithCertReloader(serverCerts), server.WithHTTP2TLS())
+	s2.RegisterName("Whoami", new(Whoami), "")
+	go s2.ServeHttp2("http2", addr)
+	time.Sleep(500 * time.Millisecond)
+
+	h2 := &http2.Transport{TLSClientConfig: clientCerts.ClientConfig(named)}
+	defer h2.CloseIdleConnections()
+	resp, body := post(h2, "https://"+addr+"/", gatewayHeader, "{}")
+	if resp.ProtoMajor != 2 || body != `"spiffe://example.org/second"` {
+		t.Fatalf("expect the identity over h2 but got %s %q", resp.Proto, body)
+	}
+}
+
+type denyPlugin struct{}
+
+func (denyPlugin) Authorize(ctx context.Context, req *protocol.Message) error {
+	if req.ServiceMethod == "Mul" {
+		return rerrors.New(rerrors.PermissionDenied, "rpcx: permission denied")
+	}
+	return nil
+}
+
+func TestClient_Authorize(t *testing.T) {
+	s := server.NewServer()
+	s.Plugins.Add(denyPlugin{})
+	s.RegisterName("Echo", new(Echo), "")
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.PermissionDenied {
+		t.Fatalf("expect a PermissionDenied error but got %v", err)
+	}
+
+	// denied calls do not close the connection
+	arg, reply := "hello", ""
+	if err := client.Call(context.Background(), "Echo", "Echo", &arg, &reply); err != nil || reply != arg {
+		t.Fatalf("expect %q but got %q, %v", arg, reply, err)
+	}
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	s := server.NewServer()
+	s.Plugins.Add(serverplugin.NewRequestRateLimitingPlugin(&serverplugin.RateLimitRule{
+		Service: "Arith",
+		Key:     serverplugin.RateLimitByClientIP,
+		Limiter: serverplugin.NewTokenBucketLimiter(1, 2),
+	}))
+	s.RegisterName("Echo", new(Echo), "")
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{}); err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+	}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.ResourceExhausted {
+		t.Fatalf("expect a ResourceExhausted error but got %v", err)
+	}
+	if delay, ok := rerrors.RetryDelay(err); !ok || delay <= 0 || delay > time.Second {
+		t.Fatalf("expect a retry delay of up to 1s but got %v", delay)
+	}
+
+	// limited calls do not close the connection, other services are not limited
+	arg, reply := "hello", ""
+	if err := client.Call(context.Background(), "Echo", "Echo", &arg, &reply); err != nil || reply != arg {
+		t.Fatalf("expect %q but got %q, %v", arg, reply, err)
+	}
+}
+
+func TestClient_RateLimitedByPrincipal(t *testing.T) {
+	s := server.NewServer()
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		principal, _, ok := strings.Cut(token, "-")
+		if !ok {
+			return errors.New("invalid token")
+		}
+		ctx.(*share.Context).SetValue("principal", principal)
+		return nil
+	}
+	s.Plugins.Add(serverplugin.NewRequestRateLimitingPlugin(&serverplugin.RateLimitRule{
+		Key: func(ctx context.Context, r *protocol.Message) string {
+			principal, _ := ctx.Value("principal").(string)
+			return principal
+		},
+		Limiter: serverplugin.NewTokenBucketLimiter(1, 1),
+	}))
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// requests are limited once they have been authenticated, so new tokens of a caller share its limit
+	call := func(token string) error {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.AuthKey: token})
+		return client.Call(ctx, "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{})
+	}
+	if err := call("alice-1"); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if err := call("alice-2"); rerrors.CodeOf(err) != rerrors.ResourceExhausted {
+		t.Fatalf("expect a ResourceExhausted error but got %v", err)
+	}
+	if err := call("bob-1"); err != nil {
+		t.Fatalf("expect another caller to have its own limit but got %v", err)
+	}
+}
@@ -70,6 +70,13 @@ func (c *Client) Connect(network, address string) error {
 		//logs.Debugf("len: client.r 1 ---------------------  %+v", c.r)
 		//c.w = bufio.NewWriterSize(conn, WriterBuffsize)
 
+		if c.option.Handshake {
+			if err = c.handshake(); err != nil {
+				conn.Close()
+				return err
+			}
+		}
+
 		// start reading and writing since connected
 		go c.input()
 
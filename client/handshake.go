@@ -0,0 +1,137 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+var (
+	// ErrHandshakeFailed the server has rejected the handshake.
+	ErrHandshakeFailed = errors.New("handshake failed")
+	// ErrProtocolVersion the server has sent a message of a protocol version that has not been agreed.
+	ErrProtocolVersion = errors.New("protocol version has not been agreed")
+)
+
+// FeatureError is returned when something needs a feature that has not been agreed with the server.
+type FeatureError struct {
+	Feature string
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("feature %s has not been agreed with the server", e.Feature)
+}
+
+// Is reports ErrStreamNotSupported for streams so that existing checks still work.
+func (e *FeatureError) Is(target error) bool {
+	return target == ErrStreamNotSupported && e.Feature == protocol.FeatureStream
+}
+
+// handshake negotiates the protocol version, compressors, serializers and features with the server.
+// It is sent as a heartbeat, servers without handshake support echo it and are used as before.
+// It must be called before the input goroutine is started.
+func (client *Client) handshake() error {
+	local := protocol.NewHandshake(share.SupportedSerializers())
+
+	client.mutex.Lock()
+	seq := client.seq
+	client.seq++
+	client.mutex.Unlock()
+
+	req := protocol.GetPooledMsg()
+	req.SetMessageType(protocol.Request)
+	req.SetSeq(seq)
+	req.SetHeartbeat(true)
+	req.SetFrameType(protocol.FrameHandshake)
+	req.Metadata = local.Metadata()
+	_, err := req.EncodeTo(client.Conn)
+	protocol.FreeMsg(req)
+	if err != nil {
+		return err
+	}
+
+	if d := client.option.ConnectTimeout; d > 0 {
+		client.Conn.SetReadDeadline(time.Now().Add(d))
+		defer client.Conn.SetReadDeadline(time.Time{})
+	}
+	res := protocol.NewMessage()
+	if err = res.DecodeWithLimits(client.r, &client.option.DecodeLimits); err != nil {
+		return err
+	}
+	if res.MessageStatusType() == protocol.Error {
+		return fmt.Errorf("%w: %s", ErrHandshakeFailed, res.Metadata[protocol.ServiceError])
+	}
+
+	agreed, err := protocol.HandshakeFromMetadata(res.Metadata)
+	if err != nil {
+		return err
+	}
+	if !agreed.Agreed {
+		// the server does not support the handshake, so nothing has been agreed.
+		agreed = &protocol.Handshake{}
+	}
+
+	client.mutex.Lock()
+	client.negotiated = agreed
+	client.mutex.Unlock()
+	return nil
+}
+
+// Negotiated returns what has been agreed with the server in the handshake.
+// It returns nil if Option.Handshake is not set. If the server does not support the handshake,
+// Agreed is false and nothing has been agreed but version 0.
+func (client *Client) Negotiated() *protocol.Handshake {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.negotiated
+}
+
+// checkNegotiated returns an error if the options of client have not been agreed with the server.
+// It must be called with client.mutex held.
+func (client *Client) checkNegotiated() error {
+	agreed := client.negotiated
+	if agreed == nil {
+		return nil
+	}
+	if client.option.Checksum && !agreed.HasFeature(protocol.FeatureChecksum) {
+		return &FeatureError{Feature: protocol.FeatureChecksum}
+	}
+	if !agreed.Agreed {
+		// servers without handshake support are used as before.
+		return nil
+	}
+	if !agreed.SupportsSerializer(client.option.SerializeType) {
+		return fmt.Errorf("%w: serializer %d is not supported by the server", ErrUnsupportedCodec, client.option.SerializeType)
+	}
+	if client.option.CompressType != protocol.None && !agreed.SupportsCompressor(client.option.CompressType) {
+		return fmt.Errorf("rpcx: compressor %d is not supported by the server", client.option.CompressType)
+	}
+	return nil
+}
+
+// hasFeature returns whether the server supports feature.
+// Without Option.Handshake the server is assumed to support it, otherwise it must have been agreed.
+func (client *Client) hasFeature(feature string) bool {
+	agreed := client.Negotiated()
+	return agreed == nil || agreed.HasFeature(feature)
+}
+
+// version returns the protocol version agreed with the server, 0 without handshake.
+func (client *Client) version() byte {
+	agreed := client.Negotiated()
+	if agreed == nil {
+		return 0
+	}
+	return agreed.Version
+}
+
+// checkVersion returns an error if res has not been sent with the agreed protocol version.
+func (client *Client) checkVersion(res *protocol.Message) error {
+	if res.MessageType() == protocol.Response && res.Version() != client.version() {
+		return fmt.Errorf("%w: got version %d", ErrProtocolVersion, res.Version())
+	}
+	return nil
+}
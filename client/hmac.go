@@ -0,0 +1,79 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// HMACSignPlugin signs requests with HMAC-SHA256 so that servers can verify them
+// with serverplugin.HMACVerifyPlugin even when TLS ends at a proxy.
+// The signature covers the service path and method, a timestamp, a nonce, the payload digest
+// and the metadata listed in Metadata, see share.SignatureBase.
+// Heartbeats and the frames that follow the first frame of a call are not signed.
+type HMACSignPlugin struct {
+	// Metadata are the keys of the metadata to sign, when requests have them.
+	Metadata []string
+
+	mu     sync.RWMutex
+	keyID  string
+	secret []byte
+}
+
+// NewHMACSignPlugin returns a HMACSignPlugin that signs with secret, known as keyID by servers.
+func NewHMACSignPlugin(keyID string, secret []byte, metadata ...string) *HMACSignPlugin {
+	return &HMACSignPlugin{Metadata: metadata, keyID: keyID, secret: secret}
+}
+
+// SetKey replaces the signing key, e.g. to rotate it once servers know the new key.
+func (p *HMACSignPlugin) SetKey(keyID string, secret []byte) {
+	p.mu.Lock()
+	p.keyID, p.secret = keyID, secret
+	p.mu.Unlock()
+}
+
+// ClientBeforeEncode signs req.
+func (p *HMACSignPlugin) ClientBeforeEncode(req *protocol.Message) error {
+	if req.IsHeartbeat() || (req.FrameType() != protocol.FrameNormal && req.FrameType() != protocol.FrameStreamOpen) {
+		return nil
+	}
+
+	p.mu.RLock()
+	keyID, secret := p.keyID, p.secret
+	p.mu.RUnlock()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// the metadata may be shared with the caller, so it is copied
+	meta := make(map[string]string, len(req.Metadata)+5)
+	for k, v := range req.Metadata {
+		meta[k] = v
+	}
+	var signed []string
+	for _, k := range p.Metadata {
+		if _, ok := meta[k]; ok && !strings.Contains(k, ",") {
+			signed = append(signed, k)
+		}
+	}
+	sort.Strings(signed)
+
+	meta[share.SignatureKeyIDKey] = keyID
+	meta[share.SignatureTimestampKey] = strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	meta[share.SignatureNonceKey] = hex.EncodeToString(nonce)
+	meta[share.SignatureMetadataKey] = strings.Join(signed, ",")
+	delete(meta, share.SignatureKey)
+	req.Metadata = meta
+
+	meta[share.SignatureKey] = share.Sign(secret, share.SignatureBase(req))
+	return nil
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/server"
+	"github.com/halokid/rpcx-plus/serverplugin"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+func TestClient_HMACSignature(t *testing.T) {
+	verifier := serverplugin.NewHMACVerifyPlugin(map[string][]byte{"k1": []byte("secret1")}, 0)
+	s := server.NewServer()
+	s.Plugins.Add(verifier)
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{option: DefaultOption}
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	args := &Args{A: 10, B: 20}
+	err := client.Call(context.Background(), "Arith", "Mul", args, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.Unauthenticated {
+		t.Fatalf("expect an Unauthenticated error for an unsigned request but got %v", err)
+	}
+
+	// the connection is kept after a rejected request
+	signer := NewHMACSignPlugin("k1", []byte("secret1"), "tenant")
+	client.Plugins = NewPluginContainer()
+	client.Plugins.Add(signer)
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": "acme"})
+	reply := &Reply{}
+	if err := client.Call(ctx, "Arith", "Mul", args, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+
+	// rotate the key
+	verifier.SetKey("k2", []byte("secret2"))
+	signer.SetKey("k2", []byte("secret2"))
+	verifier.RemoveKey("k1")
+	if err := client.Call(ctx, "Arith", "Mul", args, &Reply{}); err != nil {
+		t.Fatalf("failed to call with the new key: %v", err)
+	}
+}
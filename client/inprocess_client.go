@@ -70,7 +70,10 @@ func (client *inprocessClient) Go(ctx context.Context, servicePath, serviceMetho
 	call.Done = done
 	err := client.Call(ctx, servicePath, serviceMethod, args, reply)
 	if err != nil {
-		call.Error = ServiceError(err.Error())
+		call.Error = err
+		if !isServiceError(err) {
+			call.Error = ServiceError(err.Error())
+		}
 
 	}
 	call.done()
@@ -206,3 +209,7 @@ func (client *inprocessClient) Unregister(name string) error {
 	client.Unlock()
 	return nil
 }
+
+func (client *inprocessClient) NewStream(ctx context.Context, servicePath, serviceMethod string, args interface{}) (*Stream, error) {
+	return nil, ErrStreamNotSupported
+}
@@ -28,6 +28,8 @@ type OneClient struct {
 	latitude  float64
 	longitude float64
 	auth      string
+	// tokenSource is set on the xclients of all services.
+	tokenSource TokenSource
 
 	serverMessageChan chan<- *protocol.Message
 }
@@ -105,6 +107,16 @@ func (c *OneClient) Auth(auth string) {
 	c.mu.RUnlock()
 }
 
+// SetTokenSource sets the source of the auth tokens of all services, it replaces the token of Auth.
+func (c *OneClient) SetTokenSource(ts TokenSource) {
+	c.tokenSource = ts
+	c.mu.RLock()
+	for _, v := range c.xclients {
+		v.SetTokenSource(ts)
+	}
+	c.mu.RUnlock()
+}
+
 // Go invokes the function asynchronously. It returns the Call structure representing the invocation. The done channel will signal when the call is complete by returning the same Call object. If done is nil, Go will allocate a new channel. If non-nil, done must be buffered or Go will deliberately crash.
 // It does not use FailMode.
 func (c *OneClient) Go(ctx context.Context, servicePath string, serviceMethod string, args interface{}, reply interface{}, done chan *Call) (*Call, error) {
@@ -161,6 +173,9 @@ func (c *OneClient) newXClient(servicePath string) (xclient XClient, err error)
 	if c.auth != "" {
 		xclient.Auth(c.auth)
 	}
+	if c.tokenSource != nil {
+		xclient.SetTokenSource(c.tokenSource)
+	}
 
 	return xclient, err
 }
@@ -189,6 +204,29 @@ func (c *OneClient) Call(ctx context.Context, servicePath string, serviceMethod
 	return xclient.Call(ctx, serviceMethod, args, reply)
 }
 
+// NewStream opens a stream to servicePath.serviceMethod.
+func (c *OneClient) NewStream(ctx context.Context, servicePath string, serviceMethod string, args interface{}) (*Stream, error) {
+	c.mu.RLock()
+	xclient := c.xclients[servicePath]
+	c.mu.RUnlock()
+
+	if xclient == nil {
+		var err error
+		c.mu.Lock()
+		xclient = c.xclients[servicePath]
+		if xclient == nil {
+			xclient, err = c.newXClient(servicePath)
+			c.xclients[servicePath] = xclient
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return xclient.NewStream(ctx, serviceMethod, args)
+}
+
 func (c *OneClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
 	servicePath := r.ServicePath
 
@@ -0,0 +1,376 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/halokid/rpcx-plus/codec"
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// ErrStreamNotSupported streams can only be opened on rpcx connections.
+var ErrStreamNotSupported = errors.New("streams are not supported by this client")
+
+// Stream is the client side of a streaming call.
+// Recv reads the messages sent by the server and returns io.EOF when the server method has returned.
+// Send and CloseSend are used by bidirectional streams.
+type Stream struct {
+	client *Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	seq           uint64
+	servicePath   string
+	serviceMethod string
+	codec         codec.Codec
+
+	// opened receives the result of opening the stream.
+	opened chan error
+	// recvCh buffers the data frames of the server, its capacity is the receive window.
+	recvCh   chan *protocol.Message
+	window   uint32
+	consumed uint32
+	credit   *protocol.StreamCredit
+
+	mu          sync.Mutex
+	finished    chan struct{}
+	done        bool
+	sendClosed  bool
+	recvErr     error
+	resMetadata map[string]string
+}
+
+// NewStream opens a stream to servicePath.serviceMethod with args.
+// The stream is cancelled when ctx is done or Close is called.
+func (client *Client) NewStream(ctx context.Context, servicePath, serviceMethod string, args interface{}) (*Stream, error) {
+	if client.option.Http2 || client.option.Http {
+		return nil, ErrStreamNotSupported
+	}
+	if !client.hasFeature(protocol.FeatureStream) {
+		return nil, &FeatureError{Feature: protocol.FeatureStream}
+	}
+
+	codec := share.Codecs[client.option.SerializeType]
+	if codec == nil {
+		return nil, ErrUnsupportedCodec
+	}
+	data, err := codec.Encode(args)
+	if err != nil {
+		return nil, err
+	}
+
+	window := client.option.StreamWindow
+	if window == 0 {
+		window = protocol.DefaultStreamWindow
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	st := &Stream{
+		client:        client,
+		ctx:           sctx,
+		cancel:        cancel,
+		servicePath:   servicePath,
+		serviceMethod: serviceMethod,
+		codec:         codec,
+		opened:        make(chan error, 1),
+		recvCh:        make(chan *protocol.Message, window),
+		window:        window,
+		finished:      make(chan struct{}),
+	}
+
+	client.mutex.Lock()
+	if client.shutdown || client.closing {
+		client.mutex.Unlock()
+		cancel()
+		return nil, ErrShutdown
+	}
+	if err := client.checkNegotiated(); err != nil {
+		client.mutex.Unlock()
+		cancel()
+		return nil, err
+	}
+	if client.streams == nil {
+		client.streams = make(map[uint64]*Stream)
+	}
+	st.seq = client.seq
+	client.seq++
+	client.streams[st.seq] = st
+	client.mutex.Unlock()
+
+	metadata := make(map[string]string)
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		for k, v := range meta {
+			metadata[k] = v
+		}
+	}
+	metadata[protocol.StreamWindowKey] = strconv.FormatUint(uint64(window), 10)
+
+	err = st.writeFrame(protocol.FrameStreamOpen, data, metadata)
+	if err != nil {
+		client.removeStream(st.seq)
+		st.finish(err, nil)
+		return nil, err
+	}
+
+	select {
+	case err = <-st.opened:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		st.abort(err)
+		return nil, err
+	}
+
+	go st.watch()
+	return st, nil
+}
+
+// Context returns the context of this stream.
+func (st *Stream) Context() context.Context {
+	return st.ctx
+}
+
+// ResMetadata returns the metadata the server sent when it closed the stream.
+// It is only available after Recv has returned io.EOF.
+func (st *Stream) ResMetadata() map[string]string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.resMetadata
+}
+
+// Send sends v to the server. It blocks when the server has not granted enough window.
+func (st *Stream) Send(v interface{}) error {
+	st.mu.Lock()
+	done, sendClosed := st.done, st.sendClosed
+	st.mu.Unlock()
+	if done || sendClosed {
+		return protocol.ErrStreamClosed
+	}
+
+	err := st.credit.Acquire(st.ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := st.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return st.writeFrame(protocol.FrameStreamData, data, nil)
+}
+
+// CloseSend closes the sending side of the stream. The server receives io.EOF.
+func (st *Stream) CloseSend() error {
+	st.mu.Lock()
+	if st.done || st.sendClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.sendClosed = true
+	st.mu.Unlock()
+
+	return st.writeFrame(protocol.FrameStreamClose, nil, nil)
+}
+
+// Recv receives the next message of the server into v.
+// It returns io.EOF when the stream has ended normally, or the error of the server method.
+func (st *Stream) Recv(v interface{}) error {
+	msg, ok := <-st.recvCh
+	if !ok {
+		st.mu.Lock()
+		err := st.recvErr
+		st.mu.Unlock()
+		return err
+	}
+
+	var err error
+	codec := share.Codecs[msg.SerializeType()]
+	if codec == nil {
+		err = ErrUnsupportedCodec
+	} else {
+		err = codec.Decode(msg.Payload, v)
+	}
+	protocol.FreeMsg(msg)
+	st.release()
+	return err
+}
+
+// Close cancels the stream. The server method sees its context cancelled.
+func (st *Stream) Close() error {
+	st.abort(protocol.ErrStreamClosed)
+	return nil
+}
+
+// watch cancels the stream on the server when ctx is done.
+func (st *Stream) watch() {
+	select {
+	case <-st.ctx.Done():
+		st.abort(st.ctx.Err())
+	case <-st.finished:
+	}
+}
+
+// abort sends a cancel frame and ends the stream with err.
+func (st *Stream) abort(err error) {
+	st.mu.Lock()
+	done := st.done
+	st.mu.Unlock()
+	if done {
+		return
+	}
+
+	st.client.removeStream(st.seq)
+	if werr := st.writeFrame(protocol.FrameCancel, nil, nil); werr != nil {
+		logs.Warnf("rpcx: failed to cancel stream %s.%s: %v", st.servicePath, st.serviceMethod, werr)
+	}
+	st.finish(err, nil)
+}
+
+// finish ends the stream. Recv returns err after the buffered messages have been read.
+func (st *Stream) finish(err error, metadata map[string]string) {
+	st.mu.Lock()
+	if st.done {
+		st.mu.Unlock()
+		return
+	}
+	st.done = true
+	st.recvErr = err
+	st.resMetadata = metadata
+	close(st.recvCh)
+	close(st.finished)
+	st.mu.Unlock()
+
+	select {
+	case st.opened <- err:
+	default:
+	}
+	st.cancel()
+}
+
+// release grants the consumed window back to the server once half of it has been used.
+func (st *Stream) release() {
+	n := atomic.AddUint32(&st.consumed, 1)
+	threshold := st.window / 2
+	if threshold == 0 {
+		threshold = 1
+	}
+	if n < threshold {
+		return
+	}
+	if atomic.CompareAndSwapUint32(&st.consumed, n, 0) {
+		st.mu.Lock()
+		done := st.done
+		st.mu.Unlock()
+		if !done {
+			st.writeFrame(protocol.FrameStreamWindow, protocol.EncodeWindow(n), nil)
+		}
+	}
+}
+
+// push buffers a data frame of the server.
+func (st *Stream) push(msg *protocol.Message) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.done {
+		protocol.FreeMsg(msg)
+		return
+	}
+	select {
+	case st.recvCh <- msg:
+	default:
+		protocol.FreeMsg(msg)
+		logs.Warnf("rpcx: stream %s.%s (%d) exceeds the flow control window", st.servicePath, st.serviceMethod, st.seq)
+		go st.abort(protocol.ErrStreamWindowExceeded)
+	}
+}
+
+func (st *Stream) writeFrame(ft protocol.FrameType, payload []byte, metadata map[string]string) error {
+	client := st.client
+
+	req := protocol.GetPooledMsg()
+	req.SetMessageType(protocol.Request)
+	req.SetVersion(client.version())
+	req.SetSeq(st.seq)
+	req.SetSerializeType(client.option.SerializeType)
+	req.SetFrameType(ft)
+	req.SetChecksum(client.useChecksum())
+	req.ServicePath = st.servicePath
+	req.ServiceMethod = st.serviceMethod
+	req.Metadata = metadata
+	req.Payload = payload
+	if len(payload) > client.compressThreshold() && client.option.CompressType != protocol.None {
+		req.SetCompressType(client.option.CompressType)
+	}
+
+	if client.Plugins != nil {
+		client.Plugins.DoClientBeforeEncode(req)
+	}
+	_, err := req.EncodeTo(client.Conn)
+	protocol.FreeMsg(req)
+	return err
+}
+
+func (client *Client) removeStream(seq uint64) {
+	client.mutex.Lock()
+	delete(client.streams, seq)
+	client.mutex.Unlock()
+}
+
+// handleStreamFrame dispatches the stream frames sent by the server.
+func (client *Client) handleStreamFrame(res *protocol.Message) {
+	client.mutex.Lock()
+	st := client.streams[res.Seq()]
+	client.mutex.Unlock()
+	if st == nil {
+		return
+	}
+
+	switch res.FrameType() {
+	case protocol.FrameStreamOpen:
+		if res.MessageStatusType() == protocol.Error {
+			client.removeStream(st.seq)
+			st.finish(serviceError(res.Metadata), res.Metadata)
+			return
+		}
+		st.credit = protocol.NewStreamCredit(protocol.WindowFromMetadata(res.Metadata))
+		select {
+		case st.opened <- nil:
+		default:
+		}
+	case protocol.FrameStreamData:
+		st.push(res)
+	case protocol.FrameStreamClose:
+		client.removeStream(st.seq)
+		if res.MessageStatusType() == protocol.Error {
+			st.finish(serviceError(res.Metadata), res.Metadata)
+		} else {
+			st.finish(io.EOF, res.Metadata)
+		}
+	case protocol.FrameStreamWindow:
+		if st.credit != nil {
+			st.credit.Grant(protocol.DecodeWindow(res.Payload))
+		}
+	case protocol.FrameCancel:
+		client.removeStream(st.seq)
+		st.finish(context.Canceled, nil)
+	}
+}
+
+// failStreams ends all open streams when the connection is closed.
+func (client *Client) failStreams(err error) {
+	client.mutex.Lock()
+	streams := client.streams
+	client.streams = nil
+	client.mutex.Unlock()
+
+	for _, st := range streams {
+		st.finish(err, nil)
+	}
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/server"
+)
+
+type Counter int
+
+func (t *Counter) Count(ctx context.Context, args *Args, stream *server.Stream) error {
+	for i := 0; i < args.A; i++ {
+		if err := stream.Send(&Reply{C: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Counter) Echo(ctx context.Context, args *Args, stream *server.Stream) error {
+	for {
+		var a Args
+		err := stream.Recv(&a)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(&Reply{C: a.A * a.B}); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Counter) Fail(ctx context.Context, args *Args, stream *server.Stream) error {
+	return errors.New("stream failed")
+}
+
+func (t *Counter) Wait(ctx context.Context, args *Args, stream *server.Stream) error {
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+func TestClient_Stream(t *testing.T) {
+	s := server.NewServer(server.WithStreamWindow(4))
+	s.RegisterName("Counter", new(Counter), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	client.option.StreamWindow = 2
+
+	err := client.Connect("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// server streaming, more messages than the window.
+	stream, err := client.NewStream(context.Background(), "Counter", "Count", &Args{A: 100})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		reply := &Reply{}
+		if err = stream.Recv(reply); err != nil {
+			t.Fatalf("failed to recv: %v", err)
+		}
+		if reply.C != i {
+			t.Fatalf("expect %d but got %d", i, reply.C)
+		}
+	}
+	if err = stream.Recv(&Reply{}); err != io.EOF {
+		t.Fatalf("expect io.EOF but got %v", err)
+	}
+
+	// bidirectional
+	stream, err = client.NewStream(context.Background(), "Counter", "Echo", &Args{})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err = stream.Send(&Args{A: i, B: 2}); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+		reply := &Reply{}
+		if err = stream.Recv(reply); err != nil {
+			t.Fatalf("failed to recv: %v", err)
+		}
+		if reply.C != i*2 {
+			t.Fatalf("expect %d but got %d", i*2, reply.C)
+		}
+	}
+	stream.CloseSend()
+	if err = stream.Recv(&Reply{}); err != io.EOF {
+		t.Fatalf("expect io.EOF but got %v", err)
+	}
+
+	// errors of the server method
+	stream, err = client.NewStream(context.Background(), "Counter", "Fail", &Args{})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	err = stream.Recv(&Reply{})
+	if _, ok := err.(ServiceError); !ok || err.Error() != "stream failed" {
+		t.Fatalf("expect ServiceError but got %v", err)
+	}
+
+	_, err = client.NewStream(context.Background(), "Counter", "Missing", &Args{})
+	if rerrors.CodeOf(err) != rerrors.Unimplemented {
+		t.Fatalf("expect Unimplemented but got %v", err)
+	}
+
+	// cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = client.NewStream(ctx, "Counter", "Wait", &Args{})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	cancel()
+	if err = stream.Recv(&Reply{}); err != context.Canceled {
+		t.Fatalf("expect context.Canceled but got %v", err)
+	}
+
+	// the connection can still be used for normal calls.
+	s.RegisterName("Arith", new(Arith), "")
+	reply := &Reply{}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+}
+
+func TestStream_HealthWatch(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Counter", new(Counter), "")
+	h, err := s.RegisterHealth()
+	if err != nil {
+		t.Fatalf("failed to register health: %v", err)
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err = client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	stream, err := client.NewStream(context.Background(), server.HealthServicePath, "Watch", &server.HealthCheckRequest{Service: "Counter"})
+	if err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+	defer stream.Close()
+
+	res := &server.HealthCheckResponse{}
+	if err = stream.Recv(res); err != nil || res.Status != server.StatusServing {
+		t.Fatalf("expect SERVING but got %v, %v", res.Status, err)
+	}
+
+	h.SetServingStatus("Counter", server.StatusNotServing)
+	if err = stream.Recv(res); err != nil || res.Status != server.StatusNotServing {
+		t.Fatalf("expect NOT_SERVING but got %v, %v", res.Status, err)
+	}
+}
+
+func TestStream_Limits(t *testing.T) {
+	deny := func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}, next server.Handler) error {
+		if _, ok := reply.(*server.Stream); !ok {
+			return errors.New("expect a *server.Stream")
+		}
+		if args.(*Args).B == 1 {
+			return rerrors.New(rerrors.PermissionDenied, "denied")
+		}
+		return next(ctx, args, reply)
+	}
+	s := server.NewServer(server.WithServiceMaxInflight("Counter", 1), server.WithInterceptors(deny))
+	s.RegisterName("Counter", new(Counter), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	client := &Client{
+		option: DefaultOption,
+	}
+	err := client.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// streams run through the interceptors
+	stream, err := client.NewStream(context.Background(), "Counter", "Count", &Args{A: 1, B: 1})
+	if err == nil {
+		err = stream.Recv(&Reply{})
+	}
+	if rerrors.CodeOf(err) != rerrors.PermissionDenied {
+		t.Fatalf("expect PermissionDenied but got %v", err)
+	}
+
+	// open streams count against the in-flight limits
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = client.NewStream(ctx, "Counter", "Wait", &Args{})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	_, err = client.NewStream(context.Background(), "Counter", "Wait", &Args{})
+	if rerrors.CodeOf(err) != rerrors.ResourceExhausted {
+		t.Fatalf("expect ResourceExhausted but got %v", err)
+	}
+	cancel()
+	stream.Recv(&Reply{})
+	time.Sleep(100 * time.Millisecond)
+
+	stream, err = client.NewStream(context.Background(), "Counter", "Count", &Args{A: 1})
+	if err != nil {
+		t.Fatalf("failed to open stream after the other has ended: %v", err)
+	}
+	if err = stream.Recv(&Reply{}); err != nil {
+		t.Fatalf("failed to recv: %v", err)
+	}
+}
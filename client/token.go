@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies the auth tokens of an XClient.
+// Token is called for every request so that sources can refresh their tokens before they expire.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc is a function used as a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+// Token returns f(ctx).
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// cachedTokenSource caches a token until it is about to expire.
+type cachedTokenSource struct {
+	fetch         func(ctx context.Context) (string, time.Time, error)
+	refreshBefore time.Duration
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewCachedTokenSource returns a TokenSource that caches the tokens of fetch
+// and fetches a new one refreshBefore the cached one expires.
+// If fetch returns a zero expiry, the exp claim is used if the token is a JWT,
+// otherwise the token is cached forever.
+func NewCachedTokenSource(fetch func(ctx context.Context) (token string, expiry time.Time, err error), refreshBefore time.Duration) TokenSource {
+	return &cachedTokenSource{fetch: fetch, refreshBefore: refreshBefore}
+}
+
+func (s *cachedTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || time.Now().Add(s.refreshBefore).Before(s.expiry)) {
+		return s.token, nil
+	}
+
+	token, expiry, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	if expiry.IsZero() {
+		expiry = jwtExpiry(token)
+	}
+	s.token, s.expiry = token, expiry
+	return token, nil
+}
+
+// jwtExpiry returns the exp claim of the JWT token, without verifying it,
+// or the zero time if token is not a JWT with an exp claim.
+func jwtExpiry(token string) time.Time {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return time.Time{}
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil || exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(exp), 0)
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/server"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+func TestCachedTokenSource(t *testing.T) {
+	var fetches int32
+	expiry := time.Now().Add(time.Hour)
+	ts := NewCachedTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		return fmt.Sprintf("token-%d", n), expiry, nil
+	}, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		token, err := ts.Token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get token: %v", err)
+		}
+		if token != "token-1" {
+			t.Fatalf("expect the cached token-1 but got %s", token)
+		}
+	}
+
+	// the token is refreshed before it expires
+	expiry = time.Now().Add(30 * time.Second)
+	ts.(*cachedTokenSource).expiry = expiry
+	if token, _ := ts.Token(context.Background()); token != "token-2" {
+		t.Fatalf("expect a refreshed token-2 but got %s", token)
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","exp":1700000000}`))
+	token := "eyJhbGciOiJIUzI1NiJ9." + payload + ".c2ln"
+	if exp := jwtExpiry("Bearer " + token); !exp.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("expect the exp claim but got %v", exp)
+	}
+	if exp := jwtExpiry("opaque"); !exp.IsZero() {
+		t.Errorf("expect no expiry for an opaque token but got %v", exp)
+	}
+}
+
+type Principal int
+
+func (t *Principal) Whoami(ctx context.Context, args *Args, reply *string) error {
+	*reply, _ = ctx.Value("principal").(string)
+	return nil
+}
+
+func TestXClient_TokenSource(t *testing.T) {
+	s := server.NewServer()
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token != "Bearer secret" {
+			return errors.New("invalid token")
+		}
+		ctx.(*share.Context).SetValue("principal", "alice")
+		return nil
+	}
+	s.RegisterName("Principal", new(Principal), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	xclient := NewXClient("Principal", Failfast, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+
+	var token atomic.Value
+	token.Store("Bearer wrong")
+	xclient.SetTokenSource(TokenSourceFunc(func(ctx context.Context) (string, error) {
+		return token.Load().(string), nil
+	}))
+
+	var reply string
+	if err := xclient.Call(context.Background(), "Whoami", &Args{}, &reply); err == nil {
+		t.Fatalf("expect a call with a wrong token to fail")
+	}
+
+	token.Store("Bearer secret")
+	if err := xclient.Call(context.Background(), "Whoami", &Args{}, &reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply != "alice" {
+		t.Fatalf("expect alice but got %q", reply)
+	}
+
+	xclient.SetTokenSource(TokenSourceFunc(func(ctx context.Context) (string, error) {
+		return "", errors.New("token server is down")
+	}))
+	if err := xclient.Call(context.Background(), "Whoami", &Args{}, &reply); err == nil {
+		t.Fatalf("expect the error of the token source")
+	}
+}
@@ -7,6 +7,8 @@ xClient struct 定义为内部struct， imple方法并定义属性， 只能内
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	logs "github.com/halokid/rpcx-plus/log"
@@ -53,6 +55,7 @@ type XClient interface {
 	SetSelector(s Selector)
 	ConfigGeoSelector(latitude, longitude float64)
 	Auth(auth string)
+	SetTokenSource(ts TokenSource)
 
 	Go(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, done chan *Call) (*Call, error)
 
@@ -63,6 +66,7 @@ type XClient interface {
 	Broadcast(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
 	Fork(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
 	SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error)
+	NewStream(ctx context.Context, serviceMethod string, args interface{}) (*Stream, error)
 	SendFile(ctx context.Context, fileName string, rateInBytesPerSecond int64) error
 	DownloadFile(ctx context.Context, requestFileName string, saveTo io.Writer) error
 	Close() error
@@ -104,6 +108,8 @@ type xClient struct {
 	failMode     FailMode
 	selectMode   SelectMode
 	cachedClient map[string]RPCClient
+	// goingAway are the servers that have told the client they are shutting down.
+	goingAway    map[string]bool
 	breakers     sync.Map
 	servicePath  string
 	option       Option
@@ -117,6 +123,8 @@ type xClient struct {
 
 	// auth is a string for Authentication, for example, "Bearer mF_9.B5f-4.1JqM"
 	auth string
+	// tokenSource supplies the auth tokens instead of auth if it is set.
+	tokenSource TokenSource
 
 	Plugins PluginContainer
 
@@ -139,6 +147,7 @@ func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, di
 		discovery:    discovery,
 		servicePath:  servicePath,
 		cachedClient: make(map[string]RPCClient),
+		goingAway:    make(map[string]bool),
 		option:       option,
 	}
 	client.isGo = true
@@ -283,6 +292,7 @@ func NewBidirectionalXClient(servicePath string, failMode FailMode, selectMode S
 		discovery:         discovery,
 		servicePath:       servicePath,
 		cachedClient:      make(map[string]RPCClient),
+		goingAway:         make(map[string]bool),
 		option:            option,
 		serverMessageChan: serverMessageChan,
 	}
@@ -339,6 +349,60 @@ func (c *xClient) Auth(auth string) {
 	c.auth = auth
 }
 
+// SetTokenSource sets the source of the auth tokens, it replaces the token of Auth.
+// The token is asked for every request so that it can be refreshed before it expires.
+func (c *xClient) SetTokenSource(ts TokenSource) {
+	c.tokenSource = ts
+}
+
+// withAuth returns ctx with the auth token in the metadata of the request.
+func (c *xClient) withAuth(ctx context.Context) (context.Context, error) {
+	auth := c.auth
+	if c.tokenSource != nil {
+		token, err := c.tokenSource.Token(ctx)
+		if err != nil {
+			return ctx, err
+		}
+		auth = token
+	}
+	if auth == "" {
+		return ctx, nil
+	}
+
+	metadata := ctx.Value(share.ReqMetaDataKey)
+	if metadata == nil {
+		metadata = map[string]string{}
+		ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
+	}
+	m := metadata.(map[string]string)
+	m[share.AuthKey] = auth
+	return ctx, nil
+}
+
+// withIdempotencyKey returns ctx with an idempotency key in its request metadata if the option is set,
+// so that the retries of a call are run once by servers with an IdempotencyPlugin. A key set by the caller is kept.
+func (c *xClient) withIdempotencyKey(ctx context.Context) context.Context {
+	if !c.option.Idempotent {
+		return ctx
+	}
+	metadata, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	if metadata[share.IdempotencyKey] != "" {
+		return ctx
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return ctx
+	}
+	// the metadata of the caller may be used by other calls, so it is copied
+	m := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		m[k] = v
+	}
+	m[share.IdempotencyKey] = hex.EncodeToString(key)
+	return context.WithValue(ctx, share.ReqMetaDataKey, m)
+}
+
 // watch changes of service and update cached clients.
 // todo: just update c.servers, not watch register nodes data change here.
 // todo: watch the nodes change, check node is proxy or not, and change the isReverseProxy property
@@ -417,6 +481,34 @@ func filterByStateAndGroup(group string, servers map[string]string) {
 	}
 }
 
+// selectSerializer returns the serializer used with a server whose registry metadata is metadata.
+// It is option.SerializeType unless the server advertises that it does not support it,
+// then it is the first of option.FallbackSerializeTypes the server supports.
+func selectSerializer(option Option, metadata string) protocol.SerializeType {
+	supported := share.SerializersFromMetadata(metadata)
+	if supported == nil {
+		return option.SerializeType
+	}
+	has := func(st protocol.SerializeType) bool {
+		for _, s := range supported {
+			if s == st {
+				return true
+			}
+		}
+		return false
+	}
+
+	if has(option.SerializeType) {
+		return option.SerializeType
+	}
+	for _, st := range option.FallbackSerializeTypes {
+		if has(st) && share.Codecs[st] != nil {
+			return st
+		}
+	}
+	return option.SerializeType
+}
+
 // selects a client from candidates base on c.selectMode
 func (c *xClient) selectClient(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, RPCClient, error) {
 	//logs.Debug("selectClient ---------------")
@@ -428,10 +520,35 @@ func (c *xClient) selectClient(ctx context.Context, servicePath, serviceMethod s
 		return "", nil, ErrXClientNoServer
 	}
 	client, err := c.getCachedClient(k)
+	if err == ErrServerGoingAway {
+		return c.selectOtherClient(k)
+	}
 	client.SetHttp2SvcNode(k)			// set http2 service node
 	return k, client, err
 }
 
+// selectOtherClient selects a client of a server other than goingAway, which is shutting down.
+// The selector is not asked again because it may select goingAway again.
+func (c *xClient) selectOtherClient(goingAway string) (string, RPCClient, error) {
+	c.mu.Lock()
+	ks := make([]string, 0, len(c.servers))
+	for k := range c.servers {
+		if k != goingAway {
+			ks = append(ks, k)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, k := range ks {
+		client, err := c.getCachedClient(k)
+		if err == nil {
+			client.SetHttp2SvcNode(k)
+			return k, client, nil
+		}
+	}
+	return "", nil, ErrXClientNoServer
+}
+
 // todo: improve version of the selectCLient, if the select node is the same as previous, just
 // todo: select again
 func (c *xClient) selectClientNoRepeat(ctx context.Context, servicePath, serviceMethod string, args interface{}, previous string) (string, RPCClient, error) {
@@ -475,6 +592,9 @@ func (c *xClient) selectClientNoRepeat(ctx context.Context, servicePath, service
 	}
 
 	client, err := c.getCachedClient(k)
+	if err == ErrServerGoingAway {
+		return c.selectOtherClient(k)
+	}
 	client.SetHttp2SvcNode(k)			// set http2 service node
 	return k, client, err
 }
@@ -510,10 +630,17 @@ func (c *xClient) getCachedClient(k string) (RPCClient, error) {
 		if !client.IsClosing() && !client.IsShutdown() {
 			return client, nil			// todo: 命中cacheClient则返回
 		}
-		logs.Debugf("client IsClosing() or IsShutdown(), client的k和状态 --- k: %+v," +
+		if isGoingAway(client) {
+			// the server closes the connection once the calls in flight are finished,
+			// new calls are sent over a new connection if the server is still there.
+			delete(c.cachedClient, k)
+			c.goingAway[k] = true
+		} else {
+			logs.Debugf("client IsClosing() or IsShutdown(), client的k和状态 --- k: %+v," +
 			" IsClosing(): %+v,  IsShutdown(): %+v", k, client.IsClosing(), client.IsShutdown())
-		delete(c.cachedClient, k)
-		client.Close()
+			delete(c.cachedClient, k)
+			client.Close()
+		}
 	}
 
 	// todo:  k不命中， 则client 为 nil, 这里更多的价值只是声明client类型
@@ -528,8 +655,10 @@ func (c *xClient) getCachedClient(k string) (RPCClient, error) {
 		} else {
 			// todo: client本来是一个 RPCClient类型, xClient是从这里开始转变为client struct
 			// todo: 的，所以可以调用 client.conn
+			option := c.option
+			option.SerializeType = selectSerializer(c.option, c.servers[k])
 			client = &Client{
-				option:  c.option,
+				option:  option,
 				Plugins: c.Plugins,
 			}
 
@@ -567,11 +696,15 @@ func (c *xClient) getCachedClient(k string) (RPCClient, error) {
 				if breaker != nil {
 					breaker.(Breaker).Fail()
 				}
+				if c.goingAway[k] {
+					return nil, ErrServerGoingAway
+				}
 				return nil, err
 			}
 			if c.Plugins != nil {
 				needCallPlugin = true
 			}
+			delete(c.goingAway, k)
 		}
 
 		client.RegisterServerMessageChan(c.serverMessageChan)
@@ -599,8 +732,10 @@ func (c *xClient) getCachedClientWithoutLock(k string) (RPCClient, error) {
 		if network == "inprocess" {
 			client = InprocessClient
 		} else {
+			option := c.option
+			option.SerializeType = selectSerializer(c.option, c.servers[k])
 			client = &Client{
-				option:  c.option,
+				option:  option,
 				Plugins: c.Plugins,
 			}
 			logs.Debug("getCache 22222 --------------------------")
@@ -632,6 +767,11 @@ func (c *xClient) removeClient(k string, client RPCClient) {
 	}
 }
 
+func isGoingAway(client RPCClient) bool {
+	cl, ok := client.(*Client)
+	return ok && cl.isGoingAway()
+}
+
 func splitNetworkAndAddress(server string) (string, string) {
 	ss := strings.SplitN(server, "@", 2)
 	if len(ss) == 1 {
@@ -648,15 +788,11 @@ func (c *xClient) Go(ctx context.Context, serviceMethod string, args interface{}
 		return nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, err := c.withAuth(ctx)
+	if err != nil {
+		return nil, err
 	}
+	ctx = c.withIdempotencyKey(ctx)
 
 	_, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
 	if err != nil {
@@ -692,17 +828,12 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, err := c.withAuth(ctx)
+	if err != nil {
+		return err
 	}
+	ctx = c.withIdempotencyKey(ctx)
 
-	var err error
 	// todo: xClient在 selectClient中内存初始化为 client
 	// todo: here has connect the server, in the c.selectClient func `c.getCachedClient(k)`
 	// todo: so if use http2, dont need to connect server
@@ -729,14 +860,24 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 				if err == nil {
 					return nil
 				}
-				if _, ok := err.(ServiceError); ok {
+				if isServiceError(err) {
 					return err
 				}
+				if retries >= 0 {
+					if werr := waitRetry(ctx, err); werr != nil {
+						return werr
+					}
+				}
 			}
 
 			if uncoverError(err) {
 				c.removeClient(k, client)
 			}
+			// a corrupted or shed call is retried on another node.
+			if shouldFailover(err) {
+				k, client, e = c.selectClientNoRepeat(ctx, c.servicePath, serviceMethod, args, k)
+				continue
+			}
 			client, e = c.getCachedClient(k)
 		}
 		if err == nil {
@@ -755,13 +896,18 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 				if err == nil {
 					return nil
 				}
-				if _, ok := err.(ServiceError); ok {
+				if isServiceError(err) {
 					return err
 				}
+				if retries >= 0 {
+					if werr := waitRetry(ctx, err); werr != nil {
+						return werr
+					}
+				}
 			}
 
 			// todo: if fail node, need to removeClient use `k`key
-			if err != nil {
+			if err != nil && err != ErrServerOverloaded && err != ErrServerGoingAway && !isRetryableError(err) {
 				logs.Debugf("-->>> HTTP2 call err -->>> %+v, c.removeClient(k, client)", err)
 				c.removeClient(k, client)
 			}
@@ -805,7 +951,15 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 			if err == nil && reply != nil {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(reply1).Elem())
 			}
-			return err
+			if !isRetryableError(err) {
+				return err
+			}
+			// the call has been rejected before the backup latency, the backup call is sent after the retry delay.
+			t.Stop()
+			if err = waitRetry(ctx, err); err != nil {
+				return err
+			}
+			call1 = nil
 		case <-t.C:
 
 		}
@@ -814,6 +968,9 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 			if uncoverError(err2) {
 				c.removeClient(k, client)
 			}
+			if call1 == nil {
+				return err2
+			}
 			err = err1
 			return err
 		}
@@ -836,6 +993,14 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 		return err
 	default: //Failfast
 		err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+		if err == ErrServerGoingAway {
+			// the server has not handled the call, so it is sent to another server.
+			k, client, err = c.selectClient(ctx, c.servicePath, serviceMethod, args)
+			if err != nil {
+				return err
+			}
+			err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+		}
 		if err != nil {
 			if uncoverError(err) {
 				c.removeClient(k, client)
@@ -847,6 +1012,64 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 }
 
 
+// NewStream opens a stream to serviceMethod on one of the servers.
+// Opening the stream handles errors base on FailMode and Failbackup works like Failover.
+// Once it has been opened the stream is bound to that server.
+func (c *xClient) NewStream(ctx context.Context, serviceMethod string, args interface{}) (*Stream, error) {
+	if c.isShutdown {
+		return nil, ErrXClientShutdown
+	}
+
+	ctx, err := c.withAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
+	if err != nil && c.failMode == Failfast {
+		return nil, err
+	}
+
+	retries := c.option.Retries
+	if c.failMode == Failfast {
+		retries = 0
+	}
+
+	var e error
+	for retries >= 0 {
+		retries--
+
+		if client != nil {
+			var st *Stream
+			st, err = c.wrapNewStream(ctx, client, serviceMethod, args)
+			if err == nil {
+				return st, nil
+			}
+			if isServiceError(err) {
+				return nil, err
+			}
+			if uncoverError(err) {
+				c.removeClient(k, client)
+			}
+		}
+		if retries < 0 {
+			break
+		}
+
+		if c.failMode == Failtry {
+			client, e = c.getCachedClient(k)
+		} else {
+			k, client, e = c.selectClientNoRepeat(ctx, c.servicePath, serviceMethod, args, k)
+		}
+	}
+
+	if err == nil {
+		err = e
+	}
+	return nil, err
+}
+
+
 func (c *xClient) CallNotGo(svc string, md string, pairs []*KVPair) string {
 	// 调用非go服务
 	if len(pairs) == 0 {
@@ -882,8 +1105,70 @@ func callPySvc(svc, md, svcAddr string, params string) string {
 	return rspCt
 }
 
-func uncoverError(err error) bool {
+// isChecksumError returns whether a message of the call has been corrupted on the way.
+func isChecksumError(err error) bool {
+	var cerr *protocol.ChecksumError
+	return errors.As(err, &cerr)
+}
+
+// isServiceError returns whether err has been returned by the service, such calls are not retried.
+// Retryable errors are not service errors.
+func isServiceError(err error) bool {
 	if _, ok := err.(ServiceError); ok {
+		return true
+	}
+	var serr *ex.Error
+	return errors.As(err, &serr) && !isRetryableError(err)
+}
+
+// isRetryableError returns whether a server asks to try the call again later:
+// err is a ResourceExhausted or Unavailable error or it has a RetryInfo.
+func isRetryableError(err error) bool {
+	if _, ok := ex.RetryDelay(err); ok {
+		return true
+	}
+	switch ex.CodeOf(err) {
+	case ex.ResourceExhausted, ex.Unavailable:
+		return true
+	}
+	return false
+}
+
+// waitRetry waits for the RetryInfo delay of err before the call is retried.
+// It returns the error of ctx if ctx is done before.
+func waitRetry(ctx context.Context, err error) error {
+	delay, ok := ex.RetryDelay(err)
+	if !ok || delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shouldFailover returns whether a failed call is retried on another node by Failtry,
+// because the call has been corrupted on the way, shed by an overloaded server or refused by a server going away.
+func shouldFailover(err error) bool {
+	return isChecksumError(err) || err == ErrServerOverloaded || err == ErrServerGoingAway
+}
+
+func uncoverError(err error) bool {
+	if isServiceError(err) {
+		return false
+	}
+
+	// the server is healthy, so its connection is kept.
+	if err == ErrServerOverloaded || isRetryableError(err) {
+		return false
+	}
+
+	// the server finishes the other calls of the connection before it closes it.
+	if err == ErrServerGoingAway {
 		return false
 	}
 
@@ -928,17 +1213,12 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		return nil, nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, err := c.withAuth(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
+	ctx = c.withIdempotencyKey(ctx)
 
-	var err error
 	//logs.Debug("xclient SendRow selectClient -------------")
 	// todo: 根据XClient的数据来生成Client，最后的SendRaw逻辑是由Client调用的
 	// todo: c.selecrClient 触发 getCachedClient 函数, 这个函数调用了 client/connection.go 的 Connect 函数,
@@ -953,7 +1233,7 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 			return nil, nil, err
 		}
 
-		if _, ok := err.(ServiceError); ok {
+		if isServiceError(err) {
 			logs.Debug("DEBUG halokid 3 ------ ")
 			return nil, nil, err
 		}
@@ -972,9 +1252,22 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 				if err == nil {
 					return m, payload, nil
 				}
-				if _, ok := err.(ServiceError); ok {
+				if isServiceError(err) {
 					return nil, nil, err
 				}
+				if retries >= 0 {
+					if werr := waitRetry(ctx, err); werr != nil {
+						return nil, nil, werr
+					}
+				}
+				// a corrupted or shed call is retried on another node.
+				if shouldFailover(err) {
+					if uncoverError(err) {
+						c.removeClient(k, client)
+					}
+					k, client, e = c.selectClientNoRepeat(ctx, r.ServicePath, r.ServiceMethod, r.Payload, k)
+					continue
+				}
 			}
 
 			if uncoverError(err) {
@@ -1006,9 +1299,14 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 					logs.Errorf("-->>> %+v", err.Error())
 				}
 				
-				if _, ok := err.(ServiceError); ok {
+				if isServiceError(err) {
 					return nil, nil, err
 				}
+				if retries >= 0 {
+					if werr := waitRetry(ctx, err); werr != nil {
+						return nil, nil, werr
+					}
+				}
 			}
 
 			if uncoverError(err) {
@@ -1042,6 +1340,19 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 	}
 }
 
+func (c *xClient) wrapNewStream(ctx context.Context, client RPCClient, serviceMethod string, args interface{}) (*Stream, error) {
+	if client == nil {
+		return nil, ErrServerUnavailable
+	}
+
+	ctx = share.NewContext(ctx)
+	c.Plugins.DoPreCall(ctx, c.servicePath, serviceMethod, args)
+	st, err := client.NewStream(ctx, c.servicePath, serviceMethod, args)
+	c.Plugins.DoPostCall(ctx, c.servicePath, serviceMethod, args, nil, err)
+
+	return st, err
+}
+
 func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod string, args interface{}, reply interface{}) error {
 	logs.Debugf("wrapCall *xClient -->>> %+v", c)
 	if client == nil {
@@ -1077,14 +1388,9 @@ func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args inte
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, authErr := c.withAuth(ctx)
+	if authErr != nil {
+		return authErr
 	}
 
 	var clients = make(map[string]RPCClient)
@@ -1148,15 +1454,11 @@ func (c *xClient) Fork(ctx context.Context, serviceMethod string, args interface
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, authErr := c.withAuth(ctx)
+	if authErr != nil {
+		return authErr
 	}
+	ctx = c.withIdempotencyKey(ctx)
 
 	var clients = make(map[string]RPCClient)
 	c.mu.Lock()
@@ -3,14 +3,17 @@ package client
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"fmt"
 
 	testutils "github.com/halokid/rpcx-plus/_testutils"
+	rerrors "github.com/halokid/rpcx-plus/errors"
 	"github.com/halokid/rpcx-plus/protocol"
 	"github.com/halokid/rpcx-plus/server"
+	"github.com/halokid/rpcx-plus/serverplugin"
 	"github.com/halokid/rpcx-plus/share"
 )
 
@@ -101,6 +104,26 @@ func TestXClient_filterByStateAndGroup(t *testing.T) {
 	}
 }
 
+func TestXClient_selectSerializer(t *testing.T) {
+	option := DefaultOption
+	option.SerializeType = protocol.ProtoBuffer
+	option.FallbackSerializeTypes = []protocol.SerializeType{protocol.CBOR, protocol.JSON}
+
+	cases := map[string]protocol.SerializeType{
+		"":                         protocol.ProtoBuffer,
+		"group=test":               protocol.ProtoBuffer,
+		"serializers=0,1,2":        protocol.ProtoBuffer,
+		"group=test&serializers=1": protocol.JSON,
+		"serializers=1,5":          protocol.CBOR,
+		"serializers=7":            protocol.ProtoBuffer,
+	}
+	for metadata, want := range cases {
+		if got := selectSerializer(option, metadata); got != want {
+			t.Errorf("%q: expect %d but got %d", metadata, want, got)
+		}
+	}
+}
+
 func TestUncoverError(t *testing.T) {
 	var e error = ServiceError("error")
 	if uncoverError(e) {
@@ -115,9 +138,239 @@ func TestUncoverError(t *testing.T) {
 		t.Fatalf("expect false but get true")
 	}
 
+	if uncoverError(ErrServerGoingAway) {
+		t.Fatalf("expect false but get true")
+	}
+
 	e = errors.New("error")
 	if !uncoverError(e) {
 		t.Fatalf("expect true but get false")
 	}
 }
 
+type Lazy int
+
+func (t *Lazy) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(500 * time.Millisecond)
+	reply.C = args.A * args.B
+	return nil
+}
+
+func TestXClient_GoAway(t *testing.T) {
+	s1 := server.NewServer()
+	s1.RegisterName("Arith", new(Arith), "")
+	s1.RegisterName("Lazy", new(Lazy), "")
+	go s1.Serve("tcp", "127.0.0.1:0")
+	s2 := server.NewServer()
+	s2.RegisterName("Arith", new(Arith), "")
+	go s2.Serve("tcp", "127.0.0.1:0")
+	defer s2.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr1 := "tcp@" + s1.Address().String()
+	addr2 := "tcp@" + s2.Address().String()
+	d := NewMultipleServersDiscovery([]*KVPair{{Key: addr1}, {Key: addr2}})
+	// servers only send going away frames to clients that have agreed them in the handshake
+	option := DefaultOption
+	option.Handshake = true
+	xclient := NewXClient("Arith", Failfast, RoundRobin, d, option)
+	defer xclient.Close()
+
+	args := &Args{A: 10, B: 20}
+	for i := 0; i < 4; i++ {
+		reply := &Reply{}
+		if err := xclient.Call(context.Background(), "Mul", args, reply); err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+	}
+
+	// a client without handshake is not told, the server refuses its new calls
+	legacy := &Client{option: DefaultOption}
+	if err := legacy.Connect("tcp", s1.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer legacy.Close()
+	if err := legacy.Call(context.Background(), "Arith", "Mul", args, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+
+	// a call in flight when the server starts to shut down is completed
+	client := &Client{option: option}
+	if err := client.Connect("tcp", s1.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+	lazy := client.Go(context.Background(), "Lazy", "Mul", args, &Reply{}, nil)
+	time.Sleep(100 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s1.Shutdown(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if !client.IsClosing() {
+		t.Errorf("expect the client to be closing after going away")
+	}
+	if err := client.Call(context.Background(), "Arith", "Mul", args, &Reply{}); err != ErrServerGoingAway {
+		t.Errorf("expect ErrServerGoingAway but got %v", err)
+	}
+	if legacy.IsClosing() {
+		t.Errorf("expect the client without handshake not to get the going away frame")
+	}
+	if err := legacy.Call(context.Background(), "Arith", "Mul", args, &Reply{}); err != ErrServerGoingAway {
+		t.Errorf("expect ErrServerGoingAway from the server but got %v", err)
+	}
+
+	// new calls are sent to the other server
+	for i := 0; i < 10; i++ {
+		reply := &Reply{}
+		if err := xclient.Call(context.Background(), "Mul", args, reply); err != nil {
+			t.Fatalf("failed to call after going away: %v", err)
+		}
+		if reply.C != 200 {
+			t.Fatalf("expect 200 but got %d", reply.C)
+		}
+	}
+
+	call := <-lazy.Done
+	if call.Error != nil {
+		t.Fatalf("expect the call in flight to be completed but got %v", call.Error)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+
+	// the server is still known, a server restarted at its address gets calls again
+	xc := xclient.(*xClient)
+	xc.mu.Lock()
+	_, ok := xc.servers[addr1]
+	xc.mu.Unlock()
+	if !ok {
+		t.Errorf("expect %s to be kept in the servers", addr1)
+	}
+	for i := 0; i < 4; i++ {
+		if err := xclient.Call(context.Background(), "Mul", args, &Reply{}); err != nil {
+			t.Fatalf("failed to call after shutdown: %v", err)
+		}
+	}
+}
+
+type Sequence struct {
+	calls int32
+}
+
+func (t *Sequence) Incr(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(100 * time.Millisecond)
+	reply.C = int(atomic.AddInt32(&t.calls, 1))
+	return nil
+}
+
+func TestXClient_Idempotent(t *testing.T) {
+	s := server.NewServer(server.WithInterceptors(serverplugin.NewIdempotencyPlugin(nil).Intercept))
+	counter := &Sequence{}
+	s.RegisterName("Sequence", counter, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	opt := DefaultOption
+	opt.Idempotent = true
+	opt.BackupLatency = 10 * time.Millisecond
+	d := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	xclient := NewXClient("Sequence", Failbackup, RandomSelect, d, opt)
+	defer xclient.Close()
+
+	// the backup request of Failbackup has the same key, so the method runs once
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Incr", &Args{}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if reply.C != 1 || atomic.LoadInt32(&counter.calls) != 1 {
+		t.Fatalf("expect 1 call but got %d, %d", reply.C, counter.calls)
+	}
+
+	// every call has its own key
+	if err := xclient.Call(context.Background(), "Incr", &Args{}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 2 {
+		t.Fatalf("expect 2 calls but got %d", reply.C)
+	}
+
+	// a key set by the caller is kept
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.IdempotencyKey: "order-1"})
+	for i := 0; i < 2; i++ {
+		if err := xclient.Call(ctx, "Incr", &Args{}, reply); err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+		if reply.C != 3 {
+			t.Fatalf("expect the result of the call of order-1 but got %d", reply.C)
+		}
+	}
+}
+
+// Limited rejects the first calls of every client with a ResourceExhausted error.
+type Limited struct {
+	rejects int32
+	calls   int32
+}
+
+func (t *Limited) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	if atomic.AddInt32(&t.calls, 1) <= t.rejects {
+		return rerrors.New(rerrors.ResourceExhausted, "rate limited", &rerrors.RetryInfo{RetryDelay: 200 * time.Millisecond})
+	}
+	reply.C = args.A * args.B
+	return nil
+}
+
+func TestXClient_Retryable(t *testing.T) {
+	if isServiceError(rerrors.New(rerrors.Unavailable, "unavailable")) || uncoverError(rerrors.New(rerrors.Unavailable, "unavailable")) {
+		t.Fatalf("expect Unavailable errors retried on the kept connection")
+	}
+	if !isServiceError(rerrors.New(rerrors.NotFound, "not found")) {
+		t.Fatalf("expect NotFound errors not retried")
+	}
+
+	limited := &Limited{}
+	s := server.NewServer()
+	s.RegisterName("Arith", limited, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	for _, mode := range []FailMode{Failtry, Failover, Failbackup} {
+		atomic.StoreInt32(&limited.calls, 0)
+		limited.rejects = 1
+
+		opt := DefaultOption
+		opt.Retries = 2
+		opt.BackupLatency = time.Second
+		d := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+		xclient := NewXClient("Arith", mode, RandomSelect, d, opt)
+
+		start := time.Now()
+		reply := &Reply{}
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+			t.Fatalf("%v: failed to call: %v", mode, err)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed >= time.Second {
+			t.Errorf("%v: expect the call retried after the retry delay but it took %v", mode, elapsed)
+		}
+		if reply.C != 200 || atomic.LoadInt32(&limited.calls) != 2 {
+			t.Errorf("%v: expect 2 calls but got %d, %d", mode, reply.C, limited.calls)
+		}
+		xclient.Close()
+	}
+
+	// a Failfast call is not retried
+	atomic.StoreInt32(&limited.calls, 0)
+	d := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	xclient := NewXClient("Arith", Failfast, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+	err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{})
+	if delay, ok := rerrors.RetryDelay(err); !ok || delay != 200*time.Millisecond {
+		t.Fatalf("expect a ResourceExhausted error with a retry delay but got %v", err)
+	}
+}
@@ -3,13 +3,18 @@ package codec
 import (
 	"bytes"
 	"context"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"reflect"
 
+	"github.com/fxamacker/cbor/v2"
 	proto "github.com/gogo/protobuf/proto"
 	pb "github.com/golang/protobuf/proto"
 	"github.com/vmihailenco/msgpack"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/encoding/protojson"
+	protov2 "google.golang.org/protobuf/proto"
 
 	"github.com/apache/thrift/lib/go/thrift"
 )
@@ -126,3 +131,78 @@ func (c ThriftCodec) Decode(data []byte, i interface{}) error {
 	d.Transport.Close()
 	return d.Read(i.(thrift.TStruct), data)
 }
+
+// CBORCodec uses cbor marshaler and unmarshaler.
+type CBORCodec struct{}
+
+// Encode encodes an object into slice of bytes.
+func (c CBORCodec) Encode(i interface{}) ([]byte, error) {
+	return cbor.Marshal(i)
+}
+
+// Decode decodes an object from slice of bytes.
+func (c CBORCodec) Decode(data []byte, i interface{}) error {
+	return cbor.Unmarshal(data, i)
+}
+
+// BSONCodec uses bson marshaler and unmarshaler.
+// Only structs and maps can be encoded because BSON encodes documents.
+type BSONCodec struct{}
+
+// Encode encodes an object into slice of bytes.
+func (c BSONCodec) Encode(i interface{}) ([]byte, error) {
+	return bson.Marshal(i)
+}
+
+// Decode decodes an object from slice of bytes.
+func (c BSONCodec) Decode(data []byte, i interface{}) error {
+	return bson.Unmarshal(data, i)
+}
+
+// GobCodec uses gob encoder and decoder.
+// Every message carries its type information, so it is larger than with a gob stream.
+type GobCodec struct{}
+
+// Encode encodes an object into slice of bytes.
+func (c GobCodec) Encode(i interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(i)
+	return buf.Bytes(), err
+}
+
+// Decode decodes an object from slice of bytes.
+func (c GobCodec) Decode(data []byte, i interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(i)
+}
+
+// ProtoJSONCodec uses the canonical protobuf JSON mapping for protobuf messages
+// and json marshaler and unmarshaler for other objects.
+// It lets JSON callers call services whose args are protobuf messages.
+type ProtoJSONCodec struct{}
+
+// Encode encodes an object into slice of bytes.
+func (c ProtoJSONCodec) Encode(i interface{}) ([]byte, error) {
+	if m, ok := protoMessage(i); ok {
+		return protojson.Marshal(m)
+	}
+	return json.Marshal(i)
+}
+
+// Decode decodes an object from slice of bytes.
+func (c ProtoJSONCodec) Decode(data []byte, i interface{}) error {
+	if m, ok := protoMessage(i); ok {
+		return protojson.Unmarshal(data, m)
+	}
+	return json.Unmarshal(data, i)
+}
+
+// protoMessage returns i as a protobuf message if it is one.
+func protoMessage(i interface{}) (protov2.Message, bool) {
+	switch m := i.(type) {
+	case protov2.Message:
+		return m, true
+	case pb.Message:
+		return pb.MessageV2(m), true
+	}
+	return nil, false
+}
@@ -0,0 +1,268 @@
+package errors
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// Code is the code of an Error. The values are the same as gRPC codes.
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+var codeNames = map[Code]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
+var httpStatuses = map[Code]int{
+	OK:                 http.StatusOK,
+	Canceled:           499,
+	Unknown:            http.StatusInternalServerError,
+	InvalidArgument:    http.StatusBadRequest,
+	DeadlineExceeded:   http.StatusGatewayTimeout,
+	NotFound:           http.StatusNotFound,
+	AlreadyExists:      http.StatusConflict,
+	PermissionDenied:   http.StatusForbidden,
+	ResourceExhausted:  http.StatusTooManyRequests,
+	FailedPrecondition: http.StatusBadRequest,
+	Aborted:            http.StatusConflict,
+	OutOfRange:         http.StatusBadRequest,
+	Unimplemented:      http.StatusNotImplemented,
+	Internal:           http.StatusInternalServerError,
+	Unavailable:        http.StatusServiceUnavailable,
+	DataLoss:           http.StatusInternalServerError,
+	Unauthenticated:    http.StatusUnauthorized,
+}
+
+// HTTPStatus returns the HTTP status code of c.
+func (c Code) HTTPStatus() int {
+	if status, ok := httpStatuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error with a code and typed details that is sent to clients.
+// Clients get it back with errors.As.
+type Error struct {
+	Code    Code
+	Message string
+	// Details carry structured information about the error, such as *BadRequest or *RetryInfo.
+	// Details of types registered with RegisterDetail are decoded as pointers of the type on the client.
+	Details []interface{}
+}
+
+// New returns an Error with code, message and details.
+func New(code Code, message string, details ...interface{}) *Error {
+	return &Error{Code: code, Message: message, Details: details}
+}
+
+// Errorf returns an Error with code and a formatted message.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Error returns the message of the error, clients without support for codes get the same text.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CodeOf returns the code of err, OK for nil and Unknown for errors that are not an Error.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.Code
+	}
+	return Unknown
+}
+
+// RetryDelay returns the delay of the RetryInfo detail of err, if it has one.
+func RetryDelay(err error) (time.Duration, bool) {
+	var e *Error
+	if !stderrors.As(err, &e) {
+		return 0, false
+	}
+	for _, d := range e.Details {
+		switch info := d.(type) {
+		case *RetryInfo:
+			return info.RetryDelay, true
+		case RetryInfo:
+			return info.RetryDelay, true
+		}
+	}
+	return 0, false
+}
+
+// FieldViolation describes an invalid field of a request.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// BadRequest lists the invalid fields of a request.
+type BadRequest struct {
+	FieldViolations []FieldViolation `json:"field_violations"`
+}
+
+// RetryInfo tells clients when they can retry the request.
+type RetryInfo struct {
+	RetryDelay time.Duration `json:"retry_delay"`
+}
+
+// ErrorInfo describes the cause of an error.
+type ErrorInfo struct {
+	Reason   string            `json:"reason"`
+	Domain   string            `json:"domain"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+var (
+	detailsMu    sync.RWMutex
+	detailTypes  = make(map[string]reflect.Type)
+	detailByType = make(map[reflect.Type]string)
+)
+
+func init() {
+	RegisterDetail("rpcx.BadRequest", &BadRequest{})
+	RegisterDetail("rpcx.RetryInfo", &RetryInfo{})
+	RegisterDetail("rpcx.ErrorInfo", &ErrorInfo{})
+}
+
+// RegisterDetail registers the type of detail under name so that clients decode details of this type.
+// detail can be a value or a pointer, details are always decoded as pointers.
+func RegisterDetail(name string, detail interface{}) {
+	t := reflect.TypeOf(detail)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	detailsMu.Lock()
+	detailTypes[name] = t
+	detailByType[t] = name
+	detailsMu.Unlock()
+}
+
+type wireDetail struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// SetMetadata stores the error in the metadata of a response.
+func (e *Error) SetMetadata(metadata map[string]string) {
+	metadata[protocol.ServiceError] = e.Message
+	metadata[protocol.ServiceErrorCode] = strconv.Itoa(int(e.Code))
+	if len(e.Details) == 0 {
+		return
+	}
+
+	details := make([]wireDetail, 0, len(e.Details))
+	detailsMu.RLock()
+	for _, d := range e.Details {
+		value, err := json.Marshal(d)
+		if err != nil {
+			continue
+		}
+		t := reflect.TypeOf(d)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		name, ok := detailByType[t]
+		if !ok {
+			name = t.String()
+		}
+		details = append(details, wireDetail{Type: name, Value: value})
+	}
+	detailsMu.RUnlock()
+
+	if data, err := json.Marshal(details); err == nil {
+		metadata[protocol.ServiceErrorDetails] = string(data)
+	}
+}
+
+// FromMetadata returns the Error stored in the metadata of a response,
+// or nil if the response has no error code.
+// Details of unregistered types are returned as json.RawMessage.
+func FromMetadata(metadata map[string]string) *Error {
+	c, ok := metadata[protocol.ServiceErrorCode]
+	if !ok {
+		return nil
+	}
+	code, err := strconv.Atoi(c)
+	if err != nil {
+		return nil
+	}
+
+	e := &Error{Code: Code(code), Message: metadata[protocol.ServiceError]}
+	var details []wireDetail
+	if err := json.Unmarshal([]byte(metadata[protocol.ServiceErrorDetails]), &details); err != nil {
+		return e
+	}
+
+	detailsMu.RLock()
+	defer detailsMu.RUnlock()
+	for _, d := range details {
+		t, ok := detailTypes[d.Type]
+		if !ok {
+			e.Details = append(e.Details, d.Value)
+			continue
+		}
+		v := reflect.New(t).Interface()
+		if err := json.Unmarshal(d.Value, v); err != nil {
+			e.Details = append(e.Details, d.Value)
+			continue
+		}
+		e.Details = append(e.Details, v)
+	}
+	return e
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxPooledBufferSize is the largest buffer kept in the pool, larger ones are left to the GC.
+const maxPooledBufferSize = 64 * 1024
+
+// encodeBuffer holds the encoded head of a message and the buffers passed to writev.
+type encodeBuffer struct {
+	data []byte
+	sum  [4]byte
+	arr  [3][]byte
+	bufs net.Buffers
+}
+
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &encodeBuffer{data: make([]byte, 0, 512)}
+	},
+}
+
+func getEncodeBuffer() *encodeBuffer {
+	return encodeBufferPool.Get().(*encodeBuffer)
+}
+
+func putEncodeBuffer(b *encodeBuffer) {
+	if cap(b.data) > maxPooledBufferSize {
+		return
+	}
+	b.data = b.data[:0]
+	b.arr[0], b.arr[1], b.arr[2] = nil, nil, nil
+	b.bufs = nil
+	encodeBufferPool.Put(b)
+}
+
+// EncodeTo writes the encoded message to w without allocating the whole frame.
+// The header, names and metadata are encoded into a pooled buffer and the payload is not copied.
+// Both parts and the checksum trailer are written with one writev when w is a TCP or Unix connection.
+// Otherwise they are copied into the pooled buffer and written with one Write,
+// so concurrent writers never interleave a message.
+func (m *Message) EncodeTo(w io.Writer) (int64, error) {
+	payload := m.Payload
+	if m.CompressType() != None {
+		compressor := Compressors[m.CompressType()]
+		if compressor == nil {
+			m.SetCompressType(None)
+		} else {
+			zipped, err := compressor.Zip(m.Payload)
+			if err != nil {
+				m.SetCompressType(None)
+			} else {
+				payload = zipped
+			}
+		}
+	}
+
+	b := getEncodeBuffer()
+	defer putEncodeBuffer(b)
+
+	b.data = m.appendHead(b.data, len(payload))
+	var trailer []byte
+	if m.HasChecksum() {
+		binary.BigEndian.PutUint32(b.sum[:], frameChecksum(b.data, payload))
+		trailer = b.sum[:]
+	}
+
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		b.arr[0], b.arr[1], b.arr[2] = b.data, payload, trailer
+		b.bufs = b.arr[:]
+		return b.bufs.WriteTo(w)
+	default:
+		b.data = append(b.data, payload...)
+		b.data = append(b.data, trailer...)
+		n, err := w.Write(b.data)
+		return int64(n), err
+	}
+}
+
+// appendHead appends everything before the payload to data.
+func (m *Message) appendHead(data []byte, payloadLen int) []byte {
+	spL := len(m.ServicePath)
+	smL := len(m.ServiceMethod)
+	metaL := 0
+	for k, v := range m.Metadata {
+		metaL += 4 + len(k) + 4 + len(v)
+	}
+	totalL := (4 + spL) + (4 + smL) + (4 + metaL) + (4 + payloadLen) + m.checksumLen()
+
+	data = append(data, m.Header[:]...)
+	data = appendUint32(data, uint32(totalL))
+	data = appendUint32(data, uint32(spL))
+	data = append(data, m.ServicePath...)
+	data = appendUint32(data, uint32(smL))
+	data = append(data, m.ServiceMethod...)
+	data = appendUint32(data, uint32(metaL))
+	for k, v := range m.Metadata {
+		data = appendUint32(data, uint32(len(k)))
+		data = append(data, k...)
+		data = appendUint32(data, uint32(len(v)))
+		data = append(data, v...)
+	}
+	return appendUint32(data, uint32(payloadLen))
+}
+
+func appendUint32(data []byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(data, b[:]...)
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func newBenchMessage() *Message {
+	req := NewMessage()
+	req.SetMessageType(Response)
+	req.SetSerializeType(MsgPack)
+	req.SetSeq(1234567890)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Metadata = map[string]string{"__ID": "6ba7b810-9dad-11d1-80b4-00c04fd430c9"}
+	req.Payload = bytes.Repeat([]byte("rpcx"), 256)
+	return req
+}
+
+func TestEncodeTo(t *testing.T) {
+	req := newBenchMessage()
+
+	var buf bytes.Buffer
+	n, err := req.EncodeTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != buf.Len() {
+		t.Fatalf("expect %d bytes written but got %d", buf.Len(), n)
+	}
+	if !bytes.Equal(buf.Bytes(), req.Encode()) {
+		t.Fatalf("EncodeTo and Encode differ")
+	}
+
+	// writev on a tcp connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		req.EncodeTo(conn)
+		conn.Close()
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	res, err := Read(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Seq() != req.Seq() || res.ServicePath != req.ServicePath || res.ServiceMethod != req.ServiceMethod ||
+		res.Metadata["__ID"] != req.Metadata["__ID"] || !bytes.Equal(res.Payload, req.Payload) {
+		t.Fatalf("got wrong message: %v", res)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	req := newBenchMessage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ioutil.Discard.Write(req.Encode())
+	}
+}
+
+func BenchmarkEncodeTo(b *testing.B) {
+	req := newBenchMessage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req.EncodeTo(ioutil.Discard)
+	}
+}
+
+func benchmarkTCP(b *testing.B, write func(req *Message, conn net.Conn)) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	req := newBenchMessage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		write(req, conn)
+	}
+}
+
+func BenchmarkEncode_TCP(b *testing.B) {
+	benchmarkTCP(b, func(req *Message, conn net.Conn) {
+		conn.Write(req.Encode())
+	})
+}
+
+func BenchmarkEncodeTo_TCP(b *testing.B) {
+	benchmarkTCP(b, func(req *Message, conn net.Conn) {
+		req.EncodeTo(conn)
+	})
+}
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// ChecksumErrorKey marks error responses to requests whose checksum did not match.
+const ChecksumErrorKey = "__rpcx_checksum_error"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError is returned by Decode when the CRC32C trailer of a message does not match its data.
+// The whole message has been read, so the connection can still be used.
+type ChecksumError struct {
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %08x, got %08x", e.Expected, e.Actual)
+}
+
+// SetMetadata reports e to the peer in the metadata of an error response.
+func (e *ChecksumError) SetMetadata(m map[string]string) {
+	m[ChecksumErrorKey] = fmt.Sprintf("%08x %08x", e.Expected, e.Actual)
+}
+
+// ChecksumErrorFromMetadata returns the *ChecksumError reported by the peer in m, or nil.
+func ChecksumErrorFromMetadata(m map[string]string) *ChecksumError {
+	v, ok := m[ChecksumErrorKey]
+	if !ok {
+		return nil
+	}
+	e := &ChecksumError{}
+	fmt.Sscanf(v, "%x %x", &e.Expected, &e.Actual)
+	return e
+}
+
+// HasChecksum returns whether the message ends with a CRC32C trailer.
+func (h Header) HasChecksum() bool {
+	return h[3]&0x08 == 0x08
+}
+
+// SetChecksum sets the checksum flag. Encode then appends a CRC32C of the header,
+// the length fields, names, metadata and payload, and Decode verifies it.
+func (h *Header) SetChecksum(checksum bool) {
+	if checksum {
+		h[3] = h[3] | 0x08
+	} else {
+		h[3] = h[3] &^ 0x08
+	}
+}
+
+// checksumLen returns the length of the trailer of the message.
+func (h Header) checksumLen() int {
+	if h.HasChecksum() {
+		return 4
+	}
+	return 0
+}
+
+// frameChecksum returns the CRC32C of parts, which together are a message without its trailer.
+func frameChecksum(parts ...[]byte) uint32 {
+	var sum uint32
+	for _, p := range parts {
+		sum = crc32.Update(sum, castagnoli, p)
+	}
+	return sum
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	req := newBenchMessage()
+	req.SetCompressType(Gzip)
+	req.SetChecksum(true)
+
+	data := req.Encode()
+
+	var buf bytes.Buffer
+	if _, err := req.EncodeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("EncodeTo and Encode differ")
+	}
+	buf.Reset()
+	if err := req.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("WriteTo and Encode differ")
+	}
+
+	res, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.HasChecksum() || !bytes.Equal(res.Payload, req.Payload) {
+		t.Fatal("got wrong message")
+	}
+
+	// corrupt the payload, the next message can still be read.
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-10] ^= 0xFF
+	r := bytes.NewReader(append(corrupted, data...))
+
+	_, err = Read(r)
+	var cerr *ChecksumError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expect *ChecksumError but got %v", err)
+	}
+	if _, err = Read(r); err != nil {
+		t.Fatalf("failed to read the next message: %v", err)
+	}
+
+	m := make(map[string]string)
+	cerr.SetMetadata(m)
+	if got := ChecksumErrorFromMetadata(m); *got != *cerr {
+		t.Errorf("expect %v but got %v", cerr, got)
+	}
+}
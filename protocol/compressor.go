@@ -1,15 +1,59 @@
 package protocol
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
 	"github.com/halokid/rpcx-plus/util"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 )
 
+// DefaultCompressThreshold is the payload size above which messages are compressed
+// when a compress type has been set.
+const DefaultCompressThreshold = 1024
+
 // Compressor defines a common compression interface.
 type Compressor interface {
 	Zip([]byte) ([]byte, error)
 	Unzip([]byte) ([]byte, error)
 }
 
+// LimitedCompressor is implemented by the compressors that stop unzipping data
+// once it gets longer than max bytes, so that a small message cannot be unzipped into a huge one.
+// The data of other compressors is checked after it has been unzipped.
+type LimitedCompressor interface {
+	UnzipLimit(data []byte, max int) ([]byte, error)
+}
+
+// unzipLimitError is returned when unzipped data is longer than max, got is its known length.
+func unzipLimitError(max, got int) error {
+	return &LimitError{Field: "MaxMessageLength", Limit: max, Got: got}
+}
+
+// readLimit reads r until EOF and fails if it has more than max bytes.
+func readLimit(r io.Reader, max int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		return nil, unzipLimitError(max, len(data))
+	}
+	return data, nil
+}
+
+// RegisterCompressor registers a compressor for the compress type t.
+// It overrides the compressor registered for t before. The compress type must fit in 3 bits,
+// and compressors should be registered before clients and servers are started.
+func RegisterCompressor(t CompressType, c Compressor) {
+	Compressors[t] = c
+}
+
 // GzipCompressor implements gzip compressor.
 type GzipCompressor struct {
 }
@@ -22,6 +66,15 @@ func (c GzipCompressor) Unzip(data []byte) ([]byte, error) {
 	return util.Unzip(data)
 }
 
+func (c GzipCompressor) UnzipLimit(data []byte, max int) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	return readLimit(gr, max)
+}
+
 type RawDataCompressor struct {
 }
 
@@ -32,3 +85,102 @@ func (c RawDataCompressor) Zip(data []byte) ([]byte, error) {
 func (c RawDataCompressor) Unzip(data []byte) ([]byte, error) {
 	return data, nil
 }
+
+func (c RawDataCompressor) UnzipLimit(data []byte, max int) ([]byte, error) {
+	return data, nil
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+	// zstdLimitedDecoders are the decoders by max decoded size, limits rarely change.
+	zstdLimitedDecoders sync.Map
+)
+
+func zstdLimitedDecoder(max int) (*zstd.Decoder, error) {
+	if d, ok := zstdLimitedDecoders.Load(max); ok {
+		return d.(*zstd.Decoder), nil
+	}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(max)))
+	if err != nil {
+		return nil, err
+	}
+	actual, loaded := zstdLimitedDecoders.LoadOrStore(max, d)
+	if loaded {
+		d.Close()
+	}
+	return actual.(*zstd.Decoder), nil
+}
+
+// ZstdCompressor implements zstd compressor.
+type ZstdCompressor struct {
+}
+
+func (c ZstdCompressor) Zip(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (c ZstdCompressor) Unzip(data []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+func (c ZstdCompressor) UnzipLimit(data []byte, max int) ([]byte, error) {
+	d, err := zstdLimitedDecoder(max)
+	if err != nil {
+		return nil, err
+	}
+	data, err = d.DecodeAll(data, nil)
+	if err == zstd.ErrDecoderSizeExceeded {
+		return nil, unzipLimitError(max, max+1)
+	}
+	return data, err
+}
+
+// SnappyCompressor implements snappy compressor.
+type SnappyCompressor struct {
+}
+
+func (c SnappyCompressor) Zip(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c SnappyCompressor) Unzip(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+func (c SnappyCompressor) UnzipLimit(data []byte, max int) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, unzipLimitError(max, n)
+	}
+	return snappy.Decode(nil, data)
+}
+
+// LZ4Compressor implements lz4 compressor. It uses the lz4 frame format.
+type LZ4Compressor struct {
+}
+
+func (c LZ4Compressor) Zip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := lz4.NewWriter(&buf)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c LZ4Compressor) Unzip(data []byte) ([]byte, error) {
+	return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+}
+
+func (c LZ4Compressor) UnzipLimit(data []byte, max int) ([]byte, error) {
+	return readLimit(lz4.NewReader(bytes.NewReader(data)), max)
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompressors(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"A": 1, "B": 2}`), 256)
+
+	for _, ct := range []CompressType{Gzip, Zstd, Snappy, LZ4} {
+		req := NewMessage()
+		req.SetMessageType(Request)
+		req.SetCompressType(ct)
+		req.SetSerializeType(JSON)
+		req.SetSeq(1234567890)
+		req.ServicePath = "Arith"
+		req.ServiceMethod = "Add"
+		req.Payload = payload
+
+		data := req.Encode()
+		if len(data) >= len(payload) {
+			t.Errorf("compress type %d: payload is not compressed", ct)
+		}
+
+		res, err := Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("compress type %d: %v", ct, err)
+		}
+		if res.CompressType() != ct {
+			t.Errorf("compress type %d: got compress type %d", ct, res.CompressType())
+		}
+		if !bytes.Equal(res.Payload, payload) {
+			t.Errorf("compress type %d: got wrong payload", ct)
+		}
+	}
+}
+
+func TestUnzipLimits(t *testing.T) {
+	payload := bytes.Repeat([]byte{0}, 1<<20)
+
+	for _, ct := range []CompressType{Gzip, Zstd, Snappy, LZ4} {
+		req := NewMessage()
+		req.SetMessageType(Request)
+		req.SetCompressType(ct)
+		req.SetSerializeType(JSON)
+		req.ServicePath = "Arith"
+		req.ServiceMethod = "Add"
+		req.Payload = payload
+		data := req.Encode()
+
+		// the message is short, its payload is not
+		err := NewMessage().DecodeWithLimits(bytes.NewReader(data), &DecodeLimits{MaxMessageLength: len(data)})
+		if !errors.Is(err, ErrMessageTooLong) {
+			t.Errorf("compress type %d: expect ErrMessageTooLong but got %v", ct, err)
+		}
+
+		res := NewMessage()
+		if err := res.DecodeWithLimits(bytes.NewReader(data), &DecodeLimits{MaxMessageLength: 2 << 20}); err != nil {
+			t.Fatalf("compress type %d: %v", ct, err)
+		}
+		if !bytes.Equal(res.Payload, payload) {
+			t.Errorf("compress type %d: got wrong payload", ct)
+		}
+	}
+}
+
+type reverseCompressor struct{}
+
+func (c reverseCompressor) Zip(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+
+func (c reverseCompressor) Unzip(data []byte) ([]byte, error) {
+	return c.Zip(data)
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	const reverse CompressType = 7
+	RegisterCompressor(reverse, reverseCompressor{})
+	defer delete(Compressors, reverse)
+
+	req := NewMessage()
+	req.SetCompressType(reverse)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Add"
+	req.Payload = []byte("abc")
+
+	data := req.Encode()
+	if !bytes.HasSuffix(data, []byte("cba")) {
+		t.Fatalf("expect the registered compressor to be used")
+	}
+
+	res, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Payload) != "abc" {
+		t.Errorf("expect abc but got %s", res.Payload)
+	}
+}
@@ -0,0 +1,217 @@
+package protocol
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the highest protocol version implemented by this package.
+// Version 0 is the original protocol without handshake, version 1 adds frame types.
+const ProtocolVersion byte = 1
+
+// Features that peers can agree on in the handshake.
+const (
+	// FeatureStream supports server streaming and bidirectional streaming.
+	FeatureStream = "stream"
+	// FeatureCancel supports cancel frames.
+	FeatureCancel = "cancel"
+	// FeatureDeadline applies the deadline of callers to handlers.
+	FeatureDeadline = "deadline"
+	// FeatureChecksum verifies the CRC32C trailer of messages.
+	FeatureChecksum = "checksum"
+	// FeatureGoAway supports the going away frames of servers that are shutting down.
+	FeatureGoAway = "goaway"
+)
+
+// DefaultFeatures are the features implemented by this package.
+var DefaultFeatures = []string{FeatureStream, FeatureCancel, FeatureDeadline, FeatureChecksum, FeatureGoAway}
+
+const (
+	handshakeVersionsKey    = "__rpcx_hs_versions"
+	handshakeCompressorsKey = "__rpcx_hs_compressors"
+	handshakeSerializersKey = "__rpcx_hs_serializers"
+	handshakeFeaturesKey    = "__rpcx_hs_features"
+	handshakeAgreedKey      = "__rpcx_hs_agreed"
+)
+
+// ErrNoCommonVersion the peers do not share a protocol version.
+var ErrNoCommonVersion = errors.New("handshake: no common protocol version")
+
+// Handshake describes what a peer supports. After the handshake it holds the agreed set.
+type Handshake struct {
+	// Agreed is set in the answer of a peer that has negotiated the handshake.
+	// Peers without handshake support echo the handshake unchanged.
+	Agreed bool
+	// Version is the agreed protocol version. It is the highest of Versions before the handshake.
+	Version     byte
+	Versions    []byte
+	Compressors []CompressType
+	Serializers []SerializeType
+	Features    []string
+}
+
+// NewHandshake returns the handshake of this package with the registered compressors,
+// the given serializers and DefaultFeatures.
+func NewHandshake(serializers []SerializeType) *Handshake {
+	h := &Handshake{
+		Version:     ProtocolVersion,
+		Serializers: serializers,
+		Features:    DefaultFeatures,
+	}
+	for v := byte(0); v <= ProtocolVersion; v++ {
+		h.Versions = append(h.Versions, v)
+	}
+	for ct := range Compressors {
+		h.Compressors = append(h.Compressors, ct)
+	}
+	sort.Slice(h.Compressors, func(i, j int) bool { return h.Compressors[i] < h.Compressors[j] })
+	return h
+}
+
+// SupportsCompressor returns whether ct has been agreed.
+func (h *Handshake) SupportsCompressor(ct CompressType) bool {
+	for _, c := range h.Compressors {
+		if c == ct {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsSerializer returns whether st has been agreed.
+func (h *Handshake) SupportsSerializer(st SerializeType) bool {
+	for _, s := range h.Serializers {
+		if s == st {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFeature returns whether feature has been agreed.
+func (h *Handshake) HasFeature(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate returns what both h and remote support.
+func (h *Handshake) Negotiate(remote *Handshake) (*Handshake, error) {
+	agreed := &Handshake{Agreed: true}
+
+	for _, v := range h.Versions {
+		for _, rv := range remote.Versions {
+			if v == rv {
+				agreed.Versions = append(agreed.Versions, v)
+				if v > agreed.Version {
+					agreed.Version = v
+				}
+			}
+		}
+	}
+	if len(agreed.Versions) == 0 {
+		return nil, ErrNoCommonVersion
+	}
+
+	for _, ct := range h.Compressors {
+		if remote.SupportsCompressor(ct) {
+			agreed.Compressors = append(agreed.Compressors, ct)
+		}
+	}
+	for _, st := range h.Serializers {
+		if remote.SupportsSerializer(st) {
+			agreed.Serializers = append(agreed.Serializers, st)
+		}
+	}
+	for _, f := range h.Features {
+		if remote.HasFeature(f) {
+			agreed.Features = append(agreed.Features, f)
+		}
+	}
+	return agreed, nil
+}
+
+// Metadata encodes the handshake as the metadata of a FrameHandshake message.
+func (h *Handshake) Metadata() map[string]string {
+	versions := make([]string, 0, len(h.Versions))
+	for _, v := range h.Versions {
+		versions = append(versions, strconv.Itoa(int(v)))
+	}
+	compressors := make([]string, 0, len(h.Compressors))
+	for _, ct := range h.Compressors {
+		compressors = append(compressors, strconv.Itoa(int(ct)))
+	}
+	serializers := make([]string, 0, len(h.Serializers))
+	for _, st := range h.Serializers {
+		serializers = append(serializers, strconv.Itoa(int(st)))
+	}
+
+	m := map[string]string{
+		handshakeVersionsKey:    strings.Join(versions, ","),
+		handshakeCompressorsKey: strings.Join(compressors, ","),
+		handshakeSerializersKey: strings.Join(serializers, ","),
+		handshakeFeaturesKey:    strings.Join(h.Features, ","),
+	}
+	if h.Agreed {
+		m[handshakeAgreedKey] = "1"
+	}
+	return m
+}
+
+// HandshakeFromMetadata decodes a handshake from the metadata of a FrameHandshake message.
+func HandshakeFromMetadata(m map[string]string) (*Handshake, error) {
+	h := &Handshake{Agreed: m[handshakeAgreedKey] == "1"}
+
+	versions, err := parseBytes(m[handshakeVersionsKey])
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		h.Versions = append(h.Versions, v)
+		if v > h.Version {
+			h.Version = v
+		}
+	}
+
+	compressors, err := parseBytes(m[handshakeCompressorsKey])
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range compressors {
+		h.Compressors = append(h.Compressors, CompressType(ct))
+	}
+
+	serializers, err := parseBytes(m[handshakeSerializersKey])
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range serializers {
+		h.Serializers = append(h.Serializers, SerializeType(st))
+	}
+
+	if f := m[handshakeFeaturesKey]; f != "" {
+		h.Features = strings.Split(f, ",")
+	}
+	return h, nil
+}
+
+func parseBytes(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	bs := make([]byte, 0, len(parts))
+	for _, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return nil, errors.New("handshake: invalid value " + p)
+		}
+		bs = append(bs, byte(v))
+	}
+	return bs, nil
+}
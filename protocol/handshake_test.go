@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeNegotiate(t *testing.T) {
+	local := NewHandshake([]SerializeType{SerializeNone, JSON, MsgPack})
+	remote := &Handshake{
+		Versions:    []byte{0, 1, 2},
+		Compressors: []CompressType{None, Gzip, Zstd},
+		Serializers: []SerializeType{JSON, ProtoBuffer},
+		Features:    []string{FeatureCancel, "unknown"},
+	}
+
+	agreed, err := local.Negotiate(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !agreed.Agreed || agreed.Version != 1 {
+		t.Errorf("expect agreed version 1 but got %d", agreed.Version)
+	}
+	if !reflect.DeepEqual(agreed.Compressors, []CompressType{None, Gzip, Zstd}) {
+		t.Errorf("unexpected compressors %v", agreed.Compressors)
+	}
+	if !agreed.SupportsSerializer(JSON) || agreed.SupportsSerializer(MsgPack) {
+		t.Errorf("unexpected serializers %v", agreed.Serializers)
+	}
+	if !agreed.HasFeature(FeatureCancel) || agreed.HasFeature(FeatureStream) {
+		t.Errorf("unexpected features %v", agreed.Features)
+	}
+
+	_, err = local.Negotiate(&Handshake{Versions: []byte{7}})
+	if err != ErrNoCommonVersion {
+		t.Errorf("expect ErrNoCommonVersion but got %v", err)
+	}
+}
+
+func TestHandshakeMetadata(t *testing.T) {
+	h := NewHandshake([]SerializeType{JSON, ProtoBuffer})
+	h.Agreed = true
+
+	got, err := HandshakeFromMetadata(h.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Errorf("expect %+v but got %+v", h, got)
+	}
+
+	_, err = HandshakeFromMetadata(map[string]string{handshakeVersionsKey: "1,x"})
+	if err == nil {
+		t.Error("expect an error for an invalid version")
+	}
+}
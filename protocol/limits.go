@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidMessage the length fields of a message do not match its data.
+var ErrInvalidMessage = errors.New("invalid message: length fields do not match the data")
+
+// DecodeLimits bounds the messages accepted by Decode so that a bad or hostile peer
+// cannot make the receiver allocate huge buffers. A zero field means no limit.
+type DecodeLimits struct {
+	// MaxMessageLength is the max length of a message after the header.
+	// MaxMessageLength of this package is used if it is zero.
+	MaxMessageLength int
+	// MaxMetadataCount is the max number of metadata entries.
+	MaxMetadataCount int
+	// MaxMetadataKeyLength is the max length of a metadata key.
+	MaxMetadataKeyLength int
+	// MaxMetadataValueLength is the max length of a metadata value.
+	MaxMetadataValueLength int
+	// MaxServicePathLength is the max length of the service path.
+	MaxServicePathLength int
+	// MaxServiceMethodLength is the max length of the service method.
+	MaxServiceMethodLength int
+}
+
+// LimitError is returned by Decode when a message exceeds one of the DecodeLimits.
+type LimitError struct {
+	// Field is the name of the exceeded limit, such as "MaxMetadataCount".
+	Field string
+	Limit int
+	Got   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("message exceeds %s: %d > %d", e.Field, e.Got, e.Limit)
+}
+
+// Is reports ErrMessageTooLong for the message length limit so that existing checks still work.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrMessageTooLong && e.Field == "MaxMessageLength"
+}
+
+func (l *DecodeLimits) check(field string, limit, got int) error {
+	if limit > 0 && got > limit {
+		return &LimitError{Field: field, Limit: limit, Got: got}
+	}
+	return nil
+}
+
+// readChunkSize is the size data is read in when a message is large,
+// so the buffer only grows as fast as the peer really sends data.
+const readChunkSize = 64 * 1024
+
+// readData reads n bytes from r into buf.
+func readData(r io.Reader, buf []byte, n int) ([]byte, error) {
+	if cap(buf) >= n {
+		buf = buf[:n]
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+
+	buf = buf[:0]
+	for len(buf) < n {
+		chunk := n - len(buf)
+		if chunk > readChunkSize {
+			chunk = readChunkSize
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			if err == io.EOF && start > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf, err
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newLimitsMessage() *Message {
+	req := NewMessage()
+	req.SetMessageType(Request)
+	req.SetSerializeType(JSON)
+	req.SetSeq(1234567890)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Add"
+	req.Metadata = map[string]string{"__ID": "6ba7b810-9dad-11d1-80b4-00c04fd430c9", "k": "v"}
+	req.Payload = []byte(`{"A": 1, "B": 2}`)
+	return req
+}
+
+func TestDecodeWithLimits(t *testing.T) {
+	data := newLimitsMessage().Encode()
+
+	cases := []struct {
+		limits DecodeLimits
+		field  string
+	}{
+		{DecodeLimits{}, ""},
+		{DecodeLimits{MaxMessageLength: 10}, "MaxMessageLength"},
+		{DecodeLimits{MaxMetadataCount: 1}, "MaxMetadataCount"},
+		{DecodeLimits{MaxMetadataKeyLength: 3}, "MaxMetadataKeyLength"},
+		{DecodeLimits{MaxMetadataValueLength: 8}, "MaxMetadataValueLength"},
+		{DecodeLimits{MaxServicePathLength: 4}, "MaxServicePathLength"},
+		{DecodeLimits{MaxServiceMethodLength: 2}, "MaxServiceMethodLength"},
+	}
+
+	for _, c := range cases {
+		msg := NewMessage()
+		err := msg.DecodeWithLimits(bytes.NewReader(data), &c.limits)
+		if c.field == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			continue
+		}
+
+		var le *LimitError
+		if !errors.As(err, &le) || le.Field != c.field {
+			t.Errorf("expect a LimitError of %s but got %v", c.field, err)
+		}
+	}
+
+	err := NewMessage().DecodeWithLimits(bytes.NewReader(data), &DecodeLimits{MaxMessageLength: 10})
+	if !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("expect ErrMessageTooLong but got %v", err)
+	}
+}
+
+func TestDecodeInvalidLength(t *testing.T) {
+	data := newLimitsMessage().Encode()
+
+	// the service path claims more data than the message has.
+	bad := append([]byte(nil), data...)
+	bad[16], bad[17], bad[18], bad[19] = 0x7F, 0xFF, 0xFF, 0xFF
+	err := NewMessage().Decode(bytes.NewReader(bad))
+	if err != ErrInvalidMessage {
+		t.Errorf("expect ErrInvalidMessage but got %v", err)
+	}
+
+	// the total length is larger than the data that arrives.
+	bad = append([]byte(nil), data[:12]...)
+	bad = append(bad, 0x7F, 0xFF, 0xFF, 0xFF)
+	bad = append(bad, data[16:]...)
+	err = NewMessage().Decode(bytes.NewReader(bad))
+	if err == nil || !strings.Contains(err.Error(), "EOF") {
+		t.Errorf("expect an EOF error but got %v", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(newLimitsMessage().Encode())
+
+	req := newLimitsMessage()
+	req.SetCompressType(Gzip)
+	req.Payload = bytes.Repeat([]byte("rpcx"), 512)
+	f.Add(req.Encode())
+
+	req = NewMessage()
+	req.SetHeartbeat(true)
+	f.Add(req.Encode())
+
+	limits := &DecodeLimits{
+		MaxMessageLength:       1 << 20,
+		MaxMetadataCount:       64,
+		MaxMetadataKeyLength:   256,
+		MaxMetadataValueLength: 4096,
+		MaxServicePathLength:   256,
+		MaxServiceMethodLength: 256,
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := NewMessage()
+		if err := msg.DecodeWithLimits(bytes.NewReader(data), limits); err != nil {
+			return
+		}
+
+		msg.SetCompressType(None)
+		again := NewMessage()
+		if err := again.DecodeWithLimits(bytes.NewReader(msg.Encode()), limits); err != nil {
+			t.Fatalf("failed to decode an encoded message: %v", err)
+		}
+
+		if *again.Header != *msg.Header || again.ServicePath != msg.ServicePath || again.ServiceMethod != msg.ServiceMethod {
+			t.Fatalf("header or names changed after a round trip")
+		}
+		if len(again.Metadata) != len(msg.Metadata) || (len(msg.Metadata) > 0 && !reflect.DeepEqual(again.Metadata, msg.Metadata)) {
+			t.Fatalf("metadata changed after a round trip: %v != %v", again.Metadata, msg.Metadata)
+		}
+		if !bytes.Equal(again.Payload, msg.Payload) {
+			t.Fatalf("payload changed after a round trip")
+		}
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	logs "github.com/halokid/rpcx-plus/log"
 	"io"
 
@@ -14,8 +16,11 @@ import (
 var (
 	// Compressors are compressors supported by rpcx. You can add customized compressor in Compressors.
 	Compressors = map[CompressType]Compressor{
-		None: &RawDataCompressor{},
-		Gzip: &GzipCompressor{},
+		None:   &RawDataCompressor{},
+		Gzip:   &GzipCompressor{},
+		Zstd:   &ZstdCompressor{},
+		Snappy: &SnappyCompressor{},
+		LZ4:    &LZ4Compressor{},
 	}
 )
 
@@ -44,6 +49,14 @@ var (
 const (
 	// ServiceError contains error info of service invocation
 	ServiceError = "__rpcx_error__"
+	// ServiceOverloaded marks error responses to requests shed by an overloaded server.
+	ServiceOverloaded = "__rpcx_overloaded__"
+	// ServiceGoingAway marks error responses to requests refused by a server that is shutting down.
+	ServiceGoingAway = "__rpcx_going_away__"
+	// ServiceErrorCode contains the code of a structured service error.
+	ServiceErrorCode = "__rpcx_error_code__"
+	// ServiceErrorDetails contains the details of a structured service error.
+	ServiceErrorDetails = "__rpcx_error_details__"
 )
 
 // MessageType is message type of requests and resposnes.
@@ -74,6 +87,12 @@ const (
 	None CompressType = iota
 	// Gzip uses gzip compression.
 	Gzip
+	// Zstd uses zstd compression.
+	Zstd
+	// Snappy uses snappy compression.
+	Snappy
+	// LZ4 uses lz4 compression.
+	LZ4
 )
 
 // SerializeType defines serialization type of payload.
@@ -91,6 +110,12 @@ const (
 	// Thrift
 	// Thrift for payload
 	Thrift
+	// CBOR for payload.
+	CBOR
+	// BSON for payload.
+	BSON
+	// Gob for payload.
+	Gob
 )
 
 // Message is the generic type of Request and Response.
@@ -251,9 +276,9 @@ func (m Message) Encode() []byte {
 		}
 	}
 
-	totalL := (4 + spL) + (4 + smL) + (4 + len(meta)) + (4 + len(payload))
+	totalL := (4 + spL) + (4 + smL) + (4 + len(meta)) + (4 + len(payload)) + m.checksumLen()
 
-	// header + dataLen + spLen + sp + smLen + sm + metaL + meta + payloadLen + payload
+	// header + dataLen + spLen + sp + smLen + sm + metaL + meta + payloadLen + payload + checksum
 	metaStart := 12 + 4 + (4 + spL) + (4 + smL)
 
 	payLoadStart := metaStart + (4 + len(meta))
@@ -278,11 +303,22 @@ func (m Message) Encode() []byte {
 	binary.BigEndian.PutUint32(data[payLoadStart:payLoadStart+4], uint32(len(payload)))
 	copy(data[payLoadStart+4:], payload)
 
+	if m.HasChecksum() {
+		binary.BigEndian.PutUint32(data[l-4:], frameChecksum(data[:l-4]))
+	}
+
 	return data
 }
 
 // WriteTo writes message to writers.
 func (m Message) WriteTo(w io.Writer) error {
+	out := w
+	var sum hash.Hash32
+	if m.HasChecksum() {
+		sum = crc32.New(castagnoli)
+		w = io.MultiWriter(out, sum)
+	}
+
 	_, err := w.Write(m.Header[:])
 	if err != nil {
 		return err
@@ -305,7 +341,7 @@ func (m Message) WriteTo(w io.Writer) error {
 		}
 	}
 
-	totalL := (4 + spL) + (4 + smL) + (4 + len(meta)) + (4 + len(payload))
+	totalL := (4 + spL) + (4 + smL) + (4 + len(meta)) + (4 + len(payload)) + m.checksumLen()
 	err = binary.Write(w, binary.BigEndian, uint32(totalL))
 	if err != nil {
 		return err
@@ -346,7 +382,10 @@ func (m Message) WriteTo(w io.Writer) error {
 	}
 
 	_, err = w.Write(payload)
-	return err
+	if err != nil || sum == nil {
+		return err
+	}
+	return binary.Write(out, binary.BigEndian, sum.Sum32())
 }
 
 // len,string,len,string,......
@@ -368,26 +407,39 @@ func encodeMetadata(m map[string]string) []byte {
 	return buf.Bytes()
 }
 
-func decodeMetadata(l uint32, data []byte) (map[string]string, error) {
+func decodeMetadata(l uint32, data []byte, limits *DecodeLimits) (map[string]string, error) {
 	m := make(map[string]string, 10)
 	n := uint32(0)
 	for n < l {
+		if err := limits.check("MaxMetadataCount", limits.MaxMetadataCount, len(m)+1); err != nil {
+			return m, err
+		}
+
 		// parse one key and value
 		// key
+		if l-n < 4 {
+			return m, ErrMetaKVMissing
+		}
 		sl := binary.BigEndian.Uint32(data[n : n+4])
 		n = n + 4
-		if n+sl > l-4 {
+		if sl > l-n || l-n-sl < 4 {
 			return m, ErrMetaKVMissing
 		}
+		if err := limits.check("MaxMetadataKeyLength", limits.MaxMetadataKeyLength, int(sl)); err != nil {
+			return m, err
+		}
 		k := string(data[n : n+sl])
 		n = n + sl
 
 		// value
 		sl = binary.BigEndian.Uint32(data[n : n+4])
 		n = n + 4
-		if n+sl > l {
+		if sl > l-n {
 			return m, ErrMetaKVMissing
 		}
+		if err := limits.check("MaxMetadataValueLength", limits.MaxMetadataValueLength, int(sl)); err != nil {
+			return m, err
+		}
 		v := string(data[n : n+sl])
 		n = n + sl
 		m[k] = v
@@ -407,8 +459,21 @@ func Read(r io.Reader) (*Message, error) {
 }
 
 // Decode decodes a message from reader.
+// Only MaxMessageLength of this package limits the message, see DecodeWithLimits.
 func (m *Message) Decode(r io.Reader) error {
-	// validate rest length for each step?
+	return m.DecodeWithLimits(r, nil)
+}
+
+// DecodeWithLimits decodes a message from reader and rejects it with a *LimitError
+// when it exceeds limits. A nil limits only applies MaxMessageLength of this package.
+func (m *Message) DecodeWithLimits(r io.Reader, limits *DecodeLimits) error {
+	if limits == nil {
+		limits = &DecodeLimits{}
+	}
+	maxLength := limits.MaxMessageLength
+	if maxLength == 0 {
+		maxLength = MaxMessageLength
+	}
 
 	//logs.Debugf("res.data 2 ---------------------  %+v", m)
 	// parse header
@@ -438,65 +503,90 @@ func (m *Message) Decode(r io.Reader) error {
 	}
 	logs.Debugf("io.ReadFull读取后lenData ---------- %+v, %+v", lenData, &lenData)
 	l := binary.BigEndian.Uint32(*lenData)		// todo: 读取到数据总长度之后， 用大端位的方式解码出来
+	var sum uint32
+	if m.HasChecksum() {
+		sum = frameChecksum(m.Header[:], *lenData)
+	}
 	poolUint32Data.Put(lenData)
 
-	if MaxMessageLength > 0 && int(l) > MaxMessageLength {
-		return ErrMessageTooLong
+	if err = limits.check("MaxMessageLength", maxLength, int(l)); err != nil {
+		return err
 	}
 
-
 	//logs.Debugf("res.data 3 ---------------------  %+v", m)
 
+	// the buffer grows while data arrives, so a wrong length does not allocate it at once.
 	totalL := int(l)
-	if cap(m.data) >= totalL { //reuse data
-		m.data = m.data[:totalL]
-	} else {
-		m.data = make([]byte, totalL)
-	}
-
-	//logs.Debugf("res.data 4 ---------------------  %+v", m)
-
-	data := m.data
-	//logs.Debugf("m.data 1 ---------------------- %+v", m.data)
-	_, err = io.ReadFull(r, data)
-	//logs.Debugf("m.data 2 ---------------------- %+v", m.data)
+	m.data, err = readData(r, m.data, totalL)
 	if err != nil {
 		return err
 	}
 
+	data := m.data
 	n := 0
+	// next returns the next length field, it must leave at least min bytes after the field.
+	next := func(min int) (int, error) {
+		if len(data)-n < 4 {
+			return 0, ErrInvalidMessage
+		}
+		l := int(binary.BigEndian.Uint32(data[n : n+4]))
+		n = n + 4
+		if l < 0 || l > len(data)-n-min {
+			return 0, ErrInvalidMessage
+		}
+		return l, nil
+	}
+
 	// parse servicePath
-	l = binary.BigEndian.Uint32(data[n:4])		// todo: 根据数据的位置读取 message 的总长度数值
-	n = n + 4				// todo: 让 n 推后到 服务名称 开始的位置
-	nEnd := n + int(l)
-	m.ServicePath = util.SliceByteToString(data[n:nEnd])		// todo: 根据数据位置读取 message 的服务名称
-	n = nEnd			// todo: 再次推后 n
+	sl, err := next(12)		// todo: 根据数据的位置读取 服务名称 的长度
+	if err != nil {
+		return err
+	}
+	if err = limits.check("MaxServicePathLength", limits.MaxServicePathLength, sl); err != nil {
+		return err
+	}
+	m.ServicePath = util.SliceByteToString(data[n : n+sl])		// todo: 根据数据位置读取 message 的服务名称
+	n = n + sl			// todo: 再次推后 n
 
 	// parse serviceMethod
-	l = binary.BigEndian.Uint32(data[n : n+4])
-	n = n + 4
-	nEnd = n + int(l)
-	m.ServiceMethod = util.SliceByteToString(data[n:nEnd])			// todo: 读取方法名
-	n = nEnd
+	sl, err = next(8)
+	if err != nil {
+		return err
+	}
+	if err = limits.check("MaxServiceMethodLength", limits.MaxServiceMethodLength, sl); err != nil {
+		return err
+	}
+	m.ServiceMethod = util.SliceByteToString(data[n : n+sl])			// todo: 读取方法名
+	n = n + sl
 
 	// parse meta
-	l = binary.BigEndian.Uint32(data[n : n+4])
-	n = n + 4
-	nEnd = n + int(l)
-
-	if l > 0 {
-		m.Metadata, err = decodeMetadata(l, data[n:nEnd])
+	sl, err = next(4)
+	if err != nil {
+		return err
+	}
+	if sl > 0 {
+		m.Metadata, err = decodeMetadata(uint32(sl), data[n:n+sl], limits)
 		if err != nil {
 			return err
 		}
 	}
-	n = nEnd
+	n = n + sl
 
 	// todo: parse payload
-	l = binary.BigEndian.Uint32(data[n : n+4])
-	_ = l
-	n = n + 4
-	m.Payload = data[n:]
+	sl, err = next(m.checksumLen())
+	if err != nil {
+		return err
+	}
+	m.Payload = data[n : n+sl]
+	n = n + sl
+
+	// the trailer is verified before the payload is used.
+	if m.HasChecksum() {
+		expected := binary.BigEndian.Uint32(data[n : n+4])
+		if actual := crc32.Update(sum, castagnoli, data[:n]); actual != expected {
+			return &ChecksumError{Expected: expected, Actual: actual}
+		}
+	}
 
 	if m.CompressType() != None {
 		compressor := Compressors[m.CompressType()]
@@ -504,7 +594,15 @@ func (m *Message) Decode(r io.Reader) error {
 			return ErrUnsupportedCompressor
 		}
 		logs.Debug("数据 解压缩 方式为----", m.Metadata["Content-Encoding"], m.CompressType())
-		m.Payload, err = compressor.Unzip(m.Payload)
+		// the unzipped payload is limited like the message, so a small message cannot be unzipped into a huge one.
+		if lc, ok := compressor.(LimitedCompressor); ok && maxLength > 0 {
+			m.Payload, err = lc.UnzipLimit(m.Payload, maxLength)
+		} else {
+			m.Payload, err = compressor.Unzip(m.Payload)
+			if err == nil {
+				err = limits.check("MaxMessageLength", maxLength, len(m.Payload))
+			}
+		}
 		if err != nil {
 			return err
 		}
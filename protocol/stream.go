@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// FrameType marks which part of a call a message carries.
+// Normal requests and responses use FrameNormal, streams use the other frames.
+type FrameType byte
+
+const (
+	// FrameNormal is a plain request or response matched by Seq.
+	FrameNormal FrameType = iota
+	// FrameStreamOpen opens a stream. The request carries the arguments and the response acknowledges it.
+	FrameStreamOpen
+	// FrameStreamData carries one message of a stream.
+	FrameStreamData
+	// FrameStreamClose closes the sending side of a stream. A response close frame ends the stream.
+	FrameStreamClose
+	// FrameStreamWindow grants the peer more data frames. The payload is a uint32 credit.
+	FrameStreamWindow
+	// FrameCancel asks the peer to stop handling the call identified by Seq.
+	FrameCancel
+	// FrameHandshake exchanges the supported versions, compressors, serializers and features
+	// when a connection is established.
+	FrameHandshake
+	// FrameGoAway is sent by a server that is shutting down. It finishes the calls in flight
+	// but does not handle new requests, so clients send them to other servers.
+	FrameGoAway
+)
+
+const (
+	// StreamWindowKey is the metadata key used to advertise the receive window when a stream is opened.
+	StreamWindowKey = "__rpcx_stream_window"
+)
+
+// DefaultStreamWindow is the number of data frames a peer may send before waiting for more credit.
+var DefaultStreamWindow uint32 = 64
+
+var (
+	// ErrStreamWindowExceeded the peer sent more data frames than the window allows.
+	ErrStreamWindowExceeded = errors.New("stream flow control window exceeded")
+	// ErrStreamClosed the stream has been closed.
+	ErrStreamClosed = errors.New("stream is closed")
+)
+
+// FrameType returns the frame type of this message.
+func (h Header) FrameType() FrameType {
+	return FrameType(h[3] & 0x07)
+}
+
+// SetFrameType sets the frame type.
+func (h *Header) SetFrameType(ft FrameType) {
+	h[3] = (h[3] &^ 0x07) | (byte(ft) & 0x07)
+}
+
+// IsStreamFrame returns whether the message belongs to a stream or is a control frame.
+func (h Header) IsStreamFrame() bool {
+	return h.FrameType() != FrameNormal
+}
+
+// EncodeWindow encodes a window credit as the payload of FrameStreamWindow.
+func EncodeWindow(n uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, n)
+	return data
+}
+
+// DecodeWindow decodes the payload of FrameStreamWindow.
+func DecodeWindow(data []byte) uint32 {
+	if len(data) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data)
+}
+
+// WindowFromMetadata returns the receive window advertised in metadata, or DefaultStreamWindow.
+func WindowFromMetadata(m map[string]string) uint32 {
+	if v := m[StreamWindowKey]; v != "" {
+		if n, err := strconv.ParseUint(v, 10, 32); err == nil && n > 0 {
+			return uint32(n)
+		}
+	}
+	return DefaultStreamWindow
+}
+
+// StreamCredit counts the data frames a stream may still send before the peer grants more.
+type StreamCredit struct {
+	mu     sync.Mutex
+	n      uint32
+	notify chan struct{}
+}
+
+// NewStreamCredit creates a StreamCredit with n initial credits.
+func NewStreamCredit(n uint32) *StreamCredit {
+	return &StreamCredit{
+		n:      n,
+		notify: make(chan struct{}),
+	}
+}
+
+// Acquire takes one credit. It blocks until a credit is granted or ctx is done.
+func (c *StreamCredit) Acquire(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.n > 0 {
+			c.n--
+			c.mu.Unlock()
+			return nil
+		}
+		ch := c.notify
+		c.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Grant adds n credits and wakes up the waiting senders.
+func (c *StreamCredit) Grant(n uint32) {
+	if n == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.n += n
+	close(c.notify)
+	c.notify = make(chan struct{})
+	c.mu.Unlock()
+}
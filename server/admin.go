@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/julienschmidt/httprouter"
+)
+
+// AdminServerInfo is the state of the server.
+type AdminServerInfo struct {
+	Address     string `json:"address"`
+	Connections int    `json:"connections"`
+	// InFlight is the number of requests being handled.
+	InFlight     int32 `json:"in_flight"`
+	Draining     bool  `json:"draining"`
+	ShuttingDown bool  `json:"shutting_down"`
+}
+
+// AdminService is a registered service with the stats of its methods.
+type AdminService struct {
+	Name string `json:"name"`
+	// Status is the serving status of the health service, if it is registered.
+	Status  string         `json:"status,omitempty"`
+	Methods []*AdminMethod `json:"methods"`
+}
+
+// AdminMethod is a method or a function of a service.
+type AdminMethod struct {
+	Name     string      `json:"name"`
+	Stream   bool        `json:"stream,omitempty"`
+	Function bool        `json:"function,omitempty"`
+	Stats    MethodStats `json:"stats"`
+}
+
+// AdminConn is a connection of a client.
+type AdminConn struct {
+	RemoteAddr string `json:"remote_addr"`
+	LocalAddr  string `json:"local_addr"`
+}
+
+// AdminVersion is the build info of the binary.
+type AdminVersion struct {
+	GoVersion string `json:"go_version"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`
+	// RPCXVersion is the version of the rpcx module the binary is built with.
+	RPCXVersion string            `json:"rpcx_version,omitempty"`
+	Settings    map[string]string `json:"settings,omitempty"`
+}
+
+const rpcxModulePath = "github.com/halokid/rpcx-plus"
+
+// AdminHandler returns the handler of the admin endpoints:
+//
+//	GET    /server                     state of the server
+//	GET    /services                   services, methods and their stats
+//	GET    /connections                connections of the clients
+//	DELETE /connections/:remoteAddr    closes the connection of a client
+//	GET    /plugins                    types of the plugins
+//	GET    /version                    build info
+//	POST   /drain                      marks the services inactive and tells the clients to go away
+//	PUT    /services/:service/state    sets the state of a service, ?state=active or ?state=inactive
+//	GET    /debug/pprof/               pprof profiles
+//
+// The endpoints are not authenticated, they must only be reachable by operators.
+func (s *Server) AdminHandler() http.Handler {
+	router := httprouter.New()
+	router.GET("/server", s.handleAdminServer)
+	router.GET("/services", s.handleAdminServices)
+	router.GET("/connections", s.handleAdminConnections)
+	router.DELETE("/connections/:remoteAddr", s.handleAdminCloseConnection)
+	router.GET("/plugins", s.handleAdminPlugins)
+	router.GET("/version", handleAdminVersion)
+	router.POST("/drain", s.handleAdminDrain)
+	router.PUT("/services/:service/state", s.handleAdminServiceState)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/", router)
+	return mux
+}
+
+// ServeAdmin serves the admin endpoints on ln, separately from the gateway.
+// It is closed with the server.
+func (s *Server) ServeAdmin(ln net.Listener) error {
+	srv := &http.Server{Handler: s.AdminHandler()}
+	s.mu.Lock()
+	s.adminHTTPServer = srv
+	s.mu.Unlock()
+
+	err := srv.Serve(ln)
+	if err == http.ErrServerClosed {
+		logs.Debug("admin server closed")
+		return nil
+	}
+	return err
+}
+
+func (s *Server) closeAdmin(ctx context.Context) error {
+	s.mu.RLock()
+	srv := s.adminHTTPServer
+	s.mu.RUnlock()
+	if srv != nil {
+		return srv.Shutdown(ctx)
+	}
+	return nil
+}
+
+func (s *Server) handleAdminServer(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	info := &AdminServerInfo{
+		InFlight:     atomic.LoadInt32(&s.handlerMsgNum),
+		Draining:     s.isDraining(),
+		ShuttingDown: isShutdown(s),
+	}
+	if addr := s.Address(); addr != nil {
+		info.Address = addr.String()
+	}
+	s.mu.RLock()
+	info.Connections = len(s.activeConn)
+	s.mu.RUnlock()
+
+	writeAdminJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleAdminServices(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	s.serviceMapMu.RLock()
+	services := make([]*AdminService, 0, len(s.serviceMap))
+	for name, service := range s.serviceMap {
+		as := &AdminService{Name: name, Methods: make([]*AdminMethod, 0, len(service.method)+len(service.function))}
+		if s.health != nil {
+			as.Status = s.health.status(name).String()
+		}
+		for mname, mtype := range service.method {
+			as.Methods = append(as.Methods, &AdminMethod{Name: mname, Stream: mtype.stream, Stats: mtype.stats.snapshot()})
+		}
+		for fname, ft := range service.function {
+			as.Methods = append(as.Methods, &AdminMethod{Name: fname, Function: true, Stats: ft.stats.snapshot()})
+		}
+		sort.Slice(as.Methods, func(i, j int) bool {
+			return as.Methods[i].Name < as.Methods[j].Name
+		})
+		services = append(services, as)
+	}
+	s.serviceMapMu.RUnlock()
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	writeAdminJSON(w, http.StatusOK, services)
+}
+
+func (s *Server) handleAdminConnections(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	conns := s.ActiveClientConn()
+	result := make([]*AdminConn, 0, len(conns))
+	for _, conn := range conns {
+		result = append(result, &AdminConn{RemoteAddr: conn.RemoteAddr().String(), LocalAddr: conn.LocalAddr().String()})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RemoteAddr < result[j].RemoteAddr
+	})
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleAdminCloseConnection(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	remoteAddr := params.ByName("remoteAddr")
+	for _, conn := range s.ActiveClientConn() {
+		if conn.RemoteAddr().String() == remoteAddr {
+			// serveConn removes the connection when its read fails
+			conn.Close()
+			logs.Infof("rpcx: admin closed the connection of %s", remoteAddr)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeAdminError(w, http.StatusNotFound, fmt.Errorf("no connection from %s", remoteAddr))
+}
+
+func (s *Server) handleAdminPlugins(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	plugins := s.Plugins.All()
+	result := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		result = append(result, fmt.Sprintf("%T", p))
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+func handleAdminVersion(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	v := &AdminVersion{GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		v.Path = info.Main.Path
+		v.Version = info.Main.Version
+		if info.Main.Path == rpcxModulePath {
+			v.RPCXVersion = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == rpcxModulePath {
+				v.RPCXVersion = dep.Version
+			}
+		}
+		if len(info.Settings) > 0 {
+			v.Settings = make(map[string]string, len(info.Settings))
+			for _, setting := range info.Settings {
+				v.Settings[setting.Key] = setting.Value
+			}
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) handleAdminDrain(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	logs.Info("rpcx: admin drains the server")
+	s.drain()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleAdminServiceState(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	service := params.ByName("service")
+	if !s.isRegistered(service) {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("unknown service %s", service))
+		return
+	}
+
+	var active bool
+	switch strings.ToLower(r.URL.Query().Get("state")) {
+	case "active":
+		active = true
+	case "inactive":
+	default:
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("state must be active or inactive"))
+		return
+	}
+
+	var err error
+	if s.health != nil {
+		// the health service keeps the status in sync for the clients that watch it
+		status := StatusNotServing
+		if active {
+			status = StatusServing
+		}
+		err = s.health.SetServingStatus(service, status)
+	} else {
+		state := stateInactive
+		if active {
+			state = ""
+		}
+		err = s.updateState(service, state)
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logs.Warnf("rpcx: failed to write admin response: %v", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// callSet holds the cancel functions of the requests in progress on one connection.
+type callSet struct {
+	mu    sync.Mutex
+	calls map[uint64]context.CancelFunc
+}
+
+func newCallSet() *callSet {
+	return &callSet{calls: make(map[uint64]context.CancelFunc)}
+}
+
+func (cs *callSet) add(seq uint64, cancel context.CancelFunc) {
+	cs.mu.Lock()
+	cs.calls[seq] = cancel
+	cs.mu.Unlock()
+}
+
+// remove removes the request seq and releases its context.
+func (cs *callSet) remove(seq uint64) {
+	cs.mu.Lock()
+	cancel := cs.calls[seq]
+	delete(cs.calls, seq)
+	cs.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// cancelAll cancels all requests when the connection is closed.
+func (cs *callSet) cancelAll() {
+	cs.mu.Lock()
+	for seq, cancel := range cs.calls {
+		cancel()
+		delete(cs.calls, seq)
+	}
+	cs.mu.Unlock()
+}
+
+// handleCancelFrame cancels the context of the request or stream identified by the Seq of req.
+func (s *Server) handleCancelFrame(calls *callSet, streams *streamSet, req *protocol.Message) {
+	seq := req.Seq()
+	protocol.FreeMsg(req)
+
+	if st := streams.get(seq); st != nil {
+		st.cancel()
+		return
+	}
+	calls.remove(seq)
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// handleChecksumError answers a request whose checksum does not match.
+// The whole request has been read, so the connection is kept.
+// Its header may be corrupted too, but the client that sent it is the best guess.
+func (s *Server) handleChecksumError(conn net.Conn, req *protocol.Message, err *protocol.ChecksumError) {
+	logs.Warnf("rpcx: request %d from %s is corrupted: %v", req.Seq(), conn.RemoteAddr(), err)
+
+	if !req.IsOneway() {
+		res := req.Clone()
+		res.SetMessageType(protocol.Response)
+		handleError(res, err)
+		err.SetMetadata(res.Metadata)
+		if s.writeTimeout != 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		}
+		res.EncodeTo(conn)
+		protocol.FreeMsg(res)
+	}
+	protocol.FreeMsg(req)
+}
@@ -22,6 +22,12 @@ const (
 	XServiceMethod     = "X-RPCX-ServiceMethod"
 	XMeta              = "X-RPCX-Meta"
 	XErrorMessage      = "X-RPCX-ErrorMessage"
+	// XErrorCode is the code of a failed request, see errors.Code.
+	XErrorCode = "X-RPCX-ErrorCode"
+	// XErrorDetails are the details of a failed request in JSON.
+	XErrorDetails = "X-RPCX-ErrorDetails"
+	// XTimeout is the time left before the deadline of the caller, such as "1.5s".
+	XTimeout = "X-RPCX-Timeout"
 )
 
 // HTTPRequest2RpcxRequest converts a http request to a rpcx request.
@@ -81,6 +87,14 @@ func HTTPRequest2RpcxRequest(r *http.Request) (*protocol.Message, error) {
 		req.Metadata[share.AuthKey] = auth
 	}
 
+	timeout := h.Get(XTimeout)
+	if timeout != "" {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]string)
+		}
+		req.Metadata[share.DeadlineKey] = timeout
+	}
+
 	sp := h.Get(XServicePath)
 	if sp != "" {
 		req.ServicePath = sp
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// ErrRequestExpired the deadline of the caller has passed before the request is handled.
+var ErrRequestExpired = errors.New("rpcx: request deadline exceeded")
+
+// withRequestDeadline applies the deadline carried in metadata to ctx.
+// It returns ErrRequestExpired if the deadline has already passed.
+func withRequestDeadline(ctx context.Context, metadata map[string]string) (context.Context, context.CancelFunc, error) {
+	deadline, ok := share.DeadlineFromMetadata(metadata)
+	if !ok {
+		return ctx, func() {}, nil
+	}
+	if !time.Now().Before(deadline) {
+		return ctx, func() {}, ErrRequestExpired
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return ctx, cancel, nil
+}
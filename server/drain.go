@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// ErrServerGoingAway is returned to clients whose requests arrive after the server has started to shut down.
+// They can be sent to another server.
+var ErrServerGoingAway = errors.New("rpcx: server is going away")
+
+// goAwayWriteTimeout bounds the write of going away frames when the server has no write timeout,
+// so that a client that does not read cannot block the shutdown.
+const goAwayWriteTimeout = time.Second
+
+// drain starts the graceful shutdown. The services are marked inactive in the registries
+// and connected clients are told to send new requests to other servers.
+func (s *Server) drain() {
+	if !atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		return
+	}
+
+	if s.health != nil {
+		if err := s.health.SetServingStatus("", StatusNotServing); err != nil {
+			logs.Warnf("rpcx: failed to mark services inactive: %v", err)
+		}
+	} else {
+		s.serviceMapMu.RLock()
+		for name := range s.serviceMap {
+			if err := s.updateState(name, stateInactive); err != nil {
+				logs.Warnf("rpcx: failed to mark %s inactive: %v", name, err)
+			}
+		}
+		s.serviceMapMu.RUnlock()
+	}
+
+	// clients without handshake would take the frame for a oneway request of the server.
+	s.mu.Lock()
+	conns := make(map[net.Conn]*protocol.Handshake, len(s.goAwayConns))
+	for conn, agreed := range s.goAwayConns {
+		conns[conn] = agreed
+	}
+	s.mu.Unlock()
+
+	msg := protocol.NewMessage()
+	msg.SetMessageType(protocol.Request)
+	msg.SetOneway(true)
+	msg.SetFrameType(protocol.FrameGoAway)
+
+	timeout := s.writeTimeout
+	if timeout == 0 {
+		timeout = goAwayWriteTimeout
+	}
+	for conn, agreed := range conns {
+		msg.SetVersion(agreed.Version)
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(msg.Encode()); err != nil {
+			logs.Debugf("rpcx: failed to send going away to %s: %v", conn.RemoteAddr(), err)
+		}
+		if s.writeTimeout == 0 {
+			conn.SetWriteDeadline(time.Time{})
+		}
+	}
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// writeGoingAway refuses req because the server is shutting down.
+func (s *Server) writeGoingAway(conn net.Conn, req *protocol.Message) {
+	if !req.IsOneway() {
+		res := req.Clone()
+		res.SetMessageType(protocol.Response)
+		handleError(res, ErrServerGoingAway)
+		res.Metadata[protocol.ServiceGoingAway] = "1"
+		res.EncodeTo(conn)
+		protocol.FreeMsg(res)
+	}
+	protocol.FreeMsg(req)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// toError returns err as a structured error. The errors of the server get their codes,
+// other errors are Unknown.
+func toError(err error) *rerrors.Error {
+	var serr *rerrors.Error
+	if errors.As(err, &serr) {
+		return serr
+	}
+
+	code := rerrors.Unknown
+	switch {
+	case errors.Is(err, ErrServerOverloaded):
+		code = rerrors.ResourceExhausted
+	case errors.Is(err, ErrHandlerTimeout), errors.Is(err, ErrRequestExpired), errors.Is(err, context.DeadlineExceeded):
+		code = rerrors.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = rerrors.Canceled
+	case errors.Is(err, ErrServerGoingAway):
+		code = rerrors.Unavailable
+	}
+	return rerrors.New(code, err.Error())
+}
+
+// httpStatus returns the HTTP status code of a failed request.
+func httpStatus(err error) int {
+	return toError(err).Code.HTTPStatus()
+}
+
+// setErrorHeaders sets the code and the details of err in the headers of a gateway response.
+func setErrorHeaders(header http.Header, err error) {
+	metadata := make(map[string]string)
+	toError(err).SetMetadata(metadata)
+	header.Set(XErrorCode, metadata[protocol.ServiceErrorCode])
+	if details := metadata[protocol.ServiceErrorDetails]; details != "" {
+		header.Set(XErrorDetails, details)
+	}
+	if delay, ok := rerrors.RetryDelay(err); ok {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+}
+
+// jsonrpcError converts err to a JSON-RPC error.
+// The data of the error holds the code of err and its details.
+func jsonrpcError(err error) *JSONRPCError {
+	serr := toError(err)
+
+	code := int64(CodeInternalJSONRPCError)
+	switch serr.Code {
+	case rerrors.Unimplemented:
+		code = CodeMethodNotFound
+	case rerrors.InvalidArgument:
+		code = CodeInvalidParams
+	case rerrors.Unknown, rerrors.Internal:
+	default:
+		// -32000 to -32099 are reserved for errors defined by the server,
+		// -32011 to -32026 are the codes of rpcx.
+		code = -32010 - int64(serr.Code)
+	}
+
+	jerr := &JSONRPCError{Code: code, Message: err.Error()}
+	data, merr := json.Marshal(struct {
+		Code    string        `json:"code"`
+		Details []interface{} `json:"details,omitempty"`
+	}{serr.Code.String(), serr.Details})
+	if merr == nil {
+		raw := json.RawMessage(data)
+		jerr.Data = &raw
+	}
+	return jerr
+}
@@ -65,11 +65,11 @@ func (s *Server) startHTTP1APIGateway(ln net.Listener) {
 		c := cors.New(opt)
 		mux := c.Handler(router)
 		s.mu.Lock()
-		s.gatewayHTTPServer = &http.Server{Handler: mux}
+		s.gatewayHTTPServer = &http.Server{Handler: mux, ConnContext: connPeerIdentity}
 		s.mu.Unlock()
 	} else {
 		s.mu.Lock()
-		s.gatewayHTTPServer = &http.Server{Handler: router}
+		s.gatewayHTTPServer = &http.Server{Handler: router, ConnContext: connPeerIdentity}
 		s.mu.Unlock()
 	}
 
@@ -149,11 +149,15 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 	}
 	err = s.Plugins.DoPostReadRequest(ctx, req, nil)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		wh.Set(XMessageStatusType, "Error")
+		wh.Set(XErrorMessage, err.Error())
+		setErrorHeaders(wh, err)
+		w.WriteHeader(httpStatus(err))
 		return
 	}
 
 	ctx = context.WithValue(ctx, StartRequestContextKey, time.Now().UnixNano())
+	ctx = share.NewContext(ctx)
 	err = s.auth(ctx, req)
 	if err != nil {
 		s.Plugins.DoPreWriteResponse(ctx, req, nil)
@@ -163,10 +167,40 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
 		return
 	}
+	if err = s.authorize(ctx, req); err != nil {
+		s.Plugins.DoPreWriteResponse(ctx, req, nil)
+		wh.Set(XMessageStatusType, "Error")
+		wh.Set(XErrorMessage, err.Error())
+		setErrorHeaders(wh, err)
+		w.WriteHeader(httpStatus(err))
+		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
+		return
+	}
+
+	if err = s.acquireInflight(servicePath); err != nil {
+		wh.Set(XMessageStatusType, "Error")
+		wh.Set(XErrorMessage, err.Error())
+		setErrorHeaders(wh, err)
+		w.WriteHeader(httpStatus(err))
+		return
+	}
+	defer s.releaseInflight(servicePath)
+
+	var cancel context.CancelFunc
+	ctx, cancel, err = withRequestDeadline(ctx, req.Metadata)
+	defer cancel()
+	if err != nil {
+		wh.Set(XMessageStatusType, "Error")
+		wh.Set(XErrorMessage, err.Error())
+		setErrorHeaders(wh, err)
+		w.WriteHeader(httpStatus(err))
+		return
+	}
 
 	resMetadata := make(map[string]string)
 	newCtx := context.WithValue(context.WithValue(ctx, share.ReqMetaDataKey, req.Metadata),
 		share.ResMetaDataKey, resMetadata)
+	newCtx = withTranscoding(newCtx)
 
 	res, err := s.handleRequest(newCtx, req)
 	defer protocol.FreeMsg(res)
@@ -175,7 +209,8 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 		logs.Warnf("rpcx: failed to handle gateway request: %v", err)
 		wh.Set(XMessageStatusType, "Error")
 		wh.Set(XErrorMessage, err.Error())
-		w.WriteHeader(500)
+		setErrorHeaders(wh, err)
+		w.WriteHeader(httpStatus(err))
 		return
 	}
 
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net"
+
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// HandshakeContextKey is a context key. Handlers can read the *protocol.Handshake agreed
+// with the client from the context. It is not set if the client has not sent a handshake.
+var HandshakeContextKey = &contextKey{"handshake"}
+
+// localHandshake returns what this server supports.
+func localHandshake() *protocol.Handshake {
+	return protocol.NewHandshake(share.SupportedSerializers())
+}
+
+// handleHandshake answers the handshake of a client and returns the agreed set.
+// The handshake is sent as a heartbeat so that servers without handshake support echo it.
+func (s *Server) handleHandshake(conn net.Conn, req *protocol.Message) *protocol.Handshake {
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+
+	remote, err := protocol.HandshakeFromMetadata(req.Metadata)
+	var agreed *protocol.Handshake
+	if err == nil {
+		agreed, err = localHandshake().Negotiate(remote)
+	}
+	if err != nil {
+		logs.Warnf("rpcx: handshake with %s failed: %v", conn.RemoteAddr(), err)
+		handleError(res, err)
+	} else {
+		res.Metadata = agreed.Metadata()
+	}
+
+	res.EncodeTo(conn)
+	protocol.FreeMsg(req)
+	protocol.FreeMsg(res)
+	return agreed
+}
+
+// checkHandshake returns an error if req uses something that has not been agreed.
+func checkHandshake(agreed *protocol.Handshake, req *protocol.Message) error {
+	if req.Version() != agreed.Version {
+		return fmt.Errorf("rpcx: protocol version %d has not been agreed in the handshake", req.Version())
+	}
+	if !agreed.SupportsSerializer(req.SerializeType()) {
+		return fmt.Errorf("rpcx: serializer %d has not been agreed in the handshake", req.SerializeType())
+	}
+	if req.CompressType() != protocol.None && !agreed.SupportsCompressor(req.CompressType()) {
+		return fmt.Errorf("rpcx: compressor %d has not been agreed in the handshake", req.CompressType())
+	}
+	if req.FrameType() == protocol.FrameStreamOpen && !agreed.HasFeature(protocol.FeatureStream) {
+		return fmt.Errorf("rpcx: feature %s has not been agreed in the handshake", protocol.FeatureStream)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+)
+
+// HealthServicePath is the service path of the health service registered by RegisterHealth.
+const HealthServicePath = "Health"
+
+// ServingStatus is the health of a service.
+type ServingStatus int32
+
+const (
+	// StatusUnknown the service is not known by the server.
+	StatusUnknown ServingStatus = iota
+	// StatusServing the service handles requests.
+	StatusServing
+	// StatusNotServing the service is unhealthy, it is marked inactive in the registry.
+	StatusNotServing
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// stateInactive is the registry state of services that clients do not route to.
+const stateInactive = "inactive"
+
+// HealthCheckRequest asks for the status of Service, an empty Service means the whole server.
+type HealthCheckRequest struct {
+	Service string
+}
+
+// HealthCheckResponse is the status of a service.
+type HealthCheckResponse struct {
+	Status ServingStatus
+}
+
+// Health is the health service of a server. Services are SERVING unless their status has been set.
+type Health struct {
+	server *Server
+
+	mu       sync.Mutex
+	statuses map[string]ServingStatus
+	watchers map[string]map[chan ServingStatus]struct{}
+}
+
+// RegisterHealth registers the health service of s as HealthServicePath and returns it.
+func (s *Server) RegisterHealth() (*Health, error) {
+	h := &Health{
+		server:   s,
+		statuses: make(map[string]ServingStatus),
+		watchers: make(map[string]map[chan ServingStatus]struct{}),
+	}
+	if err := s.RegisterName(HealthServicePath, h, ""); err != nil {
+		return nil, err
+	}
+	s.health = h
+	return h, nil
+}
+
+// Check returns the status of args.Service. It fails with a NotFound error for unknown services.
+func (h *Health) Check(ctx context.Context, args *HealthCheckRequest, reply *HealthCheckResponse) error {
+	reply.Status = h.status(args.Service)
+	if reply.Status == StatusUnknown {
+		return rerrors.New(rerrors.NotFound, "rpcx: unknown service "+args.Service)
+	}
+	return nil
+}
+
+// Watch sends the status of args.Service when the stream is opened and whenever it changes,
+// until the client closes the stream.
+func (h *Health) Watch(ctx context.Context, args *HealthCheckRequest, stream *Stream) error {
+	ch := make(chan ServingStatus, 1)
+	h.mu.Lock()
+	if h.watchers[args.Service] == nil {
+		h.watchers[args.Service] = make(map[chan ServingStatus]struct{})
+	}
+	h.watchers[args.Service][ch] = struct{}{}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.watchers[args.Service], ch)
+		h.mu.Unlock()
+	}()
+
+	status := h.status(args.Service)
+	for {
+		if err := stream.Send(&HealthCheckResponse{Status: status}); err != nil {
+			return err
+		}
+		select {
+		case status = <-ch:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// SetServingStatus sets the status of service and notifies its watchers.
+// An empty service sets the status of the whole server and of all its services.
+// Services that are NOT_SERVING are marked inactive in the registry so that clients stop routing to them.
+// It returns a NotFound error if service is not registered.
+func (h *Health) SetServingStatus(service string, status ServingStatus) error {
+	if service != "" && !h.server.isRegistered(service) {
+		return rerrors.New(rerrors.NotFound, "rpcx: can't find service "+service)
+	}
+
+	var services []string
+	h.mu.Lock()
+	if service == "" {
+		h.statuses = map[string]ServingStatus{"": status}
+		h.server.serviceMapMu.RLock()
+		for name := range h.server.serviceMap {
+			if name != HealthServicePath {
+				services = append(services, name)
+			}
+		}
+		h.server.serviceMapMu.RUnlock()
+		for name, watchers := range h.watchers {
+			if name == "" || h.server.isRegistered(name) {
+				notify(watchers, status)
+			}
+		}
+	} else {
+		h.statuses[service] = status
+		services = []string{service}
+		notify(h.watchers[service], status)
+	}
+	h.mu.Unlock()
+
+	state := ""
+	if status == StatusNotServing {
+		state = stateInactive
+	}
+	var es []error
+	for _, name := range services {
+		if err := h.server.updateState(name, state); err != nil {
+			es = append(es, err)
+		}
+	}
+	if len(es) > 0 {
+		return rerrors.NewMultiError(es)
+	}
+	return nil
+}
+
+func (h *Health) status(service string) ServingStatus {
+	h.mu.Lock()
+	status, ok := h.statuses[service]
+	if !ok && service != "" {
+		status, ok = h.statuses[""]
+	}
+	h.mu.Unlock()
+
+	if service != "" && !h.server.isRegistered(service) {
+		return StatusUnknown
+	}
+	if !ok {
+		return StatusServing
+	}
+	return status
+}
+
+// notify sends status to watchers, replacing the status they have not received yet.
+func notify(watchers map[chan ServingStatus]struct{}, status ServingStatus) {
+	for ch := range watchers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- status
+	}
+}
+
+func (s *Server) isRegistered(servicePath string) bool {
+	s.serviceMapMu.RLock()
+	defer s.serviceMapMu.RUnlock()
+	return s.serviceMap[servicePath] != nil
+}
@@ -15,6 +15,9 @@ import (
 func (s *Server) handleHTTP2Request(w http.ResponseWriter, r *http.Request) {
   logs.Debugf("=== Get request from serveByHTTP2, handleHTTP2Request ===")
   ctx := context.WithValue(r.Context(), RemoteConnContextKey, r.RemoteAddr) // notice: It is a string, different with TCP (net.Conn)
+  if identity := peerIdentity(r.TLS); identity != nil {
+    ctx = context.WithValue(ctx, PeerIdentityContextKey, identity)
+  }
   err := s.Plugins.DoPreReadRequest(ctx)
   if err != nil {
     logs.Errorf("DoPreReadRequest err: %+v", err)
@@ -64,11 +67,15 @@ func (s *Server) handleHTTP2Request(w http.ResponseWriter, r *http.Request) {
   }
   err = s.Plugins.DoPostReadRequest(ctx, req, nil)
   if err != nil {
-    http.Error(w, err.Error(), 500)
+    wh.Set(XMessageStatusType, "Error")
+    wh.Set(XErrorMessage, err.Error())
+    setErrorHeaders(wh, err)
+    w.WriteHeader(httpStatus(err))
     return
   }
 
   ctx = context.WithValue(ctx, StartRequestContextKey, time.Now().UnixNano())
+  ctx = share.NewContext(ctx)
   err = s.auth(ctx, req)
   if err != nil {
     s.Plugins.DoPreWriteResponse(ctx, req, nil)
@@ -79,10 +86,40 @@ func (s *Server) handleHTTP2Request(w http.ResponseWriter, r *http.Request) {
     logs.Errorf("s.auth err: %+v", err)
     return
   }
+  if err = s.authorize(ctx, req); err != nil {
+    s.Plugins.DoPreWriteResponse(ctx, req, nil)
+    wh.Set(XMessageStatusType, "Error")
+    wh.Set(XErrorMessage, err.Error())
+    setErrorHeaders(wh, err)
+    w.WriteHeader(httpStatus(err))
+    s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
+    return
+  }
+
+  if err = s.acquireInflight(req.ServicePath); err != nil {
+    wh.Set(XMessageStatusType, "Error")
+    wh.Set(XErrorMessage, err.Error())
+    setErrorHeaders(wh, err)
+    w.WriteHeader(httpStatus(err))
+    return
+  }
+  defer s.releaseInflight(req.ServicePath)
+
+  var cancel context.CancelFunc
+  ctx, cancel, err = withRequestDeadline(ctx, req.Metadata)
+  defer cancel()
+  if err != nil {
+    wh.Set(XMessageStatusType, "Error")
+    wh.Set(XErrorMessage, err.Error())
+    setErrorHeaders(wh, err)
+    w.WriteHeader(httpStatus(err))
+    return
+  }
 
   resMetadata := make(map[string]string)
   newCtx := context.WithValue(context.WithValue(ctx, share.ReqMetaDataKey, req.Metadata),
     share.ResMetaDataKey, resMetadata)
+  newCtx = withTranscoding(newCtx)
 
   res, err := s.handleRequest(newCtx, req)
   defer protocol.FreeMsg(res)
@@ -91,7 +128,8 @@ func (s *Server) handleHTTP2Request(w http.ResponseWriter, r *http.Request) {
     logs.Warnf("rpcx: failed to handle gateway request: %v", err)
     wh.Set(XMessageStatusType, "Error")
     wh.Set(XErrorMessage, err.Error())
-    w.WriteHeader(500)
+    setErrorHeaders(wh, err)
+    w.WriteHeader(httpStatus(err))
     logs.Errorf("s.handleRequest err: %+v", err)
     return
   }
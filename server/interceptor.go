@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/halokid/rpcx-plus/codec"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// Handler invokes a service method or function with the decoded args and the reply to fill.
+type Handler func(ctx context.Context, args interface{}, reply interface{}) error
+
+// Interceptor wraps the calls of service methods and functions.
+// args is the decoded argument and reply the value that is encoded as the response,
+// or the *Stream of a streaming method.
+// An interceptor calls next to go on with the call, or returns without calling it to skip the method.
+// It replaces the reply by changing the value reply points to.
+type Interceptor func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}, next Handler) error
+
+// replyCodecKey is the context key of the codec of the reply of a call.
+type replyCodecKey struct{}
+
+type replyCodec struct {
+	serializeType protocol.SerializeType
+	codec         codec.Codec
+}
+
+// WithReplyCodec returns ctx with the serialize type of the request of a call and the codec of its reply.
+// The server sets them for every call, see ReplyCodecFromContext.
+func WithReplyCodec(ctx context.Context, st protocol.SerializeType, cc codec.Codec) context.Context {
+	rc := &replyCodec{serializeType: st, codec: cc}
+	if sctx, ok := ctx.(*share.Context); ok {
+		return share.WithLocalValue(sctx, replyCodecKey{}, rc)
+	}
+	return context.WithValue(ctx, replyCodecKey{}, rc)
+}
+
+// ReplyCodecFromContext returns the serialize type of the request of a call and the codec its reply is encoded with,
+// e.g. for interceptors that keep encoded replies. ok is false outside of calls.
+func ReplyCodecFromContext(ctx context.Context) (st protocol.SerializeType, cc codec.Codec, ok bool) {
+	rc, ok := ctx.Value(replyCodecKey{}).(*replyCodec)
+	if !ok {
+		return 0, nil, false
+	}
+	return rc.serializeType, rc.codec, true
+}
+
+// chainInterceptors returns a handler that runs interceptors in order before h.
+func chainInterceptors(interceptors []Interceptor, servicePath, serviceMethod string, h Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx context.Context, args, reply interface{}) error {
+			return interceptor(ctx, servicePath, serviceMethod, args, reply, next)
+		}
+	}
+	return h
+}
+
+// intercept calls h through the interceptors of the server.
+// Panics of interceptors are returned as errors like panics of service methods and reported to PanicPlugins.
+func (s *Server) intercept(ctx context.Context, servicePath, serviceMethod string, argv, replyv interface{}, h Handler) (err error) {
+	if len(s.interceptors) == 0 {
+		err = h(ctx, argv, replyv)
+		s.reportPanic(ctx, err)
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(servicePath, serviceMethod, r)
+		}
+		s.reportPanic(ctx, err)
+	}()
+
+	return chainInterceptors(s.interceptors, servicePath, serviceMethod, h)(ctx, argv, replyv)
+}
+
+// methodHandler returns the handler that calls mtype of service.
+func methodHandler(service *service, mtype *methodType) Handler {
+	return func(ctx context.Context, args, reply interface{}) error {
+		if mtype.ArgType.Kind() != reflect.Ptr {
+			return service.call(ctx, mtype, reflect.ValueOf(args).Elem(), reflect.ValueOf(reply))
+		}
+		return service.call(ctx, mtype, reflect.ValueOf(args), reflect.ValueOf(reply))
+	}
+}
+
+// streamHandler returns the handler that calls the stream method mtype of service, its reply is the *Stream.
+func streamHandler(service *service, mtype *methodType) Handler {
+	return func(ctx context.Context, args, reply interface{}) error {
+		if mtype.ArgType.Kind() != reflect.Ptr {
+			return service.callStream(ctx, mtype, reflect.ValueOf(args).Elem(), reply.(*Stream))
+		}
+		return service.callStream(ctx, mtype, reflect.ValueOf(args), reply.(*Stream))
+	}
+}
+
+// functionHandler returns the handler that calls the function ft of service.
+func functionHandler(service *service, ft *functionType) Handler {
+	return func(ctx context.Context, args, reply interface{}) error {
+		return service.callForFunction(ctx, ft, reflect.ValueOf(args), reflect.ValueOf(reply))
+	}
+}
@@ -42,7 +42,12 @@ func (s *Server) jsonrpcHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// notification
+	// notification: the handler outlives the HTTP request, so it must not
+	// inherit the request's cancellation.
+	ctx = context.WithValue(context.Background(), RemoteConnContextKey, r.RemoteAddr)
+	if identity := PeerIdentityFromContext(r.Context()); identity != nil {
+		ctx = context.WithValue(ctx, PeerIdentityContextKey, identity)
+	}
 	go s.handleJSONRPCRequest(ctx, req, r.Header)
 }
 
@@ -80,15 +85,21 @@ func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, he
 		req.Metadata[share.AuthKey] = auth
 	}
 
+	timeout := header.Get(XTimeout)
+	if timeout != "" {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]string)
+		}
+		req.Metadata[share.DeadlineKey] = timeout
+	}
+
 	err := s.Plugins.DoPostReadRequest(ctx, req, nil)
 	if err != nil {
-		res.Error = &JSONRPCError{
-			Code:    CodeInternalJSONRPCError,
-			Message: err.Error(),
-		}
+		res.Error = jsonrpcError(err)
 		return res
 	}
 
+	ctx = share.NewContext(ctx)
 	err = s.auth(ctx, req)
 	if err != nil {
 		s.Plugins.DoPreWriteResponse(ctx, req, nil)
@@ -99,18 +110,40 @@ func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, he
 		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
 		return res
 	}
+	if err = s.authorize(ctx, req); err != nil {
+		s.Plugins.DoPreWriteResponse(ctx, req, nil)
+		res.Error = jsonrpcError(err)
+		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
+		return res
+	}
 
-	resp, err := s.handleRequest(context.Background(), req)
+	if err = s.acquireInflight(req.ServicePath); err != nil {
+		if r.ID == nil {
+			return nil
+		}
+		res.Error = jsonrpcError(err)
+		return res
+	}
+	defer s.releaseInflight(req.ServicePath)
+
+	hctx, cancel, err := withRequestDeadline(ctx, req.Metadata)
+	defer cancel()
+	if err != nil {
+		if r.ID == nil {
+			return nil
+		}
+		res.Error = jsonrpcError(err)
+		return res
+	}
+
+	resp, err := s.handleRequest(withTranscoding(hctx), req)
 	if r.ID == nil {
 		return nil
 	}
 
 	s.Plugins.DoPreWriteResponse(ctx, req, nil)
 	if err != nil {
-		res.Error = &JSONRPCError{
-			Code:    CodeInternalJSONRPCError,
-			Message: err.Error(),
-		}
+		res.Error = jsonrpcError(err)
 		return res
 	}
 
@@ -205,13 +238,12 @@ func (s *Server) startJSONRPC2(ln net.Listener) {
 	newServer := http.NewServeMux()
 	newServer.HandleFunc("/", s.jsonrpcHandler)
 
+	srv := &http.Server{Handler: newServer, ConnContext: connPeerIdentity}
 	if s.corsOptions != nil {
 		opt := cors.Options(*s.corsOptions)
 		c := cors.New(opt)
-		mux := c.Handler(newServer)
-		go http.Serve(ln, mux)
-	} else {
-		go http.Serve(ln, newServer)
+		srv.Handler = c.Handler(newServer)
 	}
+	go srv.Serve(ln)
 
 }
@@ -3,6 +3,9 @@ package server
 import (
 	"crypto/tls"
 	"time"
+
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
 )
 
 // OptionFn configures options of server.
@@ -24,6 +27,23 @@ func WithTLSConfig(cfg *tls.Config) OptionFn {
 	}
 }
 
+// WithCertReloader serves TLS with the certificates of r, they are reloaded when their files change.
+// The tcp, quic and http2 (see WithHTTP2TLS) listeners use the reloaded certificates for new connections.
+// It is applied on the tls.Config of a previous WithTLSConfig, if any.
+func WithCertReloader(r *share.CertReloader) OptionFn {
+	return func(s *Server) {
+		s.tlsConfig = r.ServerConfig(s.tlsConfig)
+	}
+}
+
+// WithHTTP2TLS serves the http2 network over TLS with the tls.Config of WithTLSConfig or WithCertReloader.
+// The http2 network is served in cleartext (h2c) without it.
+func WithHTTP2TLS() OptionFn {
+	return func(s *Server) {
+		s.http2TLS = true
+	}
+}
+
 // WithReadTimeout sets readTimeout.
 func WithReadTimeout(readTimeout time.Duration) OptionFn {
 	return func(s *Server) {
@@ -37,3 +57,75 @@ func WithWriteTimeout(writeTimeout time.Duration) OptionFn {
 		s.writeTimeout = writeTimeout
 	}
 }
+
+// WithStreamWindow sets the number of data frames a client may send on a stream
+// before the server grants more.
+func WithStreamWindow(window uint32) OptionFn {
+	return func(s *Server) {
+		s.streamWindow = window
+	}
+}
+
+// WithCompressThreshold sets the payload size above which responses are compressed
+// with the compress type of the request. The default is protocol.DefaultCompressThreshold.
+func WithCompressThreshold(threshold int) OptionFn {
+	return func(s *Server) {
+		s.compressThreshold = threshold
+	}
+}
+
+// WithDecodeLimits sets the limits of the requests read from clients.
+// Requests exceeding them are rejected and their connections are closed.
+func WithDecodeLimits(limits protocol.DecodeLimits) OptionFn {
+	return func(s *Server) {
+		s.decodeLimits = limits
+	}
+}
+
+// WithWorkerPool handles requests on workers goroutines instead of one goroutine per request.
+// Up to queueSize requests wait for a worker, further requests fail with ErrServerOverloaded.
+// Streams do not take workers, they are bounded by WithMaxInflight and WithServiceMaxInflight.
+func WithWorkerPool(workers, queueSize int) OptionFn {
+	return func(s *Server) {
+		s.poolWorkers = workers
+		s.poolQueueSize = queueSize
+	}
+}
+
+// WithMaxInflight sets the max number of requests the server handles or queues at once.
+// Further requests fail with ErrServerOverloaded.
+func WithMaxInflight(n int) OptionFn {
+	return func(s *Server) {
+		s.inflight = &inflightLimiter{max: int32(n)}
+	}
+}
+
+// WithServiceMaxInflight sets the max number of requests of servicePath handled or queued at once.
+// Further requests fail with ErrServerOverloaded.
+func WithServiceMaxInflight(servicePath string, n int) OptionFn {
+	return func(s *Server) {
+		if s.serviceInflight == nil {
+			s.serviceInflight = make(map[string]*inflightLimiter)
+		}
+		s.serviceInflight[servicePath] = &inflightLimiter{max: int32(n)}
+	}
+}
+
+// WithInterceptors adds interceptors around the calls of service methods and functions.
+// They run in the given order, the first one is the outermost.
+func WithInterceptors(interceptors ...Interceptor) OptionFn {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// WithMethodPolicy bounds the duration and the concurrency of servicePath.serviceMethod.
+// It applies to methods and functions.
+func WithMethodPolicy(servicePath, serviceMethod string, policy MethodPolicy) OptionFn {
+	return func(s *Server) {
+		if s.methodPolicies == nil {
+			s.methodPolicies = make(map[string]*methodLimiter)
+		}
+		s.methodPolicies[servicePath+"."+serviceMethod] = newMethodLimiter(policy)
+	}
+}
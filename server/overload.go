@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// ErrServerOverloaded is returned to clients whose requests are shed because the server,
+// its worker pool or the service has too many requests in flight.
+var ErrServerOverloaded = errors.New("rpcx: server overloaded")
+
+// workerPool runs requests on a fixed number of goroutines.
+// Requests wait in a bounded queue when all workers are busy.
+type workerPool struct {
+	queue    chan func()
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{
+		queue: make(chan func(), queueSize),
+		done:  make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case fn := <-p.queue:
+			fn()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// submit queues fn. It returns false if the queue is full.
+func (p *workerPool) submit(fn func()) bool {
+	select {
+	case p.queue <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *workerPool) stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+// inflightLimiter bounds the number of requests in flight.
+type inflightLimiter struct {
+	max int32
+	n   int32
+}
+
+func (l *inflightLimiter) acquire() bool {
+	if l == nil || l.max <= 0 {
+		return true
+	}
+	if atomic.AddInt32(&l.n, 1) > l.max {
+		atomic.AddInt32(&l.n, -1)
+		return false
+	}
+	return true
+}
+
+func (l *inflightLimiter) release() {
+	if l == nil || l.max <= 0 {
+		return
+	}
+	atomic.AddInt32(&l.n, -1)
+}
+
+// acquireInflight reserves a slot of the server and of servicePath.
+// It returns ErrServerOverloaded if either has reached its limit.
+func (s *Server) acquireInflight(servicePath string) error {
+	if !s.inflight.acquire() {
+		return ErrServerOverloaded
+	}
+	if !s.serviceInflight[servicePath].acquire() {
+		s.inflight.release()
+		return ErrServerOverloaded
+	}
+	return nil
+}
+
+func (s *Server) releaseInflight(servicePath string) {
+	s.serviceInflight[servicePath].release()
+	s.inflight.release()
+}
+
+// writeOverloaded tells the client that req has been shed.
+func (s *Server) writeOverloaded(conn net.Conn, req *protocol.Message) {
+	logs.Warnf("rpcx: server overloaded, request %s.%s from %s is shed", req.ServicePath, req.ServiceMethod, conn.RemoteAddr())
+	if !req.IsOneway() {
+		res := req.Clone()
+		res.SetMessageType(protocol.Response)
+		handleError(res, ErrServerOverloaded)
+		res.EncodeTo(conn)
+		protocol.FreeMsg(res)
+	}
+	protocol.FreeMsg(req)
+}
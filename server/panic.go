@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// PanicInfo describes a panic recovered while a request was handled.
+type PanicInfo struct {
+	// CorrelationID is sent to the client and logged with the stack.
+	CorrelationID string
+	ServicePath   string
+	ServiceMethod string
+	// Value is the value passed to panic.
+	Value interface{}
+	Stack []byte
+}
+
+// panicError is the error of a request whose handler has panicked.
+// Clients get an Internal error with the correlation ID, the stack is only logged.
+type panicError struct {
+	info *PanicInfo
+	err  *rerrors.Error
+}
+
+func (e *panicError) Error() string {
+	return e.err.Error()
+}
+
+func (e *panicError) Unwrap() error {
+	return e.err
+}
+
+// newPanicError logs the panic r of servicePath.serviceMethod with its stack and returns its error.
+func newPanicError(servicePath, serviceMethod string, r interface{}) *panicError {
+	info := &PanicInfo{
+		CorrelationID: newCorrelationID(),
+		ServicePath:   servicePath,
+		ServiceMethod: serviceMethod,
+		Value:         r,
+		Stack:         debug.Stack(),
+	}
+	logs.Errorf("rpcx: panic in %s.%s, correlation id: %s: %v\n%s", servicePath, serviceMethod, info.CorrelationID, r, info.Stack)
+
+	err := rerrors.New(rerrors.Internal,
+		fmt.Sprintf("[service internal error]: %s.%s panicked, correlation id: %s", servicePath, serviceMethod, info.CorrelationID),
+		&rerrors.ErrorInfo{
+			Reason:   "PANIC",
+			Domain:   "rpcx",
+			Metadata: map[string]string{"correlation_id": info.CorrelationID},
+		})
+	return &panicError{info: info, err: err}
+}
+
+func newCorrelationID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// reportPanic passes the panic behind err to the PanicPlugins.
+func (s *Server) reportPanic(ctx context.Context, err error) {
+	var perr *panicError
+	if errors.As(err, &perr) {
+		s.handlePanic(ctx, perr.info)
+	}
+}
+
+// handleRequestPanic answers req with the error of the panic r, only the caller of req is affected.
+func (s *Server) handleRequestPanic(ctx context.Context, conn net.Conn, req *protocol.Message, r interface{}) {
+	err := newPanicError(req.ServicePath, req.ServiceMethod, r)
+	s.reportPanic(ctx, err)
+	if req.IsOneway() || req.IsHeartbeat() {
+		return
+	}
+
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	handleError(res, err)
+	res.EncodeTo(conn)
+	protocol.FreeMsg(res)
+}
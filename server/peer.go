@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+
+	"github.com/soheilhy/cmux"
+)
+
+// PeerIdentityContextKey is a context key. Handlers and AuthFunc can read the *PeerIdentity
+// of the verified client certificate of mutual TLS connections from the context.
+// It is not set if the client has not presented a verified certificate.
+var PeerIdentityContextKey = &contextKey{"peer-identity"}
+
+// PeerIdentity is the identity in a verified client certificate.
+type PeerIdentity struct {
+	// Subject is the distinguished name of the certificate.
+	Subject    string
+	CommonName string
+	// DNSNames, IPAddresses, URIs and EmailAddresses are the subject alternative names.
+	DNSNames       []string
+	IPAddresses    []string
+	URIs           []string
+	EmailAddresses []string
+	// SPIFFEID is the spiffe:// URI of the certificate, if it has one.
+	SPIFFEID    string
+	Certificate *x509.Certificate
+}
+
+// PeerIdentityFromContext returns the identity of the client of a mutual TLS connection, or nil.
+func PeerIdentityFromContext(ctx context.Context) *PeerIdentity {
+	identity, _ := ctx.Value(PeerIdentityContextKey).(*PeerIdentity)
+	return identity
+}
+
+// peerIdentity returns the identity of the verified certificate of the peer of state, or nil.
+func peerIdentity(state *tls.ConnectionState) *PeerIdentity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	identity := &PeerIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+	}
+	for _, ip := range cert.IPAddresses {
+		identity.IPAddresses = append(identity.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+		if strings.EqualFold(uri.Scheme, "spiffe") && identity.SPIFFEID == "" {
+			identity.SPIFFEID = uri.String()
+		}
+	}
+	return identity
+}
+
+// withNextProtos makes the configs returned by the GetConfigForClient of cfg offer the
+// application protocols of cfg, which http2.ConfigureServer has set after they were made.
+func withNextProtos(cfg *tls.Config) {
+	getConfigForClient := cfg.GetConfigForClient
+	if getConfigForClient == nil {
+		return
+	}
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c, err := getConfigForClient(hello)
+		if c == nil || err != nil {
+			return c, err
+		}
+		c = c.Clone()
+		c.NextProtos = cfg.NextProtos
+		return c, nil
+	}
+}
+
+// connPeerIdentity is the ConnContext of the HTTP servers of the gateway. cmux hides the
+// *tls.Conn from them, so their requests have no TLS state and the identity is read from c.
+func connPeerIdentity(ctx context.Context, c net.Conn) context.Context {
+	tc, ok := tlsConn(c)
+	if !ok {
+		return ctx
+	}
+	state := tc.ConnectionState()
+	if identity := peerIdentity(&state); identity != nil {
+		return context.WithValue(ctx, PeerIdentityContextKey, identity)
+	}
+	return ctx
+}
+
+// tlsConn returns the TLS connection of conn, which the gateway may have wrapped.
+func tlsConn(conn net.Conn) (*tls.Conn, bool) {
+	if mc, ok := conn.(*cmux.MuxConn); ok {
+		conn = mc.Conn
+	}
+	tc, ok := conn.(*tls.Conn)
+	return tc, ok
+}
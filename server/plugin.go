@@ -34,6 +34,50 @@ type PluginContainer interface {
 	DoPostWriteRequest(ctx context.Context, r *protocol.Message, e error) error
 }
 
+// The extension points below have been added after PluginContainer, so they are optional for
+// its implementations. The server invokes the plugins of All() for containers that lack them.
+type (
+	// RegisterStatePluginContainer invokes RegisterStatePlugins.
+	RegisterStatePluginContainer interface {
+		DoUpdateState(name string, state string) error
+	}
+
+	// AuthorizePluginContainer invokes AuthorizePlugins.
+	AuthorizePluginContainer interface {
+		DoAuthorize(ctx context.Context, req *protocol.Message) error
+	}
+
+	// PanicPluginContainer invokes PanicPlugins.
+	PanicPluginContainer interface {
+		DoHandlePanic(ctx context.Context, info *PanicInfo)
+	}
+)
+
+// updateState invokes the RegisterStatePlugins of the server.
+func (s *Server) updateState(name string, state string) error {
+	if c, ok := s.Plugins.(RegisterStatePluginContainer); ok {
+		return c.DoUpdateState(name, state)
+	}
+	return (&pluginContainer{plugins: s.Plugins.All()}).DoUpdateState(name, state)
+}
+
+// authorize invokes the AuthorizePlugins of the server.
+func (s *Server) authorize(ctx context.Context, req *protocol.Message) error {
+	if c, ok := s.Plugins.(AuthorizePluginContainer); ok {
+		return c.DoAuthorize(ctx, req)
+	}
+	return (&pluginContainer{plugins: s.Plugins.All()}).DoAuthorize(ctx, req)
+}
+
+// handlePanic invokes the PanicPlugins of the server.
+func (s *Server) handlePanic(ctx context.Context, info *PanicInfo) {
+	if c, ok := s.Plugins.(PanicPluginContainer); ok {
+		c.DoHandlePanic(ctx, info)
+		return
+	}
+	(&pluginContainer{plugins: s.Plugins.All()}).DoHandlePanic(ctx, info)
+}
+
 // Plugin is the server plugin interface.
 type Plugin interface {
 }
@@ -45,6 +89,12 @@ type (
 		Unregister(name string) error
 	}
 
+	// RegisterStatePlugin updates the state of registered services in the registry.
+	// Clients do not route to services whose state is "inactive".
+	RegisterStatePlugin interface {
+		UpdateState(name string, state string) error
+	}
+
 	// RegisterFunctionPlugin is .
 	RegisterFunctionPlugin interface {
 		RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error
@@ -77,6 +127,13 @@ type (
 		PreHandleRequest(ctx context.Context, r *protocol.Message) error
 	}
 
+	// AuthorizePlugin decides if an authenticated request may call its service method.
+	// Requests it returns an error for are answered with the error without being handled,
+	// their connections are kept open.
+	AuthorizePlugin interface {
+		Authorize(ctx context.Context, req *protocol.Message) error
+	}
+
 	//PreWriteResponsePlugin represents .
 	PreWriteResponsePlugin interface {
 		PreWriteResponse(context.Context, *protocol.Message, *protocol.Message) error
@@ -96,6 +153,11 @@ type (
 	PostWriteRequestPlugin interface {
 		PostWriteRequest(ctx context.Context, r *protocol.Message, e error) error
 	}
+
+	// PanicPlugin is notified of the panics recovered while requests are handled, e.g. to count them.
+	PanicPlugin interface {
+		HandlePanic(ctx context.Context, info *PanicInfo)
+	}
 )
 
 // pluginContainer implements PluginContainer interface.
@@ -182,6 +244,24 @@ func (p *pluginContainer) DoUnregister(name string) error {
 	return nil
 }
 
+// DoUpdateState invokes RegisterStatePlugin.
+func (p *pluginContainer) DoUpdateState(name string, state string) error {
+	var es []error
+	for _, rp := range p.plugins {
+		if plugin, ok := rp.(RegisterStatePlugin); ok {
+			err := plugin.UpdateState(name, state)
+			if err != nil {
+				es = append(es, err)
+			}
+		}
+	}
+
+	if len(es) > 0 {
+		return errors.NewMultiError(es)
+	}
+	return nil
+}
+
 //DoPostConnAccept handles accepted conn
 func (p *pluginContainer) DoPostConnAccept(conn net.Conn) (net.Conn, bool) {
 	var flag bool
@@ -308,3 +388,24 @@ func (p *pluginContainer) DoPostWriteRequest(ctx context.Context, r *protocol.Me
 
 	return nil
 }
+
+// DoHandlePanic invokes PanicPlugin.
+func (p *pluginContainer) DoHandlePanic(ctx context.Context, info *PanicInfo) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PanicPlugin); ok {
+			plugin.HandlePanic(ctx, info)
+		}
+	}
+}
+
+// DoAuthorize invokes AuthorizePlugin plugins, the request is denied by the first error.
+func (p *pluginContainer) DoAuthorize(ctx context.Context, req *protocol.Message) error {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(AuthorizePlugin); ok {
+			if err := plugin.Authorize(ctx, req); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// ErrHandlerTimeout is returned to clients when a method runs longer than the Timeout of its policy.
+var ErrHandlerTimeout = errors.New("rpcx: handler timeout")
+
+// MethodPolicy bounds the execution of a service method or function.
+// Zero values mean no limit.
+type MethodPolicy struct {
+	// Timeout is the max duration of the handler. When it is exceeded the client gets ErrHandlerTimeout
+	// and the ctx of the handler is cancelled. The handler keeps its execution slot until it returns,
+	// so handlers should return when their ctx is done.
+	Timeout time.Duration
+	// MaxConcurrency is the max number of concurrent executions of the method.
+	MaxConcurrency int
+	// MaxQueue is the number of requests that wait for a free execution slot once MaxConcurrency
+	// is reached. Further requests fail with ErrServerOverloaded.
+	// With a worker pool, requests wait here before they take a worker.
+	MaxQueue int
+	// QueueTimeout is the max time a request waits for an execution slot,
+	// 0 waits until the request is cancelled. Requests waiting longer fail with ErrServerOverloaded.
+	QueueTimeout time.Duration
+}
+
+// methodLimiter enforces a MethodPolicy.
+type methodLimiter struct {
+	policy  MethodPolicy
+	slots   chan struct{}
+	waiting int32
+}
+
+func newMethodLimiter(policy MethodPolicy) *methodLimiter {
+	l := &methodLimiter{policy: policy}
+	if policy.MaxConcurrency > 0 {
+		l.slots = make(chan struct{}, policy.MaxConcurrency)
+	}
+	return l
+}
+
+// tryAcquire reserves an execution slot if one is free.
+func (l *methodLimiter) tryAcquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire reserves an execution slot, waiting in the queue if the policy allows it.
+func (l *methodLimiter) acquire(ctx context.Context) error {
+	if l.slots == nil || l.tryAcquire() {
+		return nil
+	}
+
+	if atomic.AddInt32(&l.waiting, 1) > int32(l.policy.MaxQueue) {
+		atomic.AddInt32(&l.waiting, -1)
+		return ErrServerOverloaded
+	}
+	defer atomic.AddInt32(&l.waiting, -1)
+
+	var timeout <-chan time.Time
+	if l.policy.QueueTimeout > 0 {
+		t := time.NewTimer(l.policy.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrServerOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *methodLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+type policySlotKey struct{}
+
+// policySlot is an execution slot reserved before the request has got a worker of the pool.
+type policySlot struct {
+	l     *methodLimiter
+	taken int32
+}
+
+// withPolicySlot passes slot to the handler of the request of ctx.
+func withPolicySlot(ctx *share.Context, slot *policySlot) {
+	share.WithLocalValue(ctx, policySlotKey{}, slot)
+}
+
+// take makes the caller responsible for the release of the slot.
+func (s *policySlot) take() bool {
+	return atomic.CompareAndSwapInt32(&s.taken, 0, 1)
+}
+
+// release releases the slot unless the handler has taken it.
+func (s *policySlot) release() {
+	if s.take() {
+		s.l.release()
+	}
+}
+
+// wrap returns a handler that runs h within the limits of the policy.
+// With a Timeout, ErrHandlerTimeout is returned as soon as it is exceeded, so args and reply
+// may still be used by h.
+func (l *methodLimiter) wrap(h Handler) Handler {
+	return func(ctx context.Context, args, reply interface{}) error {
+		if slot, ok := ctx.Value(policySlotKey{}).(*policySlot); !ok || slot.l != l || !slot.take() {
+			if err := l.acquire(ctx); err != nil {
+				return err
+			}
+		}
+
+		if l.policy.Timeout <= 0 {
+			defer l.release()
+			return h(ctx, args, reply)
+		}
+
+		tctx, cancel, merge := detachHandler(ctx, l.policy.Timeout)
+		done := make(chan error, 1)
+		go func() {
+			defer l.release()
+			defer cancel()
+			done <- h(tctx, args, reply)
+		}()
+
+		select {
+		case err := <-done:
+			merge()
+			return err
+		case <-tctx.Done():
+		}
+		if tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return ErrHandlerTimeout
+		}
+		// the request has been cancelled or its deadline has passed, h is expected to return soon.
+		err := <-done
+		merge()
+		return err
+	}
+}
+
+// detachHandler returns the context of a handler that may outlive its request.
+// The handler gets its own values and metadata, so that it does not race with the response
+// to a timeout. merge copies them back to ctx once the handler has returned in time.
+func detachHandler(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, func()) {
+	sctx, isShare := ctx.(*share.Context)
+	parent := ctx
+	if isShare {
+		parent = sctx.Context
+	}
+	tctx, cancel := context.WithTimeout(parent, timeout)
+
+	reqMetadata, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	resMetadata, _ := ctx.Value(share.ResMetaDataKey).(map[string]string)
+	hreqMetadata := copyMetadata(reqMetadata)
+	hresMetadata := copyMetadata(resMetadata)
+
+	var hctx context.Context
+	var hsctx *share.Context
+	if isShare {
+		hsctx = sctx.Clone(tctx)
+		hctx = hsctx
+	} else {
+		hctx = tctx
+	}
+	if reqMetadata != nil {
+		hctx = withValue(hctx, share.ReqMetaDataKey, hreqMetadata)
+	}
+	if resMetadata != nil {
+		hctx = withValue(hctx, share.ResMetaDataKey, hresMetadata)
+	}
+
+	merge := func() {
+		if hsctx != nil {
+			if reqMetadata != nil {
+				hsctx.SetValue(share.ReqMetaDataKey, reqMetadata)
+			}
+			if resMetadata != nil {
+				hsctx.SetValue(share.ResMetaDataKey, resMetadata)
+			}
+			sctx.Merge(hsctx)
+		}
+		for k, v := range hresMetadata {
+			resMetadata[k] = v
+		}
+	}
+	return hctx, cancel, merge
+}
+
+// withValue sets key in ctx itself if it is a *share.Context.
+func withValue(ctx context.Context, key, val interface{}) context.Context {
+	if sctx, ok := ctx.(*share.Context); ok {
+		return share.WithLocalValue(sctx, key, val)
+	}
+	return context.WithValue(ctx, key, val)
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	m := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		m[k] = v
+	}
+	return m
+}
+
+// handlerPayload returns the payload args are decoded from.
+// A handler that may outlive req gets a copy, as the buffer of req is reused once it is answered.
+func (s *Server) handlerPayload(req *protocol.Message) []byte {
+	l := s.methodPolicies[req.ServicePath+"."+req.ServiceMethod]
+	if l == nil || l.policy.Timeout <= 0 {
+		return req.Payload
+	}
+	return append([]byte(nil), req.Payload...)
+}
+
+// withPolicy applies the policy of servicePath.serviceMethod to h.
+func (s *Server) withPolicy(servicePath, serviceMethod string, h Handler) Handler {
+	l := s.methodPolicies[servicePath+"."+serviceMethod]
+	if l == nil {
+		return h
+	}
+	return l.wrap(h)
+}
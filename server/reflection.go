@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	pb "github.com/golang/protobuf/proto"
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ReflectionServicePath is the service path of the reflection service registered by RegisterReflection.
+const ReflectionServicePath = "Reflection"
+
+// ListServicesRequest asks for the services of the server.
+type ListServicesRequest struct{}
+
+// ListServicesResponse is the sorted names of the services of the server.
+type ListServicesResponse struct {
+	Services []string
+}
+
+// DescribeServiceRequest asks for the methods of Service.
+type DescribeServiceRequest struct {
+	Service string
+}
+
+// ServiceDescriptor describes a service and its methods.
+type ServiceDescriptor struct {
+	Name    string
+	Methods []*MethodDescriptor
+	// FileDescriptorSet is a serialized google.protobuf.FileDescriptorSet with the proto files
+	// that define the protobuf messages of the methods, and their imports.
+	FileDescriptorSet []byte `json:",omitempty"`
+}
+
+// MethodDescriptor describes the args and the reply of a method.
+// The schemas are JSON Schema documents of the JSON encoding of the types,
+// the protojson encoding for protobuf messages.
+type MethodDescriptor struct {
+	Name string
+	// Stream is true for streaming methods, their replies are not described.
+	Stream      bool `json:",omitempty"`
+	ArgsType    string
+	ArgsSchema  string
+	ReplyType   string `json:",omitempty"`
+	ReplySchema string `json:",omitempty"`
+	// ArgsMessage and ReplyMessage are the full names of the types that are protobuf messages.
+	ArgsMessage  string `json:",omitempty"`
+	ReplyMessage string `json:",omitempty"`
+}
+
+// Reflection is the reflection service of a server.
+// It lets tools and dynamic clients discover the services of the server without their Go source.
+type Reflection struct {
+	server *Server
+}
+
+// RegisterReflection registers the reflection service of s as ReflectionServicePath and returns it.
+func (s *Server) RegisterReflection() (*Reflection, error) {
+	r := &Reflection{server: s}
+	if err := s.RegisterName(ReflectionServicePath, r, ""); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListServices returns the names of the registered services.
+func (r *Reflection) ListServices(ctx context.Context, args *ListServicesRequest, reply *ListServicesResponse) error {
+	r.server.serviceMapMu.RLock()
+	services := make([]string, 0, len(r.server.serviceMap))
+	for name := range r.server.serviceMap {
+		services = append(services, name)
+	}
+	r.server.serviceMapMu.RUnlock()
+
+	sort.Strings(services)
+	reply.Services = services
+	return nil
+}
+
+// DescribeService describes the methods and functions of args.Service.
+// It fails with a NotFound error for unknown services.
+func (r *Reflection) DescribeService(ctx context.Context, args *DescribeServiceRequest, reply *ServiceDescriptor) error {
+	r.server.serviceMapMu.RLock()
+	service := r.server.serviceMap[args.Service]
+	r.server.serviceMapMu.RUnlock()
+	if service == nil {
+		return rerrors.New(rerrors.NotFound, "rpcx: unknown service "+args.Service)
+	}
+
+	files := newFileSet()
+	var methods []*MethodDescriptor
+	for name, mtype := range service.method {
+		md, err := describeMethod(name, mtype.ArgType, mtype.ReplyType, mtype.stream, files)
+		if err != nil {
+			return err
+		}
+		methods = append(methods, md)
+	}
+	for name, ft := range service.function {
+		md, err := describeMethod(name, ft.ArgType, ft.ReplyType, false, files)
+		if err != nil {
+			return err
+		}
+		methods = append(methods, md)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Name < methods[j].Name
+	})
+
+	data, err := files.marshal()
+	if err != nil {
+		return err
+	}
+	reply.Name = args.Service
+	reply.Methods = methods
+	reply.FileDescriptorSet = data
+	return nil
+}
+
+func describeMethod(name string, argType, replyType reflect.Type, stream bool, files *fileSet) (*MethodDescriptor, error) {
+	md := &MethodDescriptor{Name: name, Stream: stream}
+
+	var err error
+	md.ArgsType = argType.String()
+	if md.ArgsSchema, err = schemaString(argType); err != nil {
+		return nil, err
+	}
+	md.ArgsMessage = files.addMessage(argType)
+	if stream {
+		return md, nil
+	}
+
+	md.ReplyType = replyType.String()
+	if md.ReplySchema, err = schemaString(replyType); err != nil {
+		return nil, err
+	}
+	md.ReplyMessage = files.addMessage(replyType)
+	return md, nil
+}
+
+func schemaString(t reflect.Type) (string, error) {
+	data, err := json.Marshal(jsonSchema(t))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// messageDescriptor returns the descriptor of t if t is a protobuf message.
+func messageDescriptor(t reflect.Type) protoreflect.MessageDescriptor {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	switch m := reflect.New(t).Interface().(type) {
+	case protov2.Message:
+		return m.ProtoReflect().Descriptor()
+	case pb.Message:
+		return pb.MessageV2(m).ProtoReflect().Descriptor()
+	}
+	return nil
+}
+
+// fileSet collects the proto files of protobuf messages, every file follows its imports.
+type fileSet struct {
+	seen  map[string]bool
+	files []*descriptorpb.FileDescriptorProto
+}
+
+func newFileSet() *fileSet {
+	return &fileSet{seen: make(map[string]bool)}
+}
+
+// addMessage adds the file of t if t is a protobuf message and returns the full name of the message.
+func (fs *fileSet) addMessage(t reflect.Type) string {
+	md := messageDescriptor(t)
+	if md == nil {
+		return ""
+	}
+	fs.addFile(md.ParentFile())
+	return string(md.FullName())
+}
+
+func (fs *fileSet) addFile(fd protoreflect.FileDescriptor) {
+	if fs.seen[fd.Path()] {
+		return
+	}
+	fs.seen[fd.Path()] = true
+
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		fs.addFile(imports.Get(i).FileDescriptor)
+	}
+	fs.files = append(fs.files, protodesc.ToFileDescriptorProto(fd))
+}
+
+func (fs *fileSet) marshal() ([]byte, error) {
+	if len(fs.files) == 0 {
+		return nil, nil
+	}
+	return protov2.Marshal(&descriptorpb.FileDescriptorSet{File: fs.files})
+}
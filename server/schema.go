@@ -0,0 +1,235 @@
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// jsonSchemaDialect is the JSON Schema version of the schemas of the reflection service.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema of the JSON encoding of a Go type, or of the protojson encoding of a protobuf message.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfRawMessage    = reflect.TypeOf(json.RawMessage{})
+	typeOfJSONMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// jsonSchema returns the JSON Schema of t. Named struct types are defined in $defs
+// so that recursive types can be described.
+func jsonSchema(t reflect.Type) *Schema {
+	defs := make(map[string]*Schema)
+	s := schemaOf(t, defs)
+	s.Schema = jsonSchemaDialect
+	if len(defs) > 0 {
+		s.Defs = defs
+	}
+	return s
+}
+
+func schemaOf(t reflect.Type, defs map[string]*Schema) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// protobuf messages are transcoded with protojson
+	if md := messageDescriptor(t); md != nil {
+		return messageSchema(md, defs)
+	}
+
+	switch {
+	case t == typeOfTime:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == typeOfRawMessage:
+		return &Schema{}
+	case t.Implements(typeOfJSONMarshaler) || reflect.PtrTo(t).Implements(typeOfJSONMarshaler):
+		// the encoding is up to the type
+		return &Schema{}
+	case t.Implements(typeOfTextMarshaler) || reflect.PtrTo(t).Implements(typeOfTextMarshaler):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		name := t.String()
+		if _, ok := defs[name]; !ok {
+			// reserve the name before the fields are walked, they may refer to t
+			defs[name] = nil
+			defs[name] = structSchema(t, defs)
+		}
+		return &Schema{Ref: "#/$defs/" + name}
+	default:
+		// interfaces can hold any value, channels and functions are not encoded
+		return &Schema{}
+	}
+}
+
+// structSchema describes the fields of t as encoding/json encodes them.
+func structSchema(t reflect.Type, defs map[string]*Schema) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, skip := jsonFieldName(f)
+		if skip {
+			continue
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.PkgPath != "" && !(f.Anonymous && ft.Kind() == reflect.Struct) {
+			// unexported fields are not encoded, unless they are embedded structs
+			continue
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			// fields of embedded structs are promoted
+			embedded := structSchema(ft, defs)
+			for n, p := range embedded.Properties {
+				if _, ok := s.Properties[n]; !ok {
+					s.Properties[n] = p
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaOf(f.Type, defs)
+	}
+	return s
+}
+
+// jsonFieldName returns the name of f in the json tag.
+// skip is true for the fields that encoding/json does not encode.
+func jsonFieldName(f reflect.StructField) (name string, skip bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	if strings.HasPrefix(f.Name, "XXX_") {
+		return "", true
+	}
+	if idx := strings.Index(tag, ","); idx >= 0 {
+		tag = tag[:idx]
+	}
+	return tag, false
+}
+
+// messageSchema returns the schema of the protojson encoding of md.
+// Messages are defined in $defs by their full names.
+func messageSchema(md protoreflect.MessageDescriptor, defs map[string]*Schema) *Schema {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return &Schema{Type: "string", Format: "date-time"}
+	case "google.protobuf.Duration", "google.protobuf.FieldMask":
+		return &Schema{Type: "string"}
+	case "google.protobuf.Struct":
+		return &Schema{Type: "object"}
+	case "google.protobuf.ListValue":
+		return &Schema{Type: "array"}
+	case "google.protobuf.Value", "google.protobuf.Any":
+		return &Schema{}
+	case "google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value", "google.protobuf.Int64Value",
+		"google.protobuf.UInt64Value", "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		// wrappers are encoded as their value
+		return fieldKindSchema(md.Fields().ByName("value"), defs)
+	}
+
+	name := string(md.FullName())
+	if _, ok := defs[name]; !ok {
+		// reserve the name before the fields are walked, they may refer to md
+		defs[name] = nil
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		fields := md.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			s.Properties[fd.JSONName()] = fieldSchema(fd, defs)
+		}
+		defs[name] = s
+	}
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+func fieldSchema(fd protoreflect.FieldDescriptor, defs map[string]*Schema) *Schema {
+	switch {
+	case fd.IsMap():
+		return &Schema{Type: "object", AdditionalProperties: fieldKindSchema(fd.MapValue(), defs)}
+	case fd.IsList():
+		return &Schema{Type: "array", Items: fieldKindSchema(fd, defs)}
+	}
+	return fieldKindSchema(fd, defs)
+}
+
+// fieldKindSchema describes a single value of fd, 64-bit integers are strings and enums their names.
+func fieldKindSchema(fd protoreflect.FieldDescriptor, defs map[string]*Schema) *Schema {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return &Schema{Type: "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &Schema{Type: "integer"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return &Schema{Type: "string", Format: "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &Schema{Type: "string", Format: "uint64"}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return &Schema{Type: "number"}
+	case protoreflect.StringKind:
+		return &Schema{Type: "string"}
+	case protoreflect.BytesKind:
+		return &Schema{Type: "string", ContentEncoding: "base64"}
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return &Schema{Type: "null"}
+		}
+		values := fd.Enum().Values()
+		s := &Schema{Type: "string"}
+		for i := 0; i < values.Len(); i++ {
+			s.Enum = append(s.Enum, string(values.Get(i).Name()))
+		}
+		return s
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageSchema(fd.Message(), defs)
+	}
+	return &Schema{}
+}
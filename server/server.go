@@ -9,7 +9,6 @@ import (
   "io"
   "net"
   "net/http"
-  "reflect"
   "regexp"
   "runtime"
   "strings"
@@ -21,6 +20,7 @@ import (
   "os/signal"
   "syscall"
 
+  rerrors "github.com/halokid/rpcx-plus/errors"
   logs "github.com/halokid/rpcx-plus/log"
   "github.com/halokid/rpcx-plus/protocol"
   "github.com/halokid/rpcx-plus/share"
@@ -65,6 +65,7 @@ type Server struct {
   readTimeout        time.Duration
   writeTimeout       time.Duration
   gatewayHTTPServer  *http.Server
+  adminHTTPServer    *http.Server
   DisableHTTPGateway bool // should disable http invoke or not.
   DisableJSONRPC     bool // should disable json rpc or not.
 
@@ -75,12 +76,16 @@ type Server struct {
   activeConn map[net.Conn]struct{}
   doneChan   chan struct{}
   seq        uint64
+  // goAwayConns are the connections whose clients have agreed to get going away frames, with their handshake.
+  goAwayConns map[net.Conn]*protocol.Handshake
 
   inShutdown int32
   onShutdown []func(s *Server)
 
   // TLSConfig for creating tls tcp connection.
   tlsConfig *tls.Config
+  // http2TLS serves the http2 network over TLS instead of h2c.
+  http2TLS bool
   // BlockCrypt for kcp.BlockCrypt
   options map[string]interface{}
 
@@ -90,29 +95,71 @@ type Server struct {
   Plugins PluginContainer
 
   // AuthFunc can be used to auth.
+  // ctx is a *share.Context, values set on it with SetValue are seen by the handler of req.
   AuthFunc func(ctx context.Context, req *protocol.Message, token string) error
 
   handlerMsgNum int32
+
+  // streamWindow is the receive window of streams, 0 means protocol.DefaultStreamWindow.
+  streamWindow uint32
+
+  // compressThreshold is the payload size above which responses are compressed,
+  // 0 means protocol.DefaultCompressThreshold.
+  compressThreshold int
+
+  // decodeLimits bounds the requests read from clients.
+  decodeLimits protocol.DecodeLimits
+
+  // pool handles requests if poolWorkers is set, otherwise each request has its own goroutine.
+  poolWorkers   int
+  poolQueueSize int
+  pool          *workerPool
+  // inflight and serviceInflight shed requests when too many are in flight.
+  inflight        *inflightLimiter
+  serviceInflight map[string]*inflightLimiter
+
+  // interceptors wrap the calls of service methods and functions.
+  interceptors []Interceptor
+  // methodPolicies bounds the methods by "servicePath.serviceMethod".
+  methodPolicies map[string]*methodLimiter
+
+  // health is the health service registered by RegisterHealth.
+  health *Health
+  // draining is set when Shutdown has told the clients to go away.
+  draining int32
 }
 
 // NewServer returns a server.
 func NewServer(options ...OptionFn) *Server {
   s := &Server{
-    Plugins:    &pluginContainer{},
-    options:    make(map[string]interface{}),
-    activeConn: make(map[net.Conn]struct{}),
-    doneChan:   make(chan struct{}),
-    serviceMap: make(map[string]*service),
+    Plugins:     &pluginContainer{},
+    options:     make(map[string]interface{}),
+    activeConn:  make(map[net.Conn]struct{}),
+    goAwayConns: make(map[net.Conn]*protocol.Handshake),
+    doneChan:    make(chan struct{}),
+    serviceMap:  make(map[string]*service),
   }
 
   for _, op := range options {
     op(s)
   }
 
+  if s.poolWorkers > 0 {
+    s.pool = newWorkerPool(s.poolWorkers, s.poolQueueSize)
+  }
+
   logs.Debugf("Server default options 1 ------- %+v", s.options)
   return s
 }
 
+// getCompressThreshold returns the payload size above which responses are compressed.
+func (s *Server) getCompressThreshold() int {
+  if s.compressThreshold > 0 {
+    return s.compressThreshold
+  }
+  return protocol.DefaultCompressThreshold
+}
+
 // Address returns listened address.
 func (s *Server) Address() net.Addr {
   s.mu.RLock()
@@ -157,8 +204,7 @@ func (s *Server) SendMessage(conn net.Conn, servicePath, serviceMethod string, m
   req.Metadata = metadata
   req.Payload = data
 
-  reqData := req.Encode()
-  _, err := conn.Write(reqData)
+  _, err := req.EncodeTo(conn)
   s.Plugins.DoPostWriteRequest(ctx, req, err)
   protocol.FreeMsg(req)
   return err
@@ -361,12 +407,31 @@ func (s *Server) serveByHTTP2(address string) {
     Handler: h2c.NewHandler(handler, h2s),
   }
   fmt.Printf("http2 Listening [%s]...\n", address)
+  if s.http2TLS {
+    if s.tlsConfig == nil {
+      logs.Errorf("rpcx: http2 TLS needs WithTLSConfig or WithCertReloader")
+      return
+    }
+    // certificates are read through the config on each handshake, so reloaded ones are used.
+    server.TLSConfig = s.tlsConfig.Clone()
+    if err := http2.ConfigureServer(server, h2s); err != nil {
+      logs.Errorf("rpcx: failed to configure http2 TLS: %v", err)
+      return
+    }
+    withNextProtos(server.TLSConfig)
+    server.ListenAndServeTLS("", "")
+    return
+  }
   //return server.ListenAndServe()
   server.ListenAndServe()
   //return nil
 }
 
 func (s *Server) serveConn(conn net.Conn) {
+  streams := newStreamSet()
+  calls := newCallSet()
+  // agreed is set when the client has sent a handshake.
+  var agreed *protocol.Handshake
 
   defer func() { // todo: 在defer函数捕获recover，获取服务奔溃的信息
     if err := recover(); err != any(nil) {
@@ -379,8 +444,11 @@ func (s *Server) serveConn(conn net.Conn) {
       buf = buf[:ss]
       logs.Errorf("serving %s panic error: %s, stack:\n %s", conn.RemoteAddr(), err, buf)
     }
+    streams.cancelAll()
+    calls.cancelAll()
     s.mu.Lock()
     delete(s.activeConn, conn)
+    delete(s.goAwayConns, conn)
     s.mu.Unlock()
     conn.Close()
 
@@ -392,24 +460,29 @@ func (s *Server) serveConn(conn net.Conn) {
     return
   }
 
-  if tlsConn, ok := conn.(*tls.Conn); ok {
+  // identity is the verified client certificate of mutual TLS connections.
+  var identity *PeerIdentity
+  if tc, ok := tlsConn(conn); ok {
     if d := s.readTimeout; d != 0 {
       conn.SetReadDeadline(time.Now().Add(d))
     }
     if d := s.writeTimeout; d != 0 {
       conn.SetWriteDeadline(time.Now().Add(d))
     }
-    if err := tlsConn.Handshake(); err != nil {
+    if err := tc.Handshake(); err != nil {
       logs.Errorf("rpcx: TLS handshake error from %s: %v", conn.RemoteAddr(), err)
       return
     }
+    state := tc.ConnectionState()
+    identity = peerIdentity(&state)
   }
 
   // 读取客户端请求的数据，TCP/IP会按照 ReaderBuffersize 的大下限制来读取数据包，假如大于 ReaderBuffsize，则读取多次, 分配 ReaderBuffsize长度的byte
   r := bufio.NewReaderSize(conn, ReaderBuffsize)
 
   for {
-    if isShutdown(s) {
+    // the connections of a draining server are closed once their calls are finished.
+    if isShutdown(s) && !s.isDraining() {
       closeChannel(s, conn)
       return
     }
@@ -420,11 +493,25 @@ func (s *Server) serveConn(conn net.Conn) {
     }
 
     ctx := share.WithValue(context.Background(), RemoteConnContextKey, conn)
+    if identity != nil {
+      ctx = share.WithLocalValue(ctx, PeerIdentityContextKey, identity)
+    }
 
     // todo: 根据rpcx的协议格式来decode读取到的数据
     req, err := s.readRequest(ctx, r)
     logs.Debugf("req: %+v, err: %+v", req, err)
 
+    var cerr *protocol.ChecksumError
+    if errors.As(err, &cerr) {
+      s.handleChecksumError(conn, req, cerr)
+      continue
+    }
+    // requests rejected by PostReadRequest plugins with an rpcx error are answered, the connection is kept.
+    var serr *rerrors.Error
+    if req != nil && errors.As(err, &serr) {
+      s.writeRejected(conn, req, serr)
+      continue
+    }
     if err != nil {
       if err == io.EOF {
         logs.Debugf("client has closed this conn: %s", conn.RemoteAddr().String())
@@ -440,11 +527,43 @@ func (s *Server) serveConn(conn net.Conn) {
       conn.SetWriteDeadline(t0.Add(s.writeTimeout))
     }
 
+    // the handshake is sent as a heartbeat so it is answered before auth.
+    if req.FrameType() == protocol.FrameHandshake {
+      agreed = s.handleHandshake(conn, req)
+      if agreed != nil && agreed.HasFeature(protocol.FeatureGoAway) {
+        s.mu.Lock()
+        s.goAwayConns[conn] = agreed
+        s.mu.Unlock()
+      }
+      continue
+    }
+
+    // a draining server finishes the calls in flight but refuses new ones.
+    if s.isDraining() && !req.IsHeartbeat() && !isFollowupFrame(req) {
+      s.writeGoingAway(conn, req)
+      continue
+    }
+
     ctx = share.WithLocalValue(ctx, StartRequestContextKey, time.Now().UnixNano())
+    if agreed != nil {
+      ctx = share.WithLocalValue(ctx, HandshakeContextKey, agreed)
+    }
     var closeConn = false
-    if !req.IsHeartbeat() {
+    if !req.IsHeartbeat() && !isFollowupFrame(req) {
       err = s.auth(ctx, req)
       closeConn = err != nil
+      if err == nil {
+        err = s.authorize(ctx, req)
+      }
+    }
+    if err == nil && agreed != nil && !req.IsHeartbeat() {
+      err = checkHandshake(agreed, req)
+    }
+
+    // apply the deadline of the caller, expired requests are rejected without being handled.
+    var releaseDeadline context.CancelFunc
+    if err == nil && !req.IsHeartbeat() && !isFollowupFrame(req) && (agreed == nil || agreed.HasFeature(protocol.FeatureDeadline)) {
+      ctx.Context, releaseDeadline, err = withRequestDeadline(ctx.Context, req.Metadata)
     }
 
     if err != nil {
@@ -452,14 +571,16 @@ func (s *Server) serveConn(conn net.Conn) {
       if !req.IsOneway() {
         res := req.Clone()
         res.SetMessageType(protocol.Response)
-        if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
+        if agreed != nil {
+          res.SetVersion(agreed.Version)
+        }
+        if len(res.Payload) > s.getCompressThreshold() && req.CompressType() != protocol.None {
           res.SetCompressType(req.CompressType())
         }
         handleError(res, err)
-        data := res.Encode()
 
         s.Plugins.DoPreWriteResponse(ctx, req, res)
-        conn.Write(data)
+        res.EncodeTo(conn)
         s.Plugins.DoPostWriteResponse(ctx, req, res, err)
         protocol.FreeMsg(res)
       } else {
@@ -474,18 +595,60 @@ func (s *Server) serveConn(conn net.Conn) {
       continue
     }
 
+    if req.FrameType() == protocol.FrameCancel {
+      s.handleCancelFrame(calls, streams, req)
+      continue
+    }
+
+    if req.IsStreamFrame() {
+      s.handleStreamFrame(ctx, conn, streams, req, releaseDeadline)
+      continue
+    }
+
+    // shed the request when the server or its service is too busy.
+    if !req.IsHeartbeat() {
+      if err := s.acquireInflight(req.ServicePath); err != nil {
+        if releaseDeadline != nil {
+          releaseDeadline()
+        }
+        s.writeOverloaded(conn, req)
+        continue
+      }
+    }
+
+    // the client cancels the context of a request with a cancel frame.
+    var cancel context.CancelFunc
+    if !req.IsHeartbeat() && !req.IsOneway() {
+      ctx.Context, cancel = context.WithCancel(ctx.Context)
+      calls.add(req.Seq(), cancel)
+    }
+
     // todo: 服务端处理客户端数据的gor
-    go func() {
+    atomic.AddInt32(&s.handlerMsgNum, 1)
+    handle := func() {
+      // a panic fails this request only, the connection and its other requests go on.
+      defer func() {
+        if r := recover(); r != nil {
+          s.handleRequestPanic(ctx, conn, req, r)
+        }
+      }()
       logs.Debug("server handle go func ----------------")
       logs.Debugf("req 1: %+v ==> %+v ==> %+v \n <===== server handle =====>\n\n", time.Now(), req, string(req.Payload[:]))
-      atomic.AddInt32(&s.handlerMsgNum, 1)
       defer atomic.AddInt32(&s.handlerMsgNum, -1)
+      if !req.IsHeartbeat() {
+        defer s.releaseInflight(req.ServicePath)
+      }
+      if cancel != nil {
+        defer calls.remove(req.Seq())
+      }
+      if releaseDeadline != nil {
+        defer releaseDeadline()
+      }
 
       if req.IsHeartbeat() {
         req.SetMessageType(protocol.Response)
-        data := req.Encode()
-        logs.Debugf("isHeartbeat data: %+v", data)
-        conn.Write(data)
+        logs.Debugf("isHeartbeat seq: %+v", req.Seq())
+        req.EncodeTo(conn)
         //conn.Write([]byte("xxxxxx"))		// fixme: just my test
         return
       }
@@ -523,25 +686,81 @@ func (s *Server) serveConn(conn net.Conn) {
           }
         }
 
-        if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
+        if len(res.Payload) > s.getCompressThreshold() && req.CompressType() != protocol.None {
           res.SetCompressType(req.CompressType())
         }
-        data := res.Encode()
-        //logs.Debugf("No Heartbeat data: %+v", string(data[:]))
-        logs.Debugf("No Heartbeat data len: %+v", len(data[:]))
-        //logx.Printf("No Heartbeat data: %+v", string(data[:]))
-        conn.Write(data) // todo: res就是在服务端执行的结果，这里是把结果写回给客户端， 假如注释，client就会一直阻塞在监听服务端的返回
-        //res.WriteTo(conn)
+        // todo: res就是在服务端执行的结果，这里是把结果写回给客户端， 假如注释，client就会一直阻塞在监听服务端的返回
+        // header和payload通过writev一起写入，不需要拼接成一个新的[]byte
+        n, _ := res.EncodeTo(conn)
+        logs.Debugf("No Heartbeat data len: %+v", n)
       }
       s.Plugins.DoPostWriteResponse(newCtx, req, res, err)
 
       protocol.FreeMsg(req)
       protocol.FreeMsg(res)
-    }()
+    }
+
+    // drop undoes the accounting of a request that is rejected before it is handled.
+    drop := func() {
+      atomic.AddInt32(&s.handlerMsgNum, -1)
+      s.releaseInflight(req.ServicePath)
+      if cancel != nil {
+        calls.remove(req.Seq())
+      }
+      if releaseDeadline != nil {
+        releaseDeadline()
+      }
+    }
 
+    if s.pool == nil || req.IsHeartbeat() {
+      go handle()
+    } else if l := s.methodPolicies[req.ServicePath+"."+req.ServiceMethod]; l != nil && l.slots != nil {
+      // the request waits in the queue of its method before it takes a worker of the pool.
+      submit := func() {
+        slot := &policySlot{l: l}
+        withPolicySlot(ctx, slot)
+        if !s.pool.submit(func() { defer slot.release(); handle() }) {
+          slot.release()
+          drop()
+          s.writeOverloaded(conn, req)
+        }
+      }
+      if l.tryAcquire() {
+        submit()
+      } else {
+        go func() {
+          if err := l.acquire(ctx); err != nil {
+            drop()
+            s.writeRejected(conn, req, err)
+            return
+          }
+          submit()
+        }()
+      }
+    } else if !s.pool.submit(handle) {
+      drop()
+      s.writeOverloaded(conn, req)
+    }
   } // END FOR
 }
 
+// writeRejected answers a request rejected before it is handled.
+func (s *Server) writeRejected(conn net.Conn, req *protocol.Message, err error) {
+  logs.Warnf("rpcx: request %s.%s from %s is rejected: %v", req.ServicePath, req.ServiceMethod, conn.RemoteAddr(), err)
+  if !req.IsOneway() {
+    res := req.Clone()
+    res.SetMessageType(protocol.Response)
+    res.Payload = nil
+    handleError(res, err)
+    if s.writeTimeout != 0 {
+      conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+    }
+    res.EncodeTo(conn)
+    protocol.FreeMsg(res)
+  }
+  protocol.FreeMsg(req)
+}
+
 func isShutdown(s *Server) bool {
   // todo: atomic.LoadInt32表示在load数据的时候，数据不会被其他gor修改
   return atomic.LoadInt32(&s.inShutdown) == 1
@@ -563,7 +782,7 @@ func (s *Server) readRequest(ctx context.Context, r io.Reader) (req *protocol.Me
   // todo: sync.Pool 重用 protocol Message结构体
   req = protocol.GetPooledMsg()
   logs.Debugf("readRequest req原本是一个空结构体 -------------- %+v", req)
-  err = req.Decode(r)
+  err = req.DecodeWithLimits(r, &s.decodeLimits)
   if err == io.EOF {
     return req, err
   }
@@ -594,7 +813,7 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
   service := s.serviceMap[serviceName] // 服务端执行的时候，会把服务的对象句柄放在serviceMap里面, serviceName作为key
   s.serviceMapMu.RUnlock()
   if service == nil {
-    err = errors.New("rpcx: can't find service " + serviceName)
+    err = rerrors.New(rerrors.Unimplemented, "rpcx: can't find service "+serviceName)
     return handleError(res, err)
   }
   mtype := service.method[methodName]
@@ -602,21 +821,25 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
     if service.function[methodName] != nil { //check raw functions
       return s.handleRequestForFunction(ctx, req)
     }
-    err = errors.New("rpcx: can't find method " + methodName)
+    err = rerrors.New(rerrors.Unimplemented, "rpcx: can't find method "+methodName)
+    return handleError(res, err)
+  }
+  if mtype.stream {
+    err = errors.New("rpcx: method " + methodName + " is a stream method")
     return handleError(res, err)
   }
 
   var argv = argsReplyPools.Get(mtype.ArgType)
 
   // todo: 获取序列化配适器
-  codec := share.Codecs[req.SerializeType()]
+  codec := getCodec(ctx, req.SerializeType())
   logs.Debugf("请求数据用的codec类型--------- %+v", req.SerializeType())
   if codec == nil {
     err = fmt.Errorf("can not find codec for %d", req.SerializeType())
     return handleError(res, err)
   }
 
-  err = codec.Decode(req.Payload, argv)
+  err = codec.Decode(s.handlerPayload(req), argv)
   if err != nil {
     return handleError(res, err)
   }
@@ -624,14 +847,15 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
   replyv := argsReplyPools.Get(mtype.ReplyType)
 
   // todo: 执行services的方法，执行的结果写入reply, reply是指针， 所以call方法之后就会改变reply
-  if mtype.ArgType.Kind() != reflect.Ptr {
-    // 如果method arg的类型不是指针
-    err = service.call(ctx, mtype, reflect.ValueOf(argv).Elem(), reflect.ValueOf(replyv))
-  } else {
-    // 如果method arg的类型是指针
-    err = service.call(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
-  }
+  ctx = WithReplyCodec(ctx, req.SerializeType(), codec)
+  start := mtype.stats.begin()
+  err = s.intercept(ctx, serviceName, methodName, argv, replyv, s.withPolicy(serviceName, methodName, methodHandler(service, mtype)))
+  mtype.stats.end(start, err)
 
+  if errors.Is(err, ErrHandlerTimeout) {
+    // the handler may still use argv and replyv.
+    return handleError(res, err)
+  }
   argsReplyPools.Put(mtype.ArgType, argv)
   if err != nil {
     argsReplyPools.Put(mtype.ReplyType, replyv)
@@ -667,32 +891,39 @@ func (s *Server) handleRequestForFunction(ctx context.Context, req *protocol.Mes
   service := s.serviceMap[serviceName]
   s.serviceMapMu.RUnlock()
   if service == nil {
-    err = errors.New("rpcx: can't find service  for func raw function")
+    err = rerrors.New(rerrors.Unimplemented, "rpcx: can't find service  for func raw function")
     return handleError(res, err)
   }
   mtype := service.function[methodName]
   if mtype == nil {
-    err = errors.New("rpcx: can't find method " + methodName)
+    err = rerrors.New(rerrors.Unimplemented, "rpcx: can't find method "+methodName)
     return handleError(res, err)
   }
 
   var argv = argsReplyPools.Get(mtype.ArgType)
 
-  codec := share.Codecs[req.SerializeType()]
+  codec := getCodec(ctx, req.SerializeType())
   if codec == nil {
     err = fmt.Errorf("can not find codec for %d", req.SerializeType())
     return handleError(res, err)
   }
 
-  err = codec.Decode(req.Payload, argv)
+  err = codec.Decode(s.handlerPayload(req), argv)
   if err != nil {
     return handleError(res, err)
   }
 
   replyv := argsReplyPools.Get(mtype.ReplyType)
 
-  err = service.callForFunction(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
+  ctx = WithReplyCodec(ctx, req.SerializeType(), codec)
+  start := mtype.stats.begin()
+  err = s.intercept(ctx, serviceName, methodName, argv, replyv, s.withPolicy(serviceName, methodName, functionHandler(service, mtype)))
+  mtype.stats.end(start, err)
 
+  if errors.Is(err, ErrHandlerTimeout) {
+    // the handler may still use argv and replyv.
+    return handleError(res, err)
+  }
   argsReplyPools.Put(mtype.ArgType, argv)
 
   if err != nil {
@@ -721,7 +952,16 @@ func handleError(res *protocol.Message, err error) (*protocol.Message, error) {
   if res.Metadata == nil {
     res.Metadata = make(map[string]string)
   }
+  // the errors of rpcx, such as ErrHandlerTimeout, have a code too.
+  // Other errors have none, so that clients return them as a ServiceError.
+  var serr *rerrors.Error
+  if e := toError(err); errors.As(err, &serr) || e.Code != rerrors.Unknown {
+    e.SetMetadata(res.Metadata)
+  }
   res.Metadata[protocol.ServiceError] = err.Error()
+  if err == ErrServerOverloaded {
+    res.Metadata[protocol.ServiceOverloaded] = "1"
+  }
   return res, err
 }
 
@@ -764,6 +1004,12 @@ func (s *Server) Close() error {
     delete(s.activeConn, c)
     s.Plugins.DoPostConnClose(c)
   }
+  if s.adminHTTPServer != nil {
+    s.adminHTTPServer.Close()
+  }
+  if s.pool != nil {
+    s.pool.stop()
+  }
   s.closeDoneChanLocked()
   return err
 }
@@ -779,29 +1025,31 @@ func (s *Server) RegisterOnShutdown(f func(s *Server)) {
 var shutdownPollInterval = 1000 * time.Millisecond
 
 // Shutdown gracefully shuts down the server without interrupting any
-// active connections. Shutdown works by first closing the
-// listener, then closing all idle connections, and then waiting
-// indefinitely for connections to return to idle and then shut down.
+// active connections. Shutdown works by first marking the services
+// inactive in the registries and sending a going away message to the
+// connected clients that have agreed it in the handshake (client.Option.Handshake),
+// other clients learn it from refused requests or closed connections.
+// It then closes the listener, refuses new requests,
+// and waits indefinitely for the calls in flight to finish
+// before the connections are closed.
 // If the provided context expires before the shutdown is complete,
 // Shutdown returns the context's error, otherwise it returns any
 // error returned from closing the Server's underlying Listener.
 func (s *Server) Shutdown(ctx context.Context) error {
   var err error
+  // clients are told to go away before the server stops taking requests.
+  s.drain()
   if atomic.CompareAndSwapInt32(&s.inShutdown, 0, 1) {
     logs.Info("shutdown begin")
 
     s.mu.Lock()
     s.ln.Close()
-    for conn := range s.activeConn {
-      if tcpConn, ok := conn.(*net.TCPConn); ok {
-        tcpConn.CloseRead()
-      }
-    }
     s.mu.Unlock()
 
     // wait all in-processing requests finish.
     ticker := time.NewTicker(shutdownPollInterval)
     defer ticker.Stop()
+  wait:
     for {
       if s.checkProcessMsg() {
         break
@@ -809,7 +1057,7 @@ func (s *Server) Shutdown(ctx context.Context) error {
       select {
       case <-ctx.Done():
         err = ctx.Err()
-        break
+        break wait
       case <-ticker.C:
       }
     }
@@ -828,9 +1076,17 @@ func (s *Server) Shutdown(ctx context.Context) error {
       delete(s.activeConn, conn)
       s.Plugins.DoPostConnClose(conn)
     }
+    if s.pool != nil {
+      s.pool.stop()
+    }
     s.closeDoneChanLocked()
     s.mu.Unlock()
 
+    // the admin server is closed last, it shows the progress of the shutdown.
+    if err := s.closeAdmin(ctx); err != nil {
+      logs.Warnf("failed to close admin server: %v", err)
+    }
+
     logs.Info("shutdown end")
 
   }
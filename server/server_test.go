@@ -3,13 +3,24 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"time"
 
 	"github.com/halokid/rpcx-plus/_testutils"
+	rerrors "github.com/halokid/rpcx-plus/errors"
 	"github.com/halokid/rpcx-plus/protocol"
 	"github.com/halokid/rpcx-plus/share"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type Args struct {
@@ -110,3 +121,665 @@ func TestHandleRequest(t *testing.T) {
 		t.Fatalf("expect 200 but got %d", reply.C)
 	}
 }
+
+func TestAcquireInflight(t *testing.T) {
+	s := NewServer(WithMaxInflight(3), WithServiceMaxInflight("Arith", 2))
+
+	for i := 0; i < 2; i++ {
+		if err := s.acquireInflight("Arith"); err != nil {
+			t.Fatalf("failed to acquire: %v", err)
+		}
+	}
+	if err := s.acquireInflight("Arith"); err != ErrServerOverloaded {
+		t.Fatalf("expect ErrServerOverloaded of the service but got %v", err)
+	}
+	if err := s.acquireInflight("Echo"); err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if err := s.acquireInflight("Echo"); err != ErrServerOverloaded {
+		t.Fatalf("expect ErrServerOverloaded of the server but got %v", err)
+	}
+
+	s.releaseInflight("Arith")
+	if err := s.acquireInflight("Arith"); err != nil {
+		t.Fatalf("failed to acquire after release: %v", err)
+	}
+}
+
+func TestHTTP2_Inflight(t *testing.T) {
+	s := NewServer(WithServiceMaxInflight("Arith", 1))
+	s.RegisterName("Arith", new(Arith), "")
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"A": 10, "B": 20}`))
+		r.Header.Set(XServicePath, "Arith")
+		r.Header.Set(XServiceMethod, "Mul")
+		r.Header.Set(XSerializeType, "1")
+		return r
+	}
+
+	if err := s.acquireInflight("Arith"); err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	w := httptest.NewRecorder()
+	s.handleHTTP2Request(w, newRequest())
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expect 429 but got %d", w.Code)
+	}
+
+	s.releaseInflight("Arith")
+	w = httptest.NewRecorder()
+	s.handleHTTP2Request(w, newRequest())
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "200") {
+		t.Fatalf("expect 200 but got %d %s", w.Code, w.Body.String())
+	}
+	if err := s.acquireInflight("Arith"); err != nil {
+		t.Fatalf("expect the request to release its slot but got %v", err)
+	}
+}
+
+type ProtoArith int
+
+func (t *ProtoArith) Double(ctx context.Context, args *wrapperspb.Int64Value, reply *wrapperspb.Int64Value) error {
+	reply.Value = args.Value * 2
+	return nil
+}
+
+func TestHandleRequest_ProtoJSON(t *testing.T) {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.SetSeq(1234567890)
+	req.ServicePath = "ProtoArith"
+	req.ServiceMethod = "Double"
+	// protobuf JSON encodes int64 as a string
+	req.Payload = []byte(`"21"`)
+
+	server := NewServer()
+	server.RegisterName("ProtoArith", new(ProtoArith), "")
+
+	res, err := server.handleRequest(withTranscoding(context.Background()), req)
+	if err != nil {
+		t.Fatalf("failed to hand request: %v", err)
+	}
+	if string(res.Payload) != `"42"` {
+		t.Fatalf("expect \"42\" but got %s", res.Payload)
+	}
+
+	// native clients keep using the JSON codec
+	_, err = server.handleRequest(context.Background(), req)
+	if err == nil {
+		t.Fatal("expect an error without transcoding")
+	}
+}
+
+func TestHandleRequest_Interceptors(t *testing.T) {
+	var calls []string
+	validate := func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}, next Handler) error {
+		calls = append(calls, "validate "+servicePath+"."+serviceMethod)
+		if args.(*Args).B == 0 {
+			return errors.New("B must not be 0")
+		}
+		return next(ctx, args, reply)
+	}
+	double := func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}, next Handler) error {
+		calls = append(calls, "double")
+		err := next(ctx, args, reply)
+		reply.(*Reply).C *= 2
+		return err
+	}
+
+	server := NewServer(WithInterceptors(validate, double))
+	server.RegisterName("Arith", new(Arith), "")
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = []byte(`{"A":10,"B":20}`)
+
+	res, err := server.handleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failed to hand request: %v", err)
+	}
+	if string(res.Payload) != `{"C":400}` {
+		t.Fatalf("expect {\"C\":400} but got %s", res.Payload)
+	}
+	if len(calls) != 2 || calls[0] != "validate Arith.Mul" || calls[1] != "double" {
+		t.Fatalf("unexpected interceptor calls: %v", calls)
+	}
+
+	req.Payload = []byte(`{"A":10,"B":0}`)
+	res, err = server.handleRequest(context.Background(), req)
+	if err == nil || res.Metadata[protocol.ServiceError] != "B must not be 0" {
+		t.Fatalf("expect the validation error but got %v", err)
+	}
+}
+
+type Slow int
+
+func (t *Slow) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(args.A) * time.Millisecond):
+		return nil
+	}
+}
+
+func TestHandleRequest_MethodPolicy(t *testing.T) {
+	server := NewServer(WithMethodPolicy("Slow", "Wait", MethodPolicy{Timeout: 50 * time.Millisecond}))
+	server.RegisterName("Slow", new(Slow), "")
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "Slow"
+	req.ServiceMethod = "Wait"
+	req.Payload = []byte(`{"A":1000}`)
+
+	start := time.Now()
+	_, err := server.handleRequest(context.Background(), req)
+	if err != ErrHandlerTimeout {
+		t.Fatalf("expect ErrHandlerTimeout but got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("the handler has not been cancelled")
+	}
+
+	req.Payload = []byte(`{"A":1}`)
+	if _, err = server.handleRequest(context.Background(), req); err != nil {
+		t.Fatalf("failed to hand request: %v", err)
+	}
+}
+
+func TestMethodLimiter(t *testing.T) {
+	l := newMethodLimiter(MethodPolicy{MaxConcurrency: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+
+	// the queued request times out
+	if err := l.acquire(context.Background()); err != ErrServerOverloaded {
+		t.Fatalf("expect ErrServerOverloaded after QueueTimeout but got %v", err)
+	}
+
+	// the queued request gets the released slot while the next one is rejected
+	queued := make(chan error, 1)
+	go func() { queued <- l.acquire(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	if err := l.acquire(context.Background()); err != ErrServerOverloaded {
+		t.Fatalf("expect ErrServerOverloaded with a full queue but got %v", err)
+	}
+	l.release()
+	if err := <-queued; err != nil {
+		t.Fatalf("failed to acquire from the queue: %v", err)
+	}
+	l.release()
+}
+
+func TestErrorMapping(t *testing.T) {
+	err := rerrors.New(rerrors.NotFound, "no such user", &rerrors.ErrorInfo{Reason: "USER_NOT_FOUND"})
+	if status := httpStatus(err); status != http.StatusNotFound {
+		t.Fatalf("expect 404 but got %d", status)
+	}
+	if status := httpStatus(ErrServerOverloaded); status != http.StatusTooManyRequests {
+		t.Fatalf("expect 429 but got %d", status)
+	}
+	if status := httpStatus(errors.New("boom")); status != http.StatusInternalServerError {
+		t.Fatalf("expect 500 but got %d", status)
+	}
+
+	jerr := jsonrpcError(rerrors.New(rerrors.Unimplemented, "rpcx: can't find method Foo"))
+	if jerr.Code != CodeMethodNotFound {
+		t.Fatalf("expect %d but got %d", CodeMethodNotFound, jerr.Code)
+	}
+	jerr = jsonrpcError(err)
+	if jerr.Code != -32015 || jerr.Data == nil || !strings.Contains(string(*jerr.Data), "USER_NOT_FOUND") {
+		t.Fatalf("unexpected JSON-RPC error: %d %v", jerr.Code, jerr.Data)
+	}
+
+	res := protocol.NewMessage()
+	handleError(res, err)
+	got := rerrors.FromMetadata(res.Metadata)
+	if got == nil || got.Code != rerrors.NotFound || got.Message != "no such user" {
+		t.Fatalf("unexpected error in metadata: %v", res.Metadata)
+	}
+	if info, ok := got.Details[0].(*rerrors.ErrorInfo); !ok || info.Reason != "USER_NOT_FOUND" {
+		t.Fatalf("unexpected detail: %#v", got.Details[0])
+	}
+
+	for err, code := range map[error]rerrors.Code{
+		ErrHandlerTimeout:   rerrors.DeadlineExceeded,
+		ErrRequestExpired:   rerrors.DeadlineExceeded,
+		ErrServerOverloaded: rerrors.ResourceExhausted,
+	} {
+		res := protocol.NewMessage()
+		handleError(res, err)
+		if got := rerrors.FromMetadata(res.Metadata); got == nil || got.Code != code || got.Message != err.Error() {
+			t.Errorf("expect %v for %v but got %v", code, err, got)
+		}
+	}
+	res = protocol.NewMessage()
+	handleError(res, errors.New("boom"))
+	if got := rerrors.FromMetadata(res.Metadata); got != nil || res.Metadata[protocol.ServiceError] != "boom" {
+		t.Fatalf("expect an error without code but got %v", res.Metadata)
+	}
+
+	header := make(http.Header)
+	setErrorHeaders(header, rerrors.New(rerrors.ResourceExhausted, "rate limited", &rerrors.RetryInfo{RetryDelay: 1500 * time.Millisecond}))
+	if header.Get("Retry-After") != "2" || header.Get(XErrorCode) != "8" {
+		t.Fatalf("unexpected headers: %v", header)
+	}
+}
+
+func TestOverloaded_ErrorMapping(t *testing.T) {
+	s := NewServer(WithMaxInflight(1))
+	s.RegisterName("Whoami", new(Whoami), "")
+	if err := s.acquireInflight("Whoami"); err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	defer s.releaseInflight("Whoami")
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	r.Header.Set(XServicePath, "Whoami")
+	r.Header.Set(XServiceMethod, "Get")
+	r.Header.Set(XSerializeType, "1")
+	w := httptest.NewRecorder()
+	s.handleGatewayRequest(w, r, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(XErrorCode) != "8" {
+		t.Fatalf("expect 429 with code 8 but got %d %v", w.Code, w.Header())
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "Whoami.Get", "params": {}}`))
+	w = httptest.NewRecorder()
+	s.jsonrpcHandler(w, r)
+	var res struct {
+		Error *JSONRPCError
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body.String(), err)
+	}
+	if res.Error == nil || res.Error.Code != -32018 {
+		t.Fatalf("expect a ResourceExhausted error but got %s", w.Body.String())
+	}
+}
+
+type authKey struct{}
+
+type Whoami int
+
+func (t *Whoami) Get(ctx context.Context, args *Args, reply *string) error {
+	*reply, _ = ctx.Value(authKey{}).(string)
+	return nil
+}
+
+func TestJSONRPC_AuthContext(t *testing.T) {
+	s := NewServer()
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		ctx.(*share.Context).SetValue(authKey{}, token)
+		return nil
+	}
+	s.RegisterName("Whoami", new(Whoami), "")
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "Whoami.Get", "params": {}}`))
+	r.Header.Set("Authorization", "alice")
+	w := httptest.NewRecorder()
+	s.jsonrpcHandler(w, r)
+
+	var res struct {
+		Result string
+		Error  *JSONRPCError
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body.String(), err)
+	}
+	if res.Error != nil || res.Result != "alice" {
+		t.Fatalf("expect the auth values in the handler context but got %s", w.Body.String())
+	}
+}
+
+type Panicker int
+
+func (t *Panicker) Panic(ctx context.Context, args *Args, reply *Reply) error {
+	panic("boom")
+}
+
+type panicCounter struct {
+	infos []*PanicInfo
+}
+
+func (p *panicCounter) HandlePanic(ctx context.Context, info *PanicInfo) {
+	p.infos = append(p.infos, info)
+}
+
+func TestHandleRequest_Panic(t *testing.T) {
+	counter := &panicCounter{}
+	server := NewServer()
+	server.Plugins.Add(counter)
+	server.RegisterName("Panicker", new(Panicker), "")
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "Panicker"
+	req.ServiceMethod = "Panic"
+	req.Payload = []byte(`{"A":1,"B":2}`)
+
+	res, err := server.handleRequest(context.Background(), req)
+	if rerrors.CodeOf(err) != rerrors.Internal {
+		t.Fatalf("expect an Internal error but got %v", err)
+	}
+	if len(counter.infos) != 1 {
+		t.Fatalf("expect 1 reported panic but got %d", len(counter.infos))
+	}
+	info := counter.infos[0]
+	if info.Value != "boom" || info.ServiceMethod != "Panic" || len(info.Stack) == 0 {
+		t.Fatalf("unexpected panic info: %+v", info)
+	}
+	if !strings.Contains(res.Metadata[protocol.ServiceError], info.CorrelationID) {
+		t.Fatalf("expect the correlation id in the error but got %s", res.Metadata[protocol.ServiceError])
+	}
+}
+
+type stateRecorder struct {
+	states map[string]string
+}
+
+func (p *stateRecorder) UpdateState(name string, state string) error {
+	p.states[name] = state
+	return nil
+}
+
+type denyAuthorizer struct{}
+
+func (denyAuthorizer) Authorize(ctx context.Context, req *protocol.Message) error {
+	return rerrors.New(rerrors.PermissionDenied, "denied")
+}
+
+func TestPluginContainer_Optional(t *testing.T) {
+	s := NewServer()
+	// a container with the methods of PluginContainer only
+	s.Plugins = struct{ PluginContainer }{&pluginContainer{}}
+	if _, ok := s.Plugins.(AuthorizePluginContainer); ok {
+		t.Fatal("expect a container without DoAuthorize")
+	}
+	recorder := &stateRecorder{states: make(map[string]string)}
+	s.Plugins.Add(recorder)
+	s.Plugins.Add(denyAuthorizer{})
+
+	if err := s.authorize(context.Background(), protocol.NewMessage()); rerrors.CodeOf(err) != rerrors.PermissionDenied {
+		t.Fatalf("expect the AuthorizePlugin invoked but got %v", err)
+	}
+	if err := s.updateState("Arith", stateInactive); err != nil || recorder.states["Arith"] != stateInactive {
+		t.Fatalf("expect the RegisterStatePlugin invoked but got %v, %v", recorder.states, err)
+	}
+	counter := &panicCounter{}
+	s.Plugins.Add(counter)
+	s.handlePanic(context.Background(), &PanicInfo{})
+	if len(counter.infos) != 1 {
+		t.Fatalf("expect the PanicPlugin invoked")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	recorder := &stateRecorder{states: make(map[string]string)}
+	server := NewServer()
+	server.Plugins.Add(recorder)
+	server.RegisterName("Arith", new(Arith), "")
+	h, err := server.RegisterHealth()
+	if err != nil {
+		t.Fatalf("failed to register health: %v", err)
+	}
+
+	check := func(service string) (ServingStatus, error) {
+		reply := &HealthCheckResponse{}
+		err := h.Check(context.Background(), &HealthCheckRequest{Service: service}, reply)
+		return reply.Status, err
+	}
+
+	if status, err := check("Arith"); err != nil || status != StatusServing {
+		t.Fatalf("expect SERVING but got %v, %v", status, err)
+	}
+	if _, err := check("Missing"); rerrors.CodeOf(err) != rerrors.NotFound {
+		t.Fatalf("expect NotFound but got %v", err)
+	}
+
+	if err := h.SetServingStatus("Missing", StatusNotServing); rerrors.CodeOf(err) != rerrors.NotFound {
+		t.Fatalf("expect NotFound but got %v", err)
+	}
+	if _, ok := recorder.states["Missing"]; ok {
+		t.Fatalf("expect the registry not updated for an unknown service")
+	}
+
+	h.SetServingStatus("Arith", StatusNotServing)
+	if status, _ := check("Arith"); status != StatusNotServing {
+		t.Fatalf("expect NOT_SERVING but got %v", status)
+	}
+	if recorder.states["Arith"] != "inactive" {
+		t.Fatalf("expect Arith inactive in the registry but got %q", recorder.states["Arith"])
+	}
+
+	h.SetServingStatus("", StatusServing)
+	if status, _ := check("Arith"); status != StatusServing {
+		t.Fatalf("expect SERVING but got %v", status)
+	}
+	if state, ok := recorder.states["Arith"]; !ok || state != "" {
+		t.Fatalf("expect Arith active in the registry but got %q", state)
+	}
+}
+
+func TestWithRequestDeadline(t *testing.T) {
+	ctx, cancel, err := withRequestDeadline(context.Background(), nil)
+	cancel()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatal("expect no deadline")
+	}
+
+	ctx, cancel, err = withRequestDeadline(context.Background(), map[string]string{share.DeadlineKey: "2s"})
+	defer cancel()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("expect a deadline")
+	}
+	if left := time.Until(deadline); left <= time.Second || left > 2*time.Second {
+		t.Fatalf("expect about 2s left but got %v", left)
+	}
+
+	_, cancel, err = withRequestDeadline(context.Background(), map[string]string{share.DeadlineKey: "-1ms"})
+	cancel()
+	if err != ErrRequestExpired {
+		t.Fatalf("expect ErrRequestExpired but got %v", err)
+	}
+}
+
+func TestCheckHandshake(t *testing.T) {
+	agreed := &protocol.Handshake{Agreed: true, Version: 1, Serializers: []protocol.SerializeType{protocol.JSON}}
+
+	req := protocol.NewMessage()
+	req.SetVersion(1)
+	req.SetSerializeType(protocol.JSON)
+	if err := checkHandshake(agreed, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req.SetVersion(0)
+	if err := checkHandshake(agreed, req); err == nil {
+		t.Fatal("expect an error for a version that has not been agreed")
+	}
+
+	req.SetVersion(1)
+	req.SetFrameType(protocol.FrameStreamOpen)
+	if err := checkHandshake(agreed, req); err == nil {
+		t.Fatal("expect an error for a feature that has not been agreed")
+	}
+}
+
+type Node struct {
+	Name     string    `json:"name"`
+	Children []*Node   `json:"children,omitempty"`
+	Tags     []byte    `json:"-"`
+	Created  time.Time `json:"created"`
+}
+
+type Tree int
+
+func (t *Tree) Size(ctx context.Context, args *Node, reply *int) error {
+	*reply = 1
+	return nil
+}
+
+type PBArith int
+
+func (t *PBArith) Mul(ctx context.Context, args *testutils.ProtoArgs, reply *testutils.ProtoReply) error {
+	reply.C = args.A * args.B
+	return nil
+}
+
+func TestReflection(t *testing.T) {
+	server := NewServer()
+	server.RegisterName("Tree", new(Tree), "")
+	server.RegisterName("ProtoArith", new(ProtoArith), "")
+	server.RegisterName("PBArith", new(PBArith), "")
+	r, err := server.RegisterReflection()
+	if err != nil {
+		t.Fatalf("failed to register reflection: %v", err)
+	}
+
+	list := &ListServicesResponse{}
+	r.ListServices(context.Background(), &ListServicesRequest{}, list)
+	if strings.Join(list.Services, ",") != "PBArith,ProtoArith,Reflection,Tree" {
+		t.Fatalf("unexpected services: %v", list.Services)
+	}
+
+	desc := &ServiceDescriptor{}
+	if err := r.DescribeService(context.Background(), &DescribeServiceRequest{Service: "Tree"}, desc); err != nil {
+		t.Fatalf("failed to describe Tree: %v", err)
+	}
+	if len(desc.Methods) != 1 || desc.Methods[0].Name != "Size" || desc.FileDescriptorSet != nil {
+		t.Fatalf("unexpected descriptor: %+v", desc)
+	}
+	var schema Schema
+	if err := json.Unmarshal([]byte(desc.Methods[0].ArgsSchema), &schema); err != nil {
+		t.Fatalf("invalid schema %s: %v", desc.Methods[0].ArgsSchema, err)
+	}
+	node := schema.Defs["server.Node"]
+	if schema.Ref != "#/$defs/server.Node" || node == nil {
+		t.Fatalf("unexpected schema: %s", desc.Methods[0].ArgsSchema)
+	}
+	if node.Properties["children"].Items.Ref != schema.Ref || node.Properties["created"].Format != "date-time" || node.Properties["Tags"] != nil {
+		t.Fatalf("unexpected schema of Node: %s", desc.Methods[0].ArgsSchema)
+	}
+	if desc.Methods[0].ReplySchema != `{"$schema":"https://json-schema.org/draft/2020-12/schema","type":"integer"}` {
+		t.Fatalf("unexpected reply schema: %s", desc.Methods[0].ReplySchema)
+	}
+
+	// protobuf messages are described as protojson encodes them
+	schema = *jsonSchema(reflect.TypeOf(&descriptorpb.FieldDescriptorProto{}))
+	field := schema.Defs["google.protobuf.FieldDescriptorProto"]
+	if schema.Ref != "#/$defs/google.protobuf.FieldDescriptorProto" || field == nil || field.Properties["jsonName"] == nil || field.Properties["json_name"] != nil {
+		t.Fatalf("unexpected schema of FieldDescriptorProto: %+v", field)
+	}
+	if label := field.Properties["label"]; label.Type != "string" || !strings.Contains(strings.Join(label.Enum, ","), "LABEL_REPEATED") {
+		t.Fatalf("expect the names of the enum but got %+v", label)
+	}
+	option := schema.Defs["google.protobuf.UninterpretedOption"]
+	if option == nil || option.Properties["negativeIntValue"].Type != "string" || option.Properties["stringValue"].ContentEncoding != "base64" ||
+		option.Properties["name"].Items.Ref != "#/$defs/google.protobuf.UninterpretedOption.NamePart" {
+		t.Fatalf("unexpected schema of UninterpretedOption: %+v", option)
+	}
+	if s := jsonSchema(reflect.TypeOf(&wrapperspb.Int64Value{})); s.Type != "string" || s.Defs != nil {
+		t.Fatalf("expect a wrapper described as its value but got %+v", s)
+	}
+
+	for _, name := range []string{"ProtoArith", "PBArith"} {
+		desc = &ServiceDescriptor{}
+		if err := r.DescribeService(context.Background(), &DescribeServiceRequest{Service: name}, desc); err != nil {
+			t.Fatalf("failed to describe %s: %v", name, err)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(desc.FileDescriptorSet, &set); err != nil {
+			t.Fatalf("invalid descriptor set of %s: %v", name, err)
+		}
+		files, err := protodesc.NewFiles(&set)
+		if err != nil {
+			t.Fatalf("invalid descriptor set of %s: %v", name, err)
+		}
+		if _, err := files.FindDescriptorByName(protoreflect.FullName(desc.Methods[0].ArgsMessage)); err != nil {
+			t.Fatalf("failed to find %s: %v", desc.Methods[0].ArgsMessage, err)
+		}
+	}
+
+	if err := r.DescribeService(context.Background(), &DescribeServiceRequest{Service: "Missing"}, desc); rerrors.CodeOf(err) != rerrors.NotFound {
+		t.Fatalf("expect NotFound but got %v", err)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	recorder := &stateRecorder{states: make(map[string]string)}
+	server := NewServer()
+	server.Plugins.Add(recorder)
+	server.RegisterName("Arith", new(Arith), "")
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = []byte(`{"A":10,"B":20}`)
+	if _, err := server.handleRequest(context.Background(), req); err != nil {
+		t.Fatalf("failed to handle request: %v", err)
+	}
+
+	handler := server.AdminHandler()
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := do(http.MethodGet, "/services")
+	var services []*AdminService
+	if err := json.Unmarshal(w.Body.Bytes(), &services); err != nil {
+		t.Fatalf("failed to decode services %s: %v", w.Body.String(), err)
+	}
+	if len(services) != 1 || services[0].Name != "Arith" || len(services[0].Methods) != 3 || services[0].Methods[1].Name != "Mul" {
+		t.Fatalf("unexpected services: %s", w.Body.String())
+	}
+	if stats := services[0].Methods[1].Stats; stats.Calls != 1 || stats.Errors != 0 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats of Arith.Mul: %+v", stats)
+	}
+
+	w = do(http.MethodPut, "/services/Arith/state?state=inactive")
+	if w.Code != http.StatusNoContent || recorder.states["Arith"] != "inactive" {
+		t.Fatalf("expect Arith inactive but got %d, %q", w.Code, recorder.states["Arith"])
+	}
+	if w = do(http.MethodPut, "/services/Arith/state?state=unknown"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expect 400 but got %d", w.Code)
+	}
+	if w = do(http.MethodDelete, "/connections/127.0.0.1:1"); w.Code != http.StatusNotFound {
+		t.Fatalf("expect 404 but got %d", w.Code)
+	}
+
+	if w = do(http.MethodPost, "/drain"); w.Code != http.StatusAccepted {
+		t.Fatalf("expect 202 but got %d", w.Code)
+	}
+	var info AdminServerInfo
+	w = do(http.MethodGet, "/server")
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || !info.Draining {
+		t.Fatalf("expect the server draining but got %s", w.Body.String())
+	}
+
+	for _, target := range []string{"/plugins", "/connections", "/version", "/debug/pprof/"} {
+		if w = do(http.MethodGet, target); w.Code != http.StatusOK {
+			t.Fatalf("expect 200 for %s but got %d", target, w.Code)
+		}
+	}
+}
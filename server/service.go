@@ -13,6 +13,7 @@ import (
 
 	rerrors "github.com/halokid/rpcx-plus/errors"
 	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/share"
 )
 
 // Precompute the reflect type for error. Can't use error directly
@@ -27,7 +28,8 @@ type methodType struct {
 	method     reflect.Method
 	ArgType    reflect.Type
 	ReplyType  reflect.Type
-	// numCalls   uint
+	stream     bool // the third argument is *Stream
+	stats      methodStats
 }
 
 type functionType struct {
@@ -35,6 +37,7 @@ type functionType struct {
 	fn         reflect.Value
 	ArgType    reflect.Type
 	ReplyType  reflect.Type
+	stats      methodStats
 }
 
 type service struct {
@@ -65,11 +68,14 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 //	- three arguments, the first is of context.Context, both of exported type for three arguments
 //	- the third argument is a pointer
 //	- one return value, of type error
+// Methods whose third argument is *Stream are registered as streaming methods.
 // It returns an error if the receiver is not an exported type or has
 // no suitable methods. It also logs the error.
 // The client accesses each method using a string of the form "Type.Method",
 // where Type is the receiver's concrete type.
+// The serializers of the server are advertised in metadata unless it already names them.
 func (s *Server) Register(rcvr interface{}, metadata string) error {
+	metadata = share.WithSerializersMetadata(metadata)
 	sname, err := s.register(rcvr, "", false)
 	if err != nil {
 		return err
@@ -80,6 +86,7 @@ func (s *Server) Register(rcvr interface{}, metadata string) error {
 // RegisterName is like Register but uses the provided name for the type
 // instead of the receiver's concrete type.
 func (s *Server) RegisterName(name string, rcvr interface{}, metadata string) error {
+	metadata = share.WithSerializersMetadata(metadata)
 	s.Plugins.DoRegister(name, rcvr, metadata)		// todo: 服务真正写入consul的逻辑
 	_, err := s.register(rcvr, name, true)
 	return err
@@ -91,6 +98,7 @@ func (s *Server) RegisterName(name string, rcvr interface{}, metadata string) er
 //	- one return value, of type error
 // The client accesses function using a string of the form "servicePath.Method".
 func (s *Server) RegisterFunction(servicePath string, fn interface{}, metadata string) error {
+	metadata = share.WithSerializersMetadata(metadata)
 	fname, err := s.registerFunction(servicePath, fn, "", false)
 	if err != nil {
 		return err
@@ -102,6 +110,7 @@ func (s *Server) RegisterFunction(servicePath string, fn interface{}, metadata s
 // RegisterFunctionName is like RegisterFunction but uses the provided name for the function
 // instead of the function's concrete type.
 func (s *Server) RegisterFunctionName(servicePath string, name string, fn interface{}, metadata string) error {
+	metadata = share.WithSerializersMetadata(metadata)
 	s.Plugins.DoRegisterFunction(servicePath, name, fn, metadata)
 	_, err := s.registerFunction(servicePath, fn, name, true)
 	return err
@@ -293,6 +302,11 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 			}
 			continue
 		}
+		if replyType == typeOfStream {
+			methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType, stream: true}
+			argsReplyPools.Init(argType)
+			continue
+		}
 		methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType}
 
 		argsReplyPools.Init(argType)
@@ -321,16 +335,7 @@ func (s *Server) UnregisterAll() error {
 func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv reflect.Value) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			var buf = make([]byte, 4096)
-			n := runtime.Stack(buf, false)
-			buf = buf[:n]
-
-			err = fmt.Errorf("[service internal error]: %v, method: %s, argv: %+v",
-				r, mtype.method.Name, argv.Interface())
-
-			err2 := fmt.Errorf("[service internal error]: %v, method: %s, argv: %+v, stack: %s",
-				r, mtype.method.Name, argv.Interface(), buf)
-			logs.Handle(err2)
+			err = newPanicError(s.name, mtype.method.Name, r)
 		}
 	}()
 
@@ -346,13 +351,27 @@ func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv refl
 	return nil
 }
 
+func (s *service) callStream(ctx context.Context, mtype *methodType, argv reflect.Value, stream *Stream) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(s.name, mtype.method.Name, r)
+		}
+	}()
+
+	function := mtype.method.Func
+	returnValues := function.Call([]reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, reflect.ValueOf(stream)})
+	errInter := returnValues[0].Interface()
+	if errInter != nil {
+		return errInter.(error)
+	}
+
+	return nil
+}
+
 func (s *service) callForFunction(ctx context.Context, ft *functionType, argv, replyv reflect.Value) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			//logs.Errorf("failed to invoke service: %v, stacks: %s", r, string(debug.Stack()))
-			err = fmt.Errorf("[service internal error]: %v, function: %s, argv: %+v",
-				r, runtime.FuncForPC(ft.fn.Pointer()), argv.Interface())
-			logs.Handle(err)
+			err = newPanicError(s.name, runtime.FuncForPC(ft.fn.Pointer()).Name(), r)
 		}
 	}()
 
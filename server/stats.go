@@ -0,0 +1,47 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MethodStats are the counters of a method since the server has started.
+type MethodStats struct {
+	Calls    int64 `json:"calls"`
+	Errors   int64 `json:"errors"`
+	InFlight int64 `json:"in_flight"`
+	// TotalTime is the time spent in the finished calls.
+	TotalTime time.Duration `json:"total_time"`
+}
+
+// methodStats counts the calls of a method or a function.
+type methodStats struct {
+	calls    int64
+	errors   int64
+	inflight int64
+	nanos    int64
+}
+
+// begin counts a call and returns its start time, end must be called when it returns.
+func (m *methodStats) begin() time.Time {
+	atomic.AddInt64(&m.inflight, 1)
+	return time.Now()
+}
+
+func (m *methodStats) end(start time.Time, err error) {
+	atomic.AddInt64(&m.nanos, int64(time.Since(start)))
+	if err != nil {
+		atomic.AddInt64(&m.errors, 1)
+	}
+	atomic.AddInt64(&m.calls, 1)
+	atomic.AddInt64(&m.inflight, -1)
+}
+
+func (m *methodStats) snapshot() MethodStats {
+	return MethodStats{
+		Calls:     atomic.LoadInt64(&m.calls),
+		Errors:    atomic.LoadInt64(&m.errors),
+		InFlight:  atomic.LoadInt64(&m.inflight),
+		TotalTime: time.Duration(atomic.LoadInt64(&m.nanos)),
+	}
+}
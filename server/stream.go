@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/halokid/rpcx-plus/codec"
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	logs "github.com/halokid/rpcx-plus/log"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// Precompute the reflect type for *Stream.
+var typeOfStream = reflect.TypeOf((*Stream)(nil))
+
+// Stream is the server side of a streaming call.
+// A streaming method has the signature:
+//
+//	func (t *T) Method(ctx context.Context, args *Args, stream *server.Stream) error
+//
+// The method sends messages to the client with Send and reads messages of a bidirectional
+// stream with Recv. The stream ends when the method returns.
+type Stream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	conn          net.Conn
+	seq           uint64
+	servicePath   string
+	serviceMethod string
+	serializeType protocol.SerializeType
+	compressType  protocol.CompressType
+	// compressThreshold is the payload size above which data frames are compressed.
+	compressThreshold int
+	// checksum is set when the client has opened the stream with a checksum.
+	checksum bool
+	// version is the protocol version of the client, frames are sent with it.
+	version byte
+	codec   codec.Codec
+
+	// recvCh buffers the data frames of the client, its capacity is the receive window.
+	recvCh   chan *protocol.Message
+	window   uint32
+	consumed uint32
+	credit   *protocol.StreamCredit
+
+	mu         sync.Mutex
+	closed     bool
+	recvClosed bool
+}
+
+// Context returns the context of this stream. It is cancelled when the client cancels the stream
+// or the connection is closed.
+func (st *Stream) Context() context.Context {
+	return st.ctx
+}
+
+// Send sends v to the client. It blocks when the client has not granted enough window.
+func (st *Stream) Send(v interface{}) error {
+	st.mu.Lock()
+	closed := st.closed
+	st.mu.Unlock()
+	if closed {
+		return protocol.ErrStreamClosed
+	}
+
+	err := st.credit.Acquire(st.ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := st.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return st.writeFrame(protocol.FrameStreamData, data, nil)
+}
+
+// Recv receives the next message of the client into v.
+// It returns io.EOF when the client has closed its sending side.
+func (st *Stream) Recv(v interface{}) error {
+	select {
+	case msg, ok := <-st.recvCh:
+		if !ok {
+			return io.EOF
+		}
+		err := st.codec.Decode(msg.Payload, v)
+		protocol.FreeMsg(msg)
+		st.release()
+		return err
+	case <-st.ctx.Done():
+		return st.ctx.Err()
+	}
+}
+
+// release grants the consumed window back to the client once half of it has been used.
+func (st *Stream) release() {
+	n := atomic.AddUint32(&st.consumed, 1)
+	threshold := st.window / 2
+	if threshold == 0 {
+		threshold = 1
+	}
+	if n < threshold {
+		return
+	}
+	if atomic.CompareAndSwapUint32(&st.consumed, n, 0) {
+		st.writeFrame(protocol.FrameStreamWindow, protocol.EncodeWindow(n), nil)
+	}
+}
+
+// push buffers a data frame of the client.
+func (st *Stream) push(msg *protocol.Message) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.recvClosed {
+		protocol.FreeMsg(msg)
+		return
+	}
+	select {
+	case st.recvCh <- msg:
+	default:
+		protocol.FreeMsg(msg)
+		logs.Warnf("rpcx: stream %s.%s (%d) exceeds the flow control window", st.servicePath, st.serviceMethod, st.seq)
+		st.recvClosed = true
+		close(st.recvCh)
+		st.cancel()
+	}
+}
+
+// closeRecv marks the sending side of the client closed.
+func (st *Stream) closeRecv() {
+	st.mu.Lock()
+	if !st.recvClosed {
+		st.recvClosed = true
+		close(st.recvCh)
+	}
+	st.mu.Unlock()
+}
+
+func (st *Stream) writeFrame(ft protocol.FrameType, payload []byte, metadata map[string]string) error {
+	res := protocol.GetPooledMsg()
+	res.SetMessageType(protocol.Response)
+	res.SetVersion(st.version)
+	res.SetSeq(st.seq)
+	res.SetSerializeType(st.serializeType)
+	res.SetFrameType(ft)
+	res.SetChecksum(st.checksum)
+	res.ServicePath = st.servicePath
+	res.ServiceMethod = st.serviceMethod
+	res.Metadata = metadata
+	res.Payload = payload
+	if len(payload) > st.compressThreshold && st.compressType != protocol.None {
+		res.SetCompressType(st.compressType)
+	}
+
+	_, err := res.EncodeTo(st.conn)
+	protocol.FreeMsg(res)
+	return err
+}
+
+// streamSet holds the open streams of one connection.
+type streamSet struct {
+	mu      sync.Mutex
+	streams map[uint64]*Stream
+}
+
+func newStreamSet() *streamSet {
+	return &streamSet{streams: make(map[uint64]*Stream)}
+}
+
+func (ss *streamSet) add(st *Stream) {
+	ss.mu.Lock()
+	ss.streams[st.seq] = st
+	ss.mu.Unlock()
+}
+
+func (ss *streamSet) get(seq uint64) *Stream {
+	ss.mu.Lock()
+	st := ss.streams[seq]
+	ss.mu.Unlock()
+	return st
+}
+
+func (ss *streamSet) remove(seq uint64) {
+	ss.mu.Lock()
+	delete(ss.streams, seq)
+	ss.mu.Unlock()
+}
+
+// cancelAll cancels all streams when the connection is closed.
+func (ss *streamSet) cancelAll() {
+	ss.mu.Lock()
+	for seq, st := range ss.streams {
+		st.cancel()
+		delete(ss.streams, seq)
+	}
+	ss.mu.Unlock()
+}
+
+// isFollowupFrame returns whether req belongs to a call that has already been authorized.
+func isFollowupFrame(req *protocol.Message) bool {
+	ft := req.FrameType()
+	return ft != protocol.FrameNormal && ft != protocol.FrameStreamOpen
+}
+
+// handleStreamFrame dispatches stream frames. It runs in the read loop of the connection
+// so that frames of one stream are handled in order.
+// releaseDeadline releases the deadline of a stream open, it may be nil.
+func (s *Server) handleStreamFrame(ctx *share.Context, conn net.Conn, streams *streamSet, req *protocol.Message, releaseDeadline context.CancelFunc) {
+	if req.FrameType() == protocol.FrameStreamOpen {
+		s.openStream(ctx, conn, streams, req, releaseDeadline)
+		return
+	}
+
+	st := streams.get(req.Seq())
+	if st == nil {
+		protocol.FreeMsg(req)
+		return
+	}
+
+	switch req.FrameType() {
+	case protocol.FrameStreamData:
+		st.push(req)
+		return
+	case protocol.FrameStreamClose:
+		st.closeRecv()
+	case protocol.FrameStreamWindow:
+		st.credit.Grant(protocol.DecodeWindow(req.Payload))
+	}
+	protocol.FreeMsg(req)
+}
+
+// openStream starts the handler of a stream. Like other requests, streams count against the in-flight
+// limits and run through the interceptors and the policy of their method, but they do not take a worker
+// of the pool, as they last as long as the client keeps them open.
+func (s *Server) openStream(ctx *share.Context, conn net.Conn, streams *streamSet, req *protocol.Message, releaseDeadline context.CancelFunc) {
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+
+	inflight := false
+	release := func() {
+		if inflight {
+			s.releaseInflight(req.ServicePath)
+		}
+		if releaseDeadline != nil {
+			releaseDeadline()
+		}
+	}
+	fail := func(err error) {
+		release()
+		res.SetFrameType(protocol.FrameStreamClose)
+		handleError(res, err)
+		s.Plugins.DoPreWriteResponse(ctx, req, res)
+		_, werr := res.EncodeTo(conn)
+		s.Plugins.DoPostWriteResponse(ctx, req, res, err)
+		if werr != nil {
+			logs.Warnf("rpcx: failed to write stream response: %v", werr)
+		}
+		protocol.FreeMsg(req)
+		protocol.FreeMsg(res)
+	}
+
+	s.serviceMapMu.RLock()
+	service := s.serviceMap[req.ServicePath]
+	s.serviceMapMu.RUnlock()
+	if service == nil {
+		fail(rerrors.New(rerrors.Unimplemented, "rpcx: can't find service "+req.ServicePath))
+		return
+	}
+	mtype := service.method[req.ServiceMethod]
+	if mtype == nil || !mtype.stream {
+		fail(rerrors.New(rerrors.Unimplemented, "rpcx: can't find stream method "+req.ServiceMethod))
+		return
+	}
+
+	cc := share.Codecs[req.SerializeType()]
+	if cc == nil {
+		fail(fmt.Errorf("can not find codec for %d", req.SerializeType()))
+		return
+	}
+
+	if err := s.acquireInflight(req.ServicePath); err != nil {
+		fail(err)
+		return
+	}
+	inflight = true
+
+	argv := argsReplyPools.Get(mtype.ArgType)
+	err := cc.Decode(req.Payload, argv)
+	if err != nil {
+		argsReplyPools.Put(mtype.ArgType, argv)
+		fail(err)
+		return
+	}
+
+	resMetadata := make(map[string]string)
+	newCtx := share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, req.Metadata),
+		share.ResMetaDataKey, resMetadata)
+	s.Plugins.DoPreHandleRequest(newCtx, req)
+
+	window := s.streamWindow
+	if window == 0 {
+		window = protocol.DefaultStreamWindow
+	}
+	sctx, cancel := context.WithCancel(newCtx)
+	st := &Stream{
+		ctx:               sctx,
+		cancel:            cancel,
+		conn:              conn,
+		seq:               req.Seq(),
+		servicePath:       req.ServicePath,
+		serviceMethod:     req.ServiceMethod,
+		serializeType:     req.SerializeType(),
+		compressType:      req.CompressType(),
+		codec:             cc,
+		compressThreshold: s.getCompressThreshold(),
+		checksum:          req.HasChecksum(),
+		version:           req.Version(),
+		recvCh:            make(chan *protocol.Message, window),
+		window:            window,
+		credit:            protocol.NewStreamCredit(protocol.WindowFromMetadata(req.Metadata)),
+	}
+	streams.add(st)
+
+	// acknowledge the stream and advertise the receive window.
+	err = st.writeFrame(protocol.FrameStreamOpen, nil, map[string]string{
+		protocol.StreamWindowKey: strconv.FormatUint(uint64(window), 10),
+	})
+	if err != nil {
+		streams.remove(st.seq)
+		cancel()
+		release()
+		argsReplyPools.Put(mtype.ArgType, argv)
+		protocol.FreeMsg(req)
+		protocol.FreeMsg(res)
+		return
+	}
+
+	atomic.AddInt32(&s.handlerMsgNum, 1)
+	go func() {
+		defer atomic.AddInt32(&s.handlerMsgNum, -1)
+
+		start := mtype.stats.begin()
+		err := s.intercept(sctx, req.ServicePath, req.ServiceMethod, argv, st,
+			s.withPolicy(req.ServicePath, req.ServiceMethod, streamHandler(service, mtype)))
+		mtype.stats.end(start, err)
+		if !errors.Is(err, ErrHandlerTimeout) {
+			// otherwise the handler may still use argv.
+			argsReplyPools.Put(mtype.ArgType, argv)
+		}
+
+		st.mu.Lock()
+		st.closed = true
+		st.mu.Unlock()
+		streams.remove(st.seq)
+		cancel()
+		release()
+
+		res.SetFrameType(protocol.FrameStreamClose)
+		if err != nil {
+			handleError(res, err)
+		}
+		if len(resMetadata) > 0 {
+			if res.Metadata == nil {
+				res.Metadata = resMetadata
+			} else {
+				for k, v := range resMetadata {
+					if res.Metadata[k] == "" {
+						res.Metadata[k] = v
+					}
+				}
+			}
+		}
+		s.Plugins.DoPreWriteResponse(newCtx, req, res)
+		_, werr := res.EncodeTo(conn)
+		if werr != nil {
+			logs.Warnf("rpcx: failed to close stream %s.%s: %v", st.servicePath, st.serviceMethod, werr)
+		}
+		s.Plugins.DoPostWriteResponse(newCtx, req, res, err)
+
+		protocol.FreeMsg(req)
+		protocol.FreeMsg(res)
+	}()
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+
+	"github.com/halokid/rpcx-plus/codec"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// protoJSONCodec transcodes the JSON of HTTP callers for methods whose args are protobuf messages.
+var protoJSONCodec = &codec.ProtoJSONCodec{}
+
+// transcodeKey marks the context of requests from the HTTP gateway and JSON-RPC.
+type transcodeKey struct{}
+
+// withTranscoding marks ctx so that JSON args and replies that are protobuf messages
+// use the canonical protobuf JSON mapping.
+func withTranscoding(ctx context.Context) context.Context {
+	return context.WithValue(ctx, transcodeKey{}, true)
+}
+
+// getCodec returns the codec of st for a request handled with ctx.
+func getCodec(ctx context.Context, st protocol.SerializeType) codec.Codec {
+	if st == protocol.JSON && ctx.Value(transcodeKey{}) != nil {
+		return protoJSONCodec
+	}
+	return share.Codecs[st]
+}
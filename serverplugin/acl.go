@@ -0,0 +1,266 @@
+package serverplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/server"
+)
+
+// Effects of ACL rules.
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// ACLRule allows or denies the calls of the methods matching Service and Method
+// by the callers having one of Principals.
+//
+// Service, Method and principals are patterns where * matches any characters, e.g. "Admin.*" or
+// "spiffe://example.org/ns/prod/*". An empty pattern matches anything.
+// Principals of the form "ip:" match the client IP with an address or a CIDR, e.g. "ip:10.0.0.0/8".
+type ACLRule struct {
+	Service    string   `json:"service"`
+	Method     string   `json:"method"`
+	Principals []string `json:"principals"`
+	// Effect is ACLAllow or ACLDeny.
+	Effect string `json:"effect"`
+}
+
+// ACLPolicy is an ordered list of rules, the first rule matching a call decides.
+// Calls no rule matches are decided by Default, they are denied if it is empty.
+type ACLPolicy struct {
+	Default string     `json:"default"`
+	Rules   []*ACLRule `json:"rules"`
+}
+
+// LoadACLPolicy reads a JSON policy from file.
+func LoadACLPolicy(file string) (*ACLPolicy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policy := &ACLPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("rpcx: invalid ACL policy %s: %v", file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("rpcx: invalid ACL policy %s: %v", file, err)
+	}
+	return policy, nil
+}
+
+func (p *ACLPolicy) validate() error {
+	if p.Default != "" && p.Default != ACLAllow && p.Default != ACLDeny {
+		return fmt.Errorf("unknown default effect %q", p.Default)
+	}
+	for i, rule := range p.Rules {
+		if rule.Effect != ACLAllow && rule.Effect != ACLDeny {
+			return fmt.Errorf("unknown effect %q of rule %d", rule.Effect, i)
+		}
+		for _, principal := range rule.Principals {
+			if cidr := strings.TrimPrefix(principal, "ip:"); cidr != principal && parseIPNet(cidr) == nil {
+				return fmt.Errorf("invalid ip %q of rule %d", cidr, i)
+			}
+		}
+	}
+	return nil
+}
+
+// ACLDecision is the decision of the ACLPlugin for a call.
+type ACLDecision struct {
+	ServicePath   string
+	ServiceMethod string
+	Principals    []string
+	RemoteAddr    string
+	Allowed       bool
+	// Rule is the index of the rule that has decided, -1 for the default of the policy.
+	Rule int
+}
+
+// ACLPlugin authorizes the calls of service methods with an ACLPolicy.
+// The principals of a call are those returned by Principals, e.g. the subject of the auth token,
+// and the identity of the mutual TLS connection: the SPIFFE ID, "cn:" + the common name
+// and "dns:" + the DNS names of the client certificate.
+// Denied calls fail with a PermissionDenied error.
+type ACLPlugin struct {
+	// Principals returns the principals of the caller of req, e.g. from its auth token.
+	Principals func(ctx context.Context, req *protocol.Message) []string
+	// Audit is called with every decision.
+	Audit func(ctx context.Context, decision *ACLDecision)
+
+	mu     sync.RWMutex
+	policy *ACLPolicy
+	file   string
+}
+
+// NewACLPlugin returns an ACLPlugin with policy.
+func NewACLPlugin(policy *ACLPolicy) *ACLPlugin {
+	return &ACLPlugin{policy: policy}
+}
+
+// NewACLPluginFromFile returns an ACLPlugin with the policy of file, Reload reads it again.
+func NewACLPluginFromFile(file string) (*ACLPlugin, error) {
+	policy, err := LoadACLPolicy(file)
+	if err != nil {
+		return nil, err
+	}
+	return &ACLPlugin{policy: policy, file: file}, nil
+}
+
+// SetPolicy replaces the policy.
+func (p *ACLPlugin) SetPolicy(policy *ACLPolicy) {
+	p.mu.Lock()
+	p.policy = policy
+	p.mu.Unlock()
+}
+
+// Reload reads the policy file again. The current policy is kept if the file is invalid.
+func (p *ACLPlugin) Reload() error {
+	if p.file == "" {
+		return fmt.Errorf("rpcx: ACL policy has no file")
+	}
+	policy, err := LoadACLPolicy(p.file)
+	if err != nil {
+		return err
+	}
+	p.SetPolicy(policy)
+	return nil
+}
+
+// Authorize allows or denies the call of req.
+func (p *ACLPlugin) Authorize(ctx context.Context, req *protocol.Message) error {
+	p.mu.RLock()
+	policy := p.policy
+	p.mu.RUnlock()
+
+	decision := &ACLDecision{
+		ServicePath:   req.ServicePath,
+		ServiceMethod: req.ServiceMethod,
+		RemoteAddr:    remoteAddr(ctx),
+		Rule:          -1,
+	}
+	if p.Principals != nil {
+		decision.Principals = append(decision.Principals, p.Principals(ctx, req)...)
+	}
+	if identity := server.PeerIdentityFromContext(ctx); identity != nil {
+		if identity.SPIFFEID != "" {
+			decision.Principals = append(decision.Principals, identity.SPIFFEID)
+		}
+		if identity.CommonName != "" {
+			decision.Principals = append(decision.Principals, "cn:"+identity.CommonName)
+		}
+		for _, name := range identity.DNSNames {
+			decision.Principals = append(decision.Principals, "dns:"+name)
+		}
+	}
+
+	decision.Allowed = policy != nil && policy.Default == ACLAllow
+	if policy != nil {
+		ip := remoteIP(decision.RemoteAddr)
+		for i, rule := range policy.Rules {
+			if rule.matches(req.ServicePath, req.ServiceMethod, decision.Principals, ip) {
+				decision.Allowed = rule.Effect == ACLAllow
+				decision.Rule = i
+				break
+			}
+		}
+	}
+
+	if p.Audit != nil {
+		p.Audit(ctx, decision)
+	}
+	if !decision.Allowed {
+		return rerrors.New(rerrors.PermissionDenied,
+			fmt.Sprintf("rpcx: permission denied to call %s.%s", req.ServicePath, req.ServiceMethod))
+	}
+	return nil
+}
+
+func (rule *ACLRule) matches(servicePath, serviceMethod string, principals []string, ip net.IP) bool {
+	if !matchPattern(rule.Service, servicePath) || !matchPattern(rule.Method, serviceMethod) {
+		return false
+	}
+	if len(rule.Principals) == 0 {
+		return true
+	}
+	for _, pattern := range rule.Principals {
+		if cidr := strings.TrimPrefix(pattern, "ip:"); cidr != pattern {
+			if ipNet := parseIPNet(cidr); ip != nil && ipNet != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		for _, principal := range principals {
+			if matchPattern(pattern, principal) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPattern matches s with pattern, where * matches any characters.
+func matchPattern(pattern, s string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// parseIPNet parses a CIDR or an IP address.
+func parseIPNet(s string) *net.IPNet {
+	if _, ipNet, err := net.ParseCIDR(s); err == nil {
+		return ipNet
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// remoteAddr returns the address of the client, the connection is a net.Conn
+// for rpcx connections and a string for HTTP requests.
+func remoteAddr(ctx context.Context) string {
+	switch conn := ctx.Value(server.RemoteConnContextKey).(type) {
+	case net.Conn:
+		return conn.RemoteAddr().String()
+	case string:
+		return conn
+	}
+	return ""
+}
+
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
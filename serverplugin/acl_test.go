@@ -0,0 +1,118 @@
+package serverplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/server"
+)
+
+func TestACLPlugin(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.json")
+	policy := `{"rules": [
+		{"service": "Admin", "principals": ["user:root"], "effect": "allow"},
+		{"service": "Admin", "effect": "deny"},
+		{"service": "Arith", "method": "Mul", "principals": ["user:*", "spiffe://example.org/*", "ip:10.0.0.0/8"], "effect": "allow"}
+	]}`
+	if err := os.WriteFile(file, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewACLPluginFromFile(file)
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+
+	var decisions []*ACLDecision
+	p.Audit = func(ctx context.Context, decision *ACLDecision) {
+		decisions = append(decisions, decision)
+	}
+	var user string
+	p.Principals = func(ctx context.Context, req *protocol.Message) []string {
+		if user == "" {
+			return nil
+		}
+		return []string{"user:" + user}
+	}
+
+	authorize := func(ctx context.Context, servicePath, serviceMethod string) error {
+		req := protocol.NewMessage()
+		req.ServicePath = servicePath
+		req.ServiceMethod = serviceMethod
+		return p.Authorize(ctx, req)
+	}
+	ctx := context.WithValue(context.Background(), server.RemoteConnContextKey, "192.168.1.1:3000")
+
+	user = "alice"
+	if err := authorize(ctx, "Arith", "Mul"); err != nil {
+		t.Fatalf("expect alice allowed to call Arith.Mul but got %v", err)
+	}
+	if err := authorize(ctx, "Admin", "Stop"); rerrors.CodeOf(err) != rerrors.PermissionDenied {
+		t.Fatalf("expect PermissionDenied but got %v", err)
+	}
+	if d := decisions[len(decisions)-1]; d.Allowed || d.Rule != 1 || d.Principals[0] != "user:alice" {
+		t.Fatalf("unexpected decision: %+v", d)
+	}
+	user = "root"
+	if err := authorize(ctx, "Admin", "Stop"); err != nil {
+		t.Fatalf("expect root allowed to call Admin.Stop but got %v", err)
+	}
+
+	user = ""
+	if err := authorize(ctx, "Arith", "Add"); rerrors.CodeOf(err) != rerrors.PermissionDenied {
+		t.Fatalf("expect the default to deny but got %v", err)
+	}
+	if err := authorize(ctx, "Arith", "Mul"); err == nil {
+		t.Fatalf("expect an anonymous caller denied")
+	}
+	ipCtx := context.WithValue(context.Background(), server.RemoteConnContextKey, "10.1.2.3:3000")
+	if err := authorize(ipCtx, "Arith", "Mul"); err != nil {
+		t.Fatalf("expect a caller of 10.0.0.0/8 allowed but got %v", err)
+	}
+	tlsCtx := context.WithValue(ctx, server.PeerIdentityContextKey, &server.PeerIdentity{SPIFFEID: "spiffe://example.org/ns/prod/sa/web"})
+	if err := authorize(tlsCtx, "Arith", "Mul"); err != nil {
+		t.Fatalf("expect the SPIFFE ID allowed but got %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte(`{"default": "allow"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatalf("failed to reload policy: %v", err)
+	}
+	if err := authorize(ctx, "Admin", "Stop"); err != nil {
+		t.Fatalf("expect the reloaded policy to allow but got %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte(`{"rules": [{"effect": "maybe"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err == nil {
+		t.Fatalf("expect an invalid policy rejected")
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"", "Arith", true},
+		{"*", "Arith", true},
+		{"Arith", "Arith", true},
+		{"Arith", "Arith2", false},
+		{"Ar*", "Arith", true},
+		{"*th", "Arith", true},
+		{"A*i*h", "Arith", true},
+		{"A*x*h", "Arith", false},
+		{"a*a", "a", false},
+	}
+	for _, c := range cases {
+		if matchPattern(c.pattern, c.s) != c.match {
+			t.Errorf("expect %q matching %q to be %v", c.pattern, c.s, c.match)
+		}
+	}
+}
@@ -101,23 +101,17 @@ func (p *ConsulRegisterPlugin) Start() error {
 					for _, name := range p.Services {
 						// todo: 循环写入KV， 定义注册服务
 						nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
-						kvPaire, err := p.kv.Get(nodePath)
+						// the node is written from the kept metadata, not read back from the registry,
+						// so that a state set by UpdateState meanwhile is not overwritten.
+						p.metasLock.RLock()
+						meta := p.metas[name]
+						p.metasLock.RUnlock()
+
+						v, _ := url.ParseQuery(meta)
+						v.Set("tps", string(data))
+						err := p.kv.Put(nodePath, []byte(v.Encode()), &store.WriteOptions{TTL: p.UpdateInterval * 3})
 						if err != nil {
-							// 如果获取不了key
-							logs.Warnf("can't get data of node: %s, will re-create, because of %v", nodePath, err.Error())
-
-							p.metasLock.RLock()
-							meta := p.metas[name]
-							p.metasLock.RUnlock()
-							// 超时时间设置为配置的TTL的 3倍
-							err = p.kv.Put(nodePath, []byte(meta), &store.WriteOptions{TTL: p.UpdateInterval * 3})
-							if err != nil {
-								logs.Errorf("cannot re-create consul path %s: %v", nodePath, err)
-							}
-						} else {
-							v, _ := url.ParseQuery(string(kvPaire.Value))
-							v.Set("tps", string(data))
-							p.kv.Put(nodePath, []byte(v.Encode()), &store.WriteOptions{TTL: p.UpdateInterval * 3})
+							logs.Errorf("cannot refresh consul path %s: %v", nodePath, err)
 						}
 					}
 				}
@@ -285,3 +279,26 @@ func (p *ConsulRegisterPlugin) Unregister(name string) (err error) {
 	p.metasLock.Unlock()
 	return
 }
+
+// UpdateState rewrites the metadata of the service name with state.
+// Clients do not route to services whose state is "inactive".
+func (p *ConsulRegisterPlugin) UpdateState(name string, state string) error {
+	p.metasLock.Lock()
+	if p.metas == nil {
+		p.metas = make(map[string]string)
+	}
+	meta := withState(p.metas[name], state)
+	p.metas[name] = meta
+	p.metasLock.Unlock()
+
+	if p.kv == nil {
+		return errRegistryNotStarted
+	}
+
+	nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
+	err := p.kv.Put(nodePath, []byte(meta), &store.WriteOptions{TTL: p.UpdateInterval * 3})
+	if err != nil {
+		logs.Errorf("cannot update consul path %s: %v", nodePath, err)
+	}
+	return err
+}
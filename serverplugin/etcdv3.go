@@ -87,23 +87,17 @@ func (p *EtcdV3RegisterPlugin) Start() error {
 					//set this same metrics for all services at this server
 					for _, name := range p.Services {
 						nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
-						kvPair, err := p.kv.Get(nodePath)
+						// the node is written from the kept metadata, not read back from the registry,
+						// so that a state set by UpdateState meanwhile is not overwritten.
+						p.metasLock.RLock()
+						meta := p.metas[name]
+						p.metasLock.RUnlock()
+
+						v, _ := url.ParseQuery(meta)
+						v.Set("tps", string(data))
+						err := p.kv.Put(nodePath, []byte(v.Encode()), &store.WriteOptions{TTL: p.UpdateInterval * 3})
 						if err != nil {
-							logs.Debugf("can't get data of node: %s, because of %v", nodePath, err.Error())
-
-							p.metasLock.RLock()
-							meta := p.metas[name]
-							p.metasLock.RUnlock()
-
-							err = p.kv.Put(nodePath, []byte(meta), &store.WriteOptions{TTL: p.UpdateInterval * 3})
-							if err != nil {
-								logs.Errorf("cannot re-create etcd path %s: %v", nodePath, err)
-							}
-
-						} else {
-							v, _ := url.ParseQuery(string(kvPair.Value))
-							v.Set("tps", string(data))
-							p.kv.Put(nodePath, []byte(v.Encode()), &store.WriteOptions{TTL: p.UpdateInterval * 3})
+							logs.Errorf("cannot refresh etcd path %s: %v", nodePath, err)
 						}
 					}
 				}
@@ -258,3 +252,26 @@ func (p *EtcdV3RegisterPlugin) Unregister(name string) (err error) {
 	p.metasLock.Unlock()
 	return
 }
+
+// UpdateState rewrites the metadata of the service name with state.
+// Clients do not route to services whose state is "inactive".
+func (p *EtcdV3RegisterPlugin) UpdateState(name string, state string) error {
+	p.metasLock.Lock()
+	if p.metas == nil {
+		p.metas = make(map[string]string)
+	}
+	meta := withState(p.metas[name], state)
+	p.metas[name] = meta
+	p.metasLock.Unlock()
+
+	if p.kv == nil {
+		return errRegistryNotStarted
+	}
+
+	nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
+	err := p.kv.Put(nodePath, []byte(meta), &store.WriteOptions{TTL: p.UpdateInterval * 3})
+	if err != nil {
+		logs.Errorf("cannot update etcd path %s: %v", nodePath, err)
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package serverplugin
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+const (
+	// DefaultHMACMaxSkew is the default max difference between the timestamp of a signed request and now.
+	DefaultHMACMaxSkew = 5 * time.Minute
+	// DefaultHMACMaxNonces is the default number of nonces remembered to block replays.
+	DefaultHMACMaxNonces = 100000
+)
+
+// HMACVerifyPlugin verifies the requests signed by client.HMACSignPlugin.
+// Requests that are unsigned, signed with an unknown key or an invalid signature,
+// whose timestamp is off by more than MaxSkew or whose nonce has been seen are rejected
+// with an Unauthenticated error.
+//
+// Keys are looked up by id, so keys are rotated by adding the new key, moving the clients to it
+// and then removing the old key.
+//
+// Nonces are remembered for MaxSkew, at most MaxNonces of them. When the cache is full the nonce with the
+// oldest timestamp is dropped and the requests signed before it are rejected as stale, so replays are always blocked.
+type HMACVerifyPlugin struct {
+	// MaxSkew is DefaultHMACMaxSkew if it is 0.
+	MaxSkew time.Duration
+	// Skip returns true for the requests that need no signature, e.g. health checks.
+	Skip func(req *protocol.Message) bool
+
+	mu   sync.RWMutex
+	keys map[string][]byte
+
+	nonces *nonceCache
+}
+
+// NewHMACVerifyPlugin returns a HMACVerifyPlugin with keys by id which remembers at most maxNonces nonces,
+// DefaultHMACMaxNonces if it is 0.
+func NewHMACVerifyPlugin(keys map[string][]byte, maxNonces int) *HMACVerifyPlugin {
+	if maxNonces <= 0 {
+		maxNonces = DefaultHMACMaxNonces
+	}
+	p := &HMACVerifyPlugin{
+		keys:   make(map[string][]byte, len(keys)),
+		nonces: newNonceCache(maxNonces),
+	}
+	for id, secret := range keys {
+		p.keys[id] = secret
+	}
+	return p
+}
+
+// SetKey adds or replaces the key of id.
+func (p *HMACVerifyPlugin) SetKey(id string, secret []byte) {
+	p.mu.Lock()
+	p.keys[id] = secret
+	p.mu.Unlock()
+}
+
+// RemoveKey removes the key of id.
+func (p *HMACVerifyPlugin) RemoveKey(id string) {
+	p.mu.Lock()
+	delete(p.keys, id)
+	p.mu.Unlock()
+}
+
+// PostReadRequest verifies the signature of r.
+func (p *HMACVerifyPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if e != nil || !isCallFrame(r) {
+		return nil
+	}
+	if p.Skip != nil && p.Skip(r) {
+		return nil
+	}
+	if err := p.verify(r, time.Now()); err != nil {
+		return rerrors.New(rerrors.Unauthenticated, "rpcx: invalid request signature: "+err.Error())
+	}
+	return nil
+}
+
+func (p *HMACVerifyPlugin) verify(r *protocol.Message, now time.Time) error {
+	signature := r.Metadata[share.SignatureKey]
+	if signature == "" {
+		return fmt.Errorf("request is not signed")
+	}
+
+	keyID := r.Metadata[share.SignatureKeyIDKey]
+	p.mu.RLock()
+	secret, ok := p.keys[keyID]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown key %q", keyID)
+	}
+	if !share.VerifySignature(secret, share.SignatureBase(r), signature) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	ms, err := strconv.ParseInt(r.Metadata[share.SignatureTimestampKey], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	ts := time.Unix(0, ms*int64(time.Millisecond))
+	maxSkew := p.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultHMACMaxSkew
+	}
+	if ts.Before(now.Add(-maxSkew)) || ts.After(now.Add(maxSkew)) {
+		return fmt.Errorf("stale timestamp")
+	}
+
+	nonce := r.Metadata[share.SignatureNonceKey]
+	if nonce == "" {
+		return fmt.Errorf("missing nonce")
+	}
+	return p.nonces.add(keyID+":"+nonce, ts, now.Add(-maxSkew))
+}
+
+// nonceCache remembers the nonces of the requests signed after a time.
+// Nonces are dropped by timestamp, the oldest first, so that a client whose clock is ahead
+// does not make the requests of the other clients stale.
+type nonceCache struct {
+	max int
+
+	mu   sync.Mutex
+	seen map[string]*nonceEntry
+	byTS nonceHeap
+	// floor is the latest timestamp of the dropped nonces, older requests cannot be checked.
+	floor time.Time
+}
+
+type nonceEntry struct {
+	nonce string
+	ts    time.Time
+}
+
+func newNonceCache(max int) *nonceCache {
+	return &nonceCache{max: max, seen: make(map[string]*nonceEntry)}
+}
+
+// add adds the nonce of a request signed at ts and forgets the nonces of the requests signed before expired.
+func (c *nonceCache) add(nonce string, ts, expired time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.byTS) > 0 && c.byTS[0].ts.Before(expired) {
+		c.removeOldest()
+	}
+
+	if !ts.After(c.floor) {
+		return fmt.Errorf("stale timestamp")
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return fmt.Errorf("replayed nonce")
+	}
+	entry := &nonceEntry{nonce: nonce, ts: ts}
+	c.seen[nonce] = entry
+	heap.Push(&c.byTS, entry)
+
+	for len(c.byTS) > c.max {
+		entry := c.removeOldest()
+		if entry.ts.After(c.floor) {
+			c.floor = entry.ts
+		}
+	}
+	return nil
+}
+
+func (c *nonceCache) removeOldest() *nonceEntry {
+	entry := heap.Pop(&c.byTS).(*nonceEntry)
+	delete(c.seen, entry.nonce)
+	return entry
+}
+
+// nonceHeap is a min-heap of nonces by timestamp.
+type nonceHeap []*nonceEntry
+
+func (h nonceHeap) Len() int            { return len(h) }
+func (h nonceHeap) Less(i, j int) bool  { return h[i].ts.Before(h[j].ts) }
+func (h nonceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x interface{}) { *h = append(*h, x.(*nonceEntry)) }
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
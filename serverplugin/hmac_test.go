@@ -0,0 +1,154 @@
+package serverplugin
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+func signedMessage(keyID string, secret []byte, nonce string, ts time.Time) *protocol.Message {
+	req := protocol.NewMessage()
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = []byte(`{"A":10,"B":20}`)
+	req.Metadata = map[string]string{
+		"tenant":                    "acme",
+		share.SignatureKeyIDKey:     keyID,
+		share.SignatureTimestampKey: strconv.FormatInt(ts.UnixNano()/int64(time.Millisecond), 10),
+		share.SignatureNonceKey:     nonce,
+		share.SignatureMetadataKey:  "tenant",
+	}
+	req.Metadata[share.SignatureKey] = share.Sign(secret, share.SignatureBase(req))
+	return req
+}
+
+func TestHMACVerifyPlugin(t *testing.T) {
+	p := NewHMACVerifyPlugin(map[string][]byte{"k1": []byte("secret1")}, 0)
+	verify := func(req *protocol.Message) error {
+		return p.PostReadRequest(context.Background(), req, nil)
+	}
+
+	if err := verify(signedMessage("k1", []byte("secret1"), "n1", time.Now())); err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+
+	tampered := signedMessage("k1", []byte("secret1"), "n2", time.Now())
+	tampered.Metadata["tenant"] = "other"
+	unlisted := signedMessage("k1", []byte("secret1"), "n3", time.Now())
+	unlisted.Metadata[share.SignatureMetadataKey] = ""
+	payload := signedMessage("k1", []byte("secret1"), "n4", time.Now())
+	payload.Payload = []byte(`{"A":10,"B":30}`)
+	unsigned := protocol.NewMessage()
+	unsigned.ServicePath = "Arith"
+	unsigned.ServiceMethod = "Mul"
+
+	cases := map[string]*protocol.Message{
+		"replayed":         signedMessage("k1", []byte("secret1"), "n1", time.Now()),
+		"tampered meta":    tampered,
+		"unlisted meta":    unlisted,
+		"tampered payload": payload,
+		"stale":            signedMessage("k1", []byte("secret1"), "n5", time.Now().Add(-time.Hour)),
+		"future":           signedMessage("k1", []byte("secret1"), "n6", time.Now().Add(time.Hour)),
+		"unknown key":      signedMessage("k2", []byte("secret2"), "n7", time.Now()),
+		"wrong secret":     signedMessage("k1", []byte("secret2"), "n8", time.Now()),
+		"unsigned":         unsigned,
+	}
+	for name, req := range cases {
+		if err := verify(req); rerrors.CodeOf(err) != rerrors.Unauthenticated {
+			t.Errorf("expect an Unauthenticated error for the %s request but got %v", name, err)
+		}
+	}
+
+	heartbeat := protocol.NewMessage()
+	heartbeat.SetHeartbeat(true)
+	if err := verify(heartbeat); err != nil {
+		t.Errorf("expect heartbeats not to be verified but got %v", err)
+	}
+
+	// rotate the key
+	p.SetKey("k2", []byte("secret2"))
+	if err := verify(signedMessage("k2", []byte("secret2"), "n9", time.Now())); err != nil {
+		t.Fatalf("failed to verify with the new key: %v", err)
+	}
+	p.RemoveKey("k1")
+	if err := verify(signedMessage("k1", []byte("secret1"), "n10", time.Now())); err == nil {
+		t.Fatalf("expect the removed key to be rejected")
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	c := newNonceCache(2)
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+
+	if err := c.add("a", now.Add(-3*time.Second), expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.add("b", now.Add(-2*time.Second), expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.add("a", now.Add(-3*time.Second), expired); err == nil {
+		t.Fatalf("expect a replayed nonce to be rejected")
+	}
+
+	// a is dropped, the requests signed before it cannot be checked anymore
+	if err := c.add("c", now.Add(-time.Second), expired); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.seen) != 2 {
+		t.Fatalf("expect 2 nonces but got %d", len(c.seen))
+	}
+	if err := c.add("a", now.Add(-3*time.Second), expired); err == nil {
+		t.Fatalf("expect a request older than the dropped nonces to be rejected")
+	}
+
+	// expired nonces are forgotten
+	if err := c.add("d", now, now.Add(-1500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.seen) != 2 {
+		t.Fatalf("expect 2 nonces after expiry but got %d", len(c.seen))
+	}
+
+	// the nonce of a client whose clock is ahead is not dropped first
+	c = newNonceCache(2)
+	if err := c.add("ahead", now.Add(4*time.Minute), expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.add("a", now.Add(-time.Second), expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.add("b", now, expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.add("c", now.Add(-500*time.Millisecond), expired); err != nil {
+		t.Fatalf("expect a request signed after the dropped nonce accepted but got %v", err)
+	}
+	if err := c.add("ahead", now.Add(4*time.Minute), expired); err == nil {
+		t.Fatalf("expect a replayed nonce to be rejected")
+	}
+}
+
+func TestSignatureBase_Unambiguous(t *testing.T) {
+	a := protocol.NewMessage()
+	a.ServicePath = "Arith\nMul"
+	a.ServiceMethod = "Add"
+	b := protocol.NewMessage()
+	b.ServicePath = "Arith"
+	b.ServiceMethod = "Mul\nAdd"
+	if string(share.SignatureBase(a)) == string(share.SignatureBase(b)) {
+		t.Fatalf("expect different signature bases for different service paths and methods")
+	}
+
+	a.Metadata = map[string]string{"a": "1:b", share.SignatureMetadataKey: "a,b"}
+	b.ServicePath, b.ServiceMethod = a.ServicePath, a.ServiceMethod
+	b.Metadata = map[string]string{"a": "1", "b": "b:", share.SignatureMetadataKey: "a,b"}
+	if string(share.SignatureBase(a)) == string(share.SignatureBase(b)) {
+		t.Fatalf("expect different signature bases for different metadata")
+	}
+}
@@ -0,0 +1,277 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/server"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+const (
+	// DefaultIdempotencyTTL is how long the results of calls are kept by default.
+	DefaultIdempotencyTTL = 10 * time.Minute
+	// DefaultIdempotencyLockTTL is how long a running call holds its key by default.
+	DefaultIdempotencyLockTTL = time.Minute
+)
+
+// IdempotentResult is the result of a call kept by an IdempotencyStore.
+type IdempotentResult struct {
+	// SerializeType is the serialization of the request of the call, Reply is encoded with it.
+	SerializeType protocol.SerializeType
+	Reply         []byte
+	// Metadata is the response metadata set by the handler.
+	Metadata map[string]string
+	// Error is the error of the call as the metadata of an error response, nil if the call has succeeded.
+	Error map[string]string
+}
+
+// IdempotencyStore keeps the results of calls by idempotency key.
+// Stores shared by servers, e.g. in Redis, also run the retries sent to other servers once.
+type IdempotencyStore interface {
+	// Reserve reserves key for ttl for a call that is going to run.
+	// If key is already reserved, it returns false and the result of key, which is nil while its call is running.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (bool, *IdempotentResult, error)
+	// Complete keeps the result of the call of key for ttl.
+	Complete(ctx context.Context, key string, result *IdempotentResult, ttl time.Duration) error
+	// Release drops the reservation of key, so that its call can run again.
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyPlugin runs the calls that have the same idempotency key once, see client.Option.Idempotent.
+// Retries of a completed call get its result again and retries of a running call wait for it.
+// Calls failed with errors that are worth a retry, e.g. DeadlineExceeded or Unavailable, are not kept.
+// Keys are scoped by caller, so a caller cannot get the results of another one by sending its key.
+// Set its Intercept method as an interceptor of the server:
+//
+//	s := server.NewServer(server.WithInterceptors(idempotencyPlugin.Intercept))
+type IdempotencyPlugin struct {
+	Store IdempotencyStore
+	// TTL is how long results are kept, DefaultIdempotencyTTL if it is 0.
+	TTL time.Duration
+	// LockTTL is how long a running call holds its key, DefaultIdempotencyLockTTL if it is 0.
+	// The retries that wait for a call longer than LockTTL run it again.
+	LockTTL time.Duration
+	// PollInterval is how often waiting retries check the result of a running call, 10ms if it is 0.
+	PollInterval time.Duration
+	// Caller returns the identity of the caller of a call. If it is nil, the hash of the auth token
+	// or else the subject of the mTLS client certificate is used, so retries must be sent with the same token.
+	Caller func(ctx context.Context) string
+}
+
+// NewIdempotencyPlugin returns an IdempotencyPlugin with store, a MemoryIdempotencyStore if it is nil.
+func NewIdempotencyPlugin(store IdempotencyStore) *IdempotencyPlugin {
+	if store == nil {
+		store = NewMemoryIdempotencyStore()
+	}
+	return &IdempotencyPlugin{Store: store}
+}
+
+// Intercept runs the call once per idempotency key.
+func (p *IdempotencyPlugin) Intercept(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}, next server.Handler) error {
+	metadata, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	idempotencyKey := metadata[share.IdempotencyKey]
+	if idempotencyKey == "" {
+		return next(ctx, args, reply)
+	}
+	key := p.caller(ctx, metadata) + "|" + servicePath + "." + serviceMethod + "|" + idempotencyKey
+
+	for {
+		reserved, result, err := p.Store.Reserve(ctx, key, p.lockTTL())
+		if err != nil {
+			return err
+		}
+		if reserved {
+			return p.run(ctx, key, args, reply, next)
+		}
+		if result != nil {
+			return p.replay(ctx, result, reply)
+		}
+
+		// the call is running
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.pollInterval()):
+		}
+	}
+}
+
+func (p *IdempotencyPlugin) run(ctx context.Context, key string, args, reply interface{}, next server.Handler) (err error) {
+	completed := false
+	defer func() {
+		if !completed {
+			p.Store.Release(context.Background(), key)
+		}
+	}()
+
+	err = next(ctx, args, reply)
+	if err != nil && retryable(err) {
+		return err
+	}
+
+	result := &IdempotentResult{}
+	if err != nil {
+		result.Error = make(map[string]string)
+		var serr *rerrors.Error
+		if errors.As(err, &serr) {
+			serr.SetMetadata(result.Error)
+		} else {
+			result.Error[protocol.ServiceError] = err.Error()
+		}
+	} else {
+		// the reply is kept as the server encodes it for the request.
+		st, cc, ok := server.ReplyCodecFromContext(ctx)
+		if !ok {
+			return nil
+		}
+		data, encodeErr := cc.Encode(reply)
+		if encodeErr != nil {
+			return nil
+		}
+		result.SerializeType, result.Reply = st, data
+	}
+	if resMetadata, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok && len(resMetadata) > 0 {
+		result.Metadata = make(map[string]string, len(resMetadata))
+		for k, v := range resMetadata {
+			result.Metadata[k] = v
+		}
+	}
+
+	if p.Store.Complete(context.Background(), key, result, p.ttl()) == nil {
+		completed = true
+	}
+	return err
+}
+
+func (p *IdempotencyPlugin) replay(ctx context.Context, result *IdempotentResult, reply interface{}) error {
+	if resMetadata, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok {
+		for k, v := range result.Metadata {
+			resMetadata[k] = v
+		}
+	}
+	if result.Error != nil {
+		if serr := rerrors.FromMetadata(result.Error); serr != nil {
+			return serr
+		}
+		return errors.New(result.Error[protocol.ServiceError])
+	}
+	// the codec of the request also decodes the transcoded JSON of the gateway.
+	if st, cc, ok := server.ReplyCodecFromContext(ctx); ok && st == result.SerializeType {
+		return cc.Decode(result.Reply, reply)
+	}
+	cc := share.Codecs[result.SerializeType]
+	if cc == nil {
+		return fmt.Errorf("rpcx: can not find codec for %d", result.SerializeType)
+	}
+	return cc.Decode(result.Reply, reply)
+}
+
+func (p *IdempotencyPlugin) caller(ctx context.Context, metadata map[string]string) string {
+	if p.Caller != nil {
+		return p.Caller(ctx)
+	}
+	if token := tokenHash(metadata[share.AuthKey]); token != "" {
+		return "token:" + token
+	}
+	if identity := server.PeerIdentityFromContext(ctx); identity != nil {
+		return "peer:" + identity.Subject
+	}
+	return ""
+}
+
+func (p *IdempotencyPlugin) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultIdempotencyTTL
+}
+
+func (p *IdempotencyPlugin) lockTTL() time.Duration {
+	if p.LockTTL > 0 {
+		return p.LockTTL
+	}
+	return DefaultIdempotencyLockTTL
+}
+
+func (p *IdempotencyPlugin) pollInterval() time.Duration {
+	if p.PollInterval > 0 {
+		return p.PollInterval
+	}
+	return 10 * time.Millisecond
+}
+
+// retryable returns true for the errors of calls that may succeed if they are run again.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, server.ErrHandlerTimeout) || errors.Is(err, server.ErrServerOverloaded) {
+		return true
+	}
+	switch rerrors.CodeOf(err) {
+	case rerrors.Canceled, rerrors.DeadlineExceeded, rerrors.ResourceExhausted, rerrors.Aborted, rerrors.Unavailable:
+		return true
+	}
+	return false
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore in memory.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	result  *IdempotentResult
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns a MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]*idempotencyEntry)}
+}
+
+// Reserve reserves key.
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, *IdempotentResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Second {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		return false, e.result, nil
+	}
+	s.entries[key] = &idempotencyEntry{expires: now.Add(ttl)}
+	return true, nil, nil
+}
+
+// Complete keeps the result of key.
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, result *IdempotentResult, ttl time.Duration) error {
+	s.mu.Lock()
+	s.entries[key] = &idempotencyEntry{result: result, expires: time.Now().Add(ttl)}
+	s.mu.Unlock()
+	return nil
+}
+
+// Release drops the reservation of key.
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	if e, ok := s.entries[key]; ok && e.result == nil {
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+	return nil
+}
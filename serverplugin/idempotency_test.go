@@ -0,0 +1,98 @@
+package serverplugin
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/server"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+type idempotencyReply struct {
+	N int
+}
+
+func TestIdempotencyPlugin(t *testing.T) {
+	p := NewIdempotencyPlugin(nil)
+
+	var calls int32
+	var fail error
+	next := func(ctx context.Context, args, reply interface{}) error {
+		n := atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		if fail != nil {
+			return fail
+		}
+		reply.(*idempotencyReply).N = int(n)
+		ctx.Value(share.ResMetaDataKey).(map[string]string)["n"] = "first"
+		return nil
+	}
+	call := func(key string, token ...string) (*idempotencyReply, map[string]string, error) {
+		resMetadata := map[string]string{}
+		metadata := map[string]string{share.IdempotencyKey: key}
+		if len(token) > 0 {
+			metadata[share.AuthKey] = token[0]
+		}
+		ctx := server.WithReplyCodec(share.WithLocalValue(share.WithValue(context.Background(), share.ReqMetaDataKey, metadata),
+			share.ResMetaDataKey, resMetadata), protocol.JSON, share.Codecs[protocol.JSON])
+		reply := &idempotencyReply{}
+		err := p.Intercept(ctx, "Arith", "Mul", nil, reply, next)
+		return reply, resMetadata, err
+	}
+
+	// concurrent duplicates wait for the first call
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, resMetadata, err := call("k1")
+			if err != nil || reply.N != 1 || resMetadata["n"] != "first" {
+				t.Errorf("expect the result of the first call but got %v, %v, %v", reply.N, resMetadata, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expect 1 call but got %d", calls)
+	}
+
+	// a completed call is replayed
+	if reply, _, err := call("k1"); err != nil || reply.N != 1 || calls != 1 {
+		t.Fatalf("expect the result of the first call but got %d, %v", reply.N, err)
+	}
+	if reply, _, err := call("k2"); err != nil || reply.N != 2 {
+		t.Fatalf("expect another key to run but got %d, %v", reply.N, err)
+	}
+
+	// the keys of other callers are not shared
+	if reply, _, err := call("k2", "token-a"); err != nil || reply.N != 3 {
+		t.Fatalf("expect the key of another caller to run but got %d, %v", reply.N, err)
+	}
+	if reply, _, err := call("k2", "token-a"); err != nil || reply.N != 3 {
+		t.Fatalf("expect the result of the caller but got %d, %v", reply.N, err)
+	}
+	result := p.Store.(*MemoryIdempotencyStore).entries["|Arith.Mul|k1"].result
+	if result.SerializeType != protocol.JSON || string(result.Reply) != `{"N":1}` {
+		t.Fatalf("expect the reply encoded as JSON but got %d %s", result.SerializeType, result.Reply)
+	}
+
+	// errors worth a retry are not kept
+	fail = rerrors.New(rerrors.Unavailable, "try again")
+	if _, _, err := call("k3"); rerrors.CodeOf(err) != rerrors.Unavailable {
+		t.Fatalf("expect Unavailable but got %v", err)
+	}
+	fail = rerrors.New(rerrors.InvalidArgument, "bad args")
+	if _, _, err := call("k3"); rerrors.CodeOf(err) != rerrors.InvalidArgument {
+		t.Fatalf("expect InvalidArgument but got %v", err)
+	}
+	fail = nil
+	if _, _, err := call("k3"); rerrors.CodeOf(err) != rerrors.InvalidArgument || calls != 5 {
+		t.Fatalf("expect the kept InvalidArgument error after 5 calls but got %v after %d calls", err, calls)
+	}
+}
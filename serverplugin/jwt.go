@@ -0,0 +1,297 @@
+package serverplugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+type jwtClaimsContextKey struct{}
+
+// JWTClaimsFromContext returns the claims of the JWT the request has been authenticated with, or nil.
+func JWTClaimsFromContext(ctx context.Context) jwt.MapClaims {
+	claims, _ := ctx.Value(jwtClaimsContextKey{}).(jwt.MapClaims)
+	return claims
+}
+
+// JWTPlugin authenticates requests with JWT bearer tokens.
+// Set its Auth method as the AuthFunc of the server:
+//
+//	s.AuthFunc = jwtPlugin.Auth
+//
+// The claims of valid tokens are put in the context of the handlers, see JWTClaimsFromContext.
+type JWTPlugin struct {
+	// Keyfunc returns the key that verifies a token, see HMACKey, PublicKey and JWKS.Keyfunc.
+	Keyfunc jwt.Keyfunc
+	// Methods are the accepted signing algorithms, e.g. "HS256", "RS256" or "ES256".
+	Methods []string
+	// Audience and Issuer are checked if they are set.
+	Audience string
+	Issuer   string
+	// Leeway is the clock skew allowed when exp, nbf and iat are checked.
+	Leeway time.Duration
+	// AllowMissingExp accepts tokens without an exp claim, they never expire.
+	AllowMissingExp bool
+}
+
+// NewJWTPlugin returns a JWTPlugin that verifies the tokens signed with methods with the key of keyfunc.
+func NewJWTPlugin(keyfunc jwt.Keyfunc, methods ...string) *JWTPlugin {
+	return &JWTPlugin{Keyfunc: keyfunc, Methods: methods}
+}
+
+// Auth authenticates req with its bearer token, the "Bearer " prefix is optional.
+// Invalid tokens fail with an Unauthenticated error.
+func (p *JWTPlugin) Auth(ctx context.Context, req *protocol.Message, token string) error {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	if token == "" {
+		return rerrors.New(rerrors.Unauthenticated, "rpcx: missing bearer token")
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(p.Methods), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(token, claims, p.Keyfunc); err != nil {
+		return rerrors.New(rerrors.Unauthenticated, "rpcx: invalid token: "+err.Error())
+	}
+	if err := p.validate(claims); err != nil {
+		return rerrors.New(rerrors.Unauthenticated, "rpcx: invalid token: "+err.Error())
+	}
+
+	// the context of rpcx requests holds the values of the handlers
+	if sctx, ok := ctx.(*share.Context); ok {
+		sctx.SetValue(jwtClaimsContextKey{}, claims)
+	}
+	return nil
+}
+
+func (p *JWTPlugin) validate(claims jwt.MapClaims) error {
+	now := time.Now()
+	if _, ok := claims["exp"]; !ok && !p.AllowMissingExp {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-p.Leeway).Unix(), false) {
+		return fmt.Errorf("token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(p.Leeway).Unix(), false) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(p.Leeway).Unix(), false) {
+		return fmt.Errorf("token is used before issued")
+	}
+	if p.Audience != "" && !claims.VerifyAudience(p.Audience, true) {
+		return fmt.Errorf("token is not issued for %s", p.Audience)
+	}
+	if p.Issuer != "" && !claims.VerifyIssuer(p.Issuer, true) {
+		return fmt.Errorf("token is not issued by %s", p.Issuer)
+	}
+	return nil
+}
+
+// HMACKey returns a Keyfunc for tokens signed with secret by HS256, HS384 or HS512.
+func HMACKey(secret []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return secret, nil
+	}
+}
+
+// PublicKey returns a Keyfunc for tokens signed by the private key of key, an RSA or ECDSA public key.
+func PublicKey(key crypto.PublicKey) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	}
+}
+
+// JWKS is a JSON Web Key Set loaded from a file or an HTTP endpoint.
+// Keys are looked up by the kid of tokens, the set is loaded again when a kid is unknown
+// so that rotated keys are found, at most once every RefreshInterval.
+type JWKS struct {
+	// RefreshInterval is the min time between two loads, one minute by default.
+	RefreshInterval time.Duration
+
+	load func() ([]byte, error)
+
+	mu       sync.Mutex
+	keys     map[string]interface{}
+	loadedAt time.Time
+}
+
+// NewFileJWKS loads the key set of file.
+func NewFileJWKS(file string) (*JWKS, error) {
+	return newJWKS(func() ([]byte, error) {
+		return ioutil.ReadFile(file)
+	})
+}
+
+// NewHTTPJWKS loads the key set of url.
+func NewHTTPJWKS(url string) (*JWKS, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return newJWKS(func() ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("rpcx: failed to get JWKS from %s: %s", url, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	})
+}
+
+func newJWKS(load func() ([]byte, error)) (*JWKS, error) {
+	j := &JWKS{RefreshInterval: time.Minute, load: load}
+	if err := j.Reload(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Reload loads the key set again.
+func (j *JWKS) Reload() error {
+	data, err := j.load()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.loadedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+// Keyfunc returns the key of the kid of token.
+func (j *JWKS) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	j.mu.Lock()
+	key, ok := j.keys[kid]
+	refresh := !ok && time.Since(j.loadedAt) >= j.RefreshInterval
+	j.mu.Unlock()
+	if refresh {
+		if err := j.Reload(); err != nil {
+			return nil, err
+		}
+		j.mu.Lock()
+		key, ok = j.keys[kid]
+		j.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// jsonWebKey is a key of a JWKS, see RFC 7517 and RFC 7518.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("rpcx: invalid JWKS: %v", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.key()
+		if err != nil {
+			return nil, fmt.Errorf("rpcx: invalid key %q in JWKS: %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk *jsonWebKey) key() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(jwk.K)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,101 @@
+package serverplugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+func TestJWTPlugin_HMAC(t *testing.T) {
+	secret := []byte("secret")
+	p := NewJWTPlugin(HMACKey(secret), "HS256")
+	p.Audience = "rpcx"
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	ctx := share.NewContext(context.Background())
+	token := sign(jwt.MapClaims{"sub": "alice", "aud": "rpcx", "exp": time.Now().Add(time.Minute).Unix()})
+	if err := p.Auth(ctx, protocol.NewMessage(), "Bearer "+token); err != nil {
+		t.Fatalf("failed to auth: %v", err)
+	}
+	if claims := JWTClaimsFromContext(ctx); claims == nil || claims["sub"] != "alice" {
+		t.Fatalf("expect the claims of alice in the context but got %v", claims)
+	}
+
+	cases := map[string]string{
+		"expired":        sign(jwt.MapClaims{"aud": "rpcx", "exp": time.Now().Add(-time.Minute).Unix()}),
+		"other audience": sign(jwt.MapClaims{"aud": "other", "exp": time.Now().Add(time.Minute).Unix()}),
+		"no exp":         sign(jwt.MapClaims{"aud": "rpcx"}),
+		"bad signature":  token[:len(token)-2] + "xx",
+		"empty":          "",
+	}
+	for name, token := range cases {
+		if err := p.Auth(context.Background(), protocol.NewMessage(), token); rerrors.CodeOf(err) != rerrors.Unauthenticated {
+			t.Errorf("expect an Unauthenticated error for the %s token but got %v", name, err)
+		}
+	}
+
+	p.AllowMissingExp = true
+	if err := p.Auth(context.Background(), protocol.NewMessage(), cases["no exp"]); err != nil {
+		t.Fatalf("expect a token without exp accepted with AllowMissingExp but got %v", err)
+	}
+}
+
+func TestJWTPlugin_JWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := fmt.Sprintf(`{"keys": [{"kid": "k1", "kty": "RSA", "use": "sig", "n": %q, "e": %q}]}`,
+		encode(key.N), encode(big.NewInt(int64(key.E))))
+	if err := os.WriteFile(file, []byte(jwks), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewFileJWKS(file)
+	if err != nil {
+		t.Fatalf("failed to load JWKS: %v", err)
+	}
+	p := NewJWTPlugin(keys.Keyfunc, "RS256")
+
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Minute).Unix()})
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if err := p.Auth(context.Background(), protocol.NewMessage(), sign("k1")); err != nil {
+		t.Fatalf("failed to auth: %v", err)
+	}
+	if err := p.Auth(context.Background(), protocol.NewMessage(), sign("k2")); err == nil {
+		t.Fatalf("expect a token of an unknown key rejected")
+	}
+	hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "bob"}).SignedString([]byte("k1"))
+	if err := p.Auth(context.Background(), protocol.NewMessage(), hs); err == nil {
+		t.Fatalf("expect a token of another algorithm rejected")
+	}
+}
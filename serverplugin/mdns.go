@@ -199,3 +199,21 @@ func (p *MDNSRegisterPlugin) Unregister(name string) (err error) {
 
 	return
 }
+
+// UpdateState rewrites the metadata of the service name with state.
+// Clients do not route to services whose state is "inactive".
+func (p *MDNSRegisterPlugin) UpdateState(name string, state string) error {
+	for _, sm := range p.Services {
+		if sm.Service == name {
+			sm.Meta = withState(sm.Meta, state)
+		}
+	}
+
+	if p.server == nil {
+		return errRegistryNotStarted
+	}
+	ss, _ := json.Marshal(p.Services)
+	s := url.QueryEscape(string(ss))
+	p.server.SetText([]string{s})
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	logs "github.com/halokid/rpcx-plus/log"
 	"strings"
+	"sync"
 
 	"github.com/halokid/rpcx-plus/util"
 	"github.com/nacos-group/nacos-sdk-go/clients"
@@ -24,7 +25,9 @@ type NacosRegisterPlugin struct {
 	Tenant       string
 
 	// Registered services
-	Services []string
+	Services  []string
+	metasLock sync.RWMutex
+	metas     map[string]string
 
 	namingClient naming_client.INamingClient
 
@@ -85,6 +88,23 @@ func (p *NacosRegisterPlugin) Register(name string, rcvr interface{}, metadata s
 		return errors.New("Register service `name` can't be empty")
 	}
 
+	err = p.registerInstance(name, metadata)
+	if err != nil {
+		return err
+	}
+
+	p.Services = append(p.Services, name)
+
+	p.metasLock.Lock()
+	if p.metas == nil {
+		p.metas = make(map[string]string)
+	}
+	p.metas[name] = metadata
+	p.metasLock.Unlock()
+	return
+}
+
+func (p *NacosRegisterPlugin) registerInstance(name string, metadata string) error {
 	network, ip, port, err := util.ParseRpcxAddress(p.ServiceAddress)
 	if err != nil {
 		logs.Errorf("failed to parse rpcx addr in Register: %v", err)
@@ -109,12 +129,8 @@ func (p *NacosRegisterPlugin) Register(name string, rcvr interface{}, metadata s
 	_, err = p.namingClient.RegisterInstance(inst)
 	if err != nil {
 		logs.Errorf("failed to register %s: %v", name, err)
-		return err
 	}
-
-	p.Services = append(p.Services, name)
-
-	return
+	return err
 }
 
 func (p *NacosRegisterPlugin) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
@@ -151,3 +167,20 @@ func (p *NacosRegisterPlugin) Unregister(name string) (err error) {
 
 	return nil
 }
+
+// UpdateState registers the instance of the service name again with state in its metadata.
+// Clients do not route to services whose state is "inactive".
+func (p *NacosRegisterPlugin) UpdateState(name string, state string) error {
+	p.metasLock.Lock()
+	if p.metas == nil {
+		p.metas = make(map[string]string)
+	}
+	meta := withState(p.metas[name], state)
+	p.metas[name] = meta
+	p.metasLock.Unlock()
+
+	if p.namingClient == nil {
+		return errRegistryNotStarted
+	}
+	return p.registerInstance(name, meta)
+}
@@ -254,3 +254,26 @@ func (p *RedisRegisterPlugin) Unregister(name string) (err error) {
 	p.metasLock.Unlock()
 	return
 }
+
+// UpdateState rewrites the metadata of the service name with state.
+// Clients do not route to services whose state is "inactive".
+func (p *RedisRegisterPlugin) UpdateState(name string, state string) error {
+	p.metasLock.Lock()
+	if p.metas == nil {
+		p.metas = make(map[string]string)
+	}
+	meta := withState(p.metas[name], state)
+	p.metas[name] = meta
+	p.metasLock.Unlock()
+
+	if p.kv == nil {
+		return errRegistryNotStarted
+	}
+
+	nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
+	err := p.kv.Put(nodePath, []byte(meta), &store.WriteOptions{TTL: p.UpdateInterval * 3})
+	if err != nil {
+		logs.Errorf("cannot update redis path %s: %v", nodePath, err)
+	}
+	return err
+}
@@ -0,0 +1,256 @@
+package serverplugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+// RateLimiter limits the requests of keys.
+type RateLimiter interface {
+	// Allow takes a request of key. If it is not allowed, Allow returns how long to wait
+	// before the next request of key may be allowed.
+	Allow(key string, now time.Time) (bool, time.Duration)
+}
+
+// RateLimitKeyFunc returns the key of the limit of a request, e.g. the identity of its caller.
+type RateLimitKeyFunc func(ctx context.Context, r *protocol.Message) string
+
+// RateLimitByMethod limits every method on its own.
+func RateLimitByMethod(ctx context.Context, r *protocol.Message) string {
+	return r.ServicePath + "." + r.ServiceMethod
+}
+
+// RateLimitByClientIP limits every client IP on its own.
+func RateLimitByClientIP(ctx context.Context, r *protocol.Message) string {
+	addr := remoteAddr(ctx)
+	if ip := remoteIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// RateLimitByToken limits every auth token on its own. Tokens are hashed so that they are not kept.
+// Tokens are chosen by clients, so they must be verified by the AuthFunc of the server,
+// otherwise a client gets a new limit with every new token.
+func RateLimitByToken(ctx context.Context, r *protocol.Message) string {
+	return tokenHash(r.Metadata[share.AuthKey])
+}
+
+// tokenHash returns the hash of an auth token, or "" if there is no token.
+func tokenHash(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
+
+// RateLimitByMetadata limits every value of the metadata key on its own, e.g. a tenant or an API key.
+func RateLimitByMetadata(key string) RateLimitKeyFunc {
+	return func(ctx context.Context, r *protocol.Message) string {
+		return r.Metadata[key]
+	}
+}
+
+// RateLimitBy combines keys, e.g. RateLimitBy(RateLimitByToken, RateLimitByMethod)
+// limits every method for every caller.
+func RateLimitBy(keys ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(ctx context.Context, r *protocol.Message) string {
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = key(ctx, r)
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+// RateLimitRule limits the calls of the methods matching Service and Method with Limiter.
+// Service and Method are patterns where * matches any characters, an empty pattern matches anything.
+type RateLimitRule struct {
+	Service string
+	Method  string
+	// Key returns the key of the limit of a call, all matched calls share one limit if it is nil.
+	Key     RateLimitKeyFunc
+	Limiter RateLimiter
+}
+
+// RequestRateLimitingPlugin limits the rate of requests, unlike RateLimitingPlugin which limits connections.
+// Requests are limited after they have been authenticated, so that keys can rely on the verified caller.
+// A request is checked against every rule it matches and is rejected if one limit is exceeded,
+// with a ResourceExhausted error whose RetryInfo tells when to retry, also sent as the Retry-After header over HTTP.
+type RequestRateLimitingPlugin struct {
+	Rules []*RateLimitRule
+}
+
+// NewRequestRateLimitingPlugin returns a RequestRateLimitingPlugin with rules.
+func NewRequestRateLimitingPlugin(rules ...*RateLimitRule) *RequestRateLimitingPlugin {
+	return &RequestRateLimitingPlugin{Rules: rules}
+}
+
+// Authorize limits r once it has been authenticated.
+func (p *RequestRateLimitingPlugin) Authorize(ctx context.Context, r *protocol.Message) error {
+	if !isCallFrame(r) {
+		return nil
+	}
+
+	now := time.Now()
+	for i, rule := range p.Rules {
+		if !matchPattern(rule.Service, r.ServicePath) || !matchPattern(rule.Method, r.ServiceMethod) {
+			continue
+		}
+		key := fmt.Sprint(i)
+		if rule.Key != nil {
+			key += "|" + rule.Key(ctx, r)
+		}
+		if ok, wait := rule.Limiter.Allow(key, now); !ok {
+			return rerrors.New(rerrors.ResourceExhausted,
+				fmt.Sprintf("rpcx: rate limited, retry after %v", wait), &rerrors.RetryInfo{RetryDelay: wait})
+		}
+	}
+	return nil
+}
+
+// isCallFrame returns true for the messages that start calls or streams.
+func isCallFrame(r *protocol.Message) bool {
+	return !r.IsHeartbeat() && (r.FrameType() == protocol.FrameNormal || r.FrameType() == protocol.FrameStreamOpen)
+}
+
+// TokenBucketLimiter allows rate requests per second for every key, with bursts of up to burst requests.
+// rate must be positive.
+type TokenBucketLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter returns a TokenBucketLimiter.
+func NewTokenBucketLimiter(rate float64, burst int) *TokenBucketLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucketLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token of the bucket of key.
+func (l *TokenBucketLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) > full {
+		// full buckets are the same as new ones
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// SlidingWindowLimiter allows limit requests in any window for every key.
+// The count of the sliding window is estimated from the counts of the current and the previous fixed windows.
+type SlidingWindowLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*slidingWindow
+	current time.Time
+}
+
+type slidingWindow struct {
+	start time.Time
+	prev  int
+	count int
+}
+
+// NewSlidingWindowLimiter returns a SlidingWindowLimiter.
+func NewSlidingWindowLimiter(limit int, window time.Duration) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{limit: limit, window: window, windows: make(map[string]*slidingWindow)}
+}
+
+// Allow counts a request of key in its window.
+func (l *SlidingWindowLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := now.Truncate(l.window)
+	if start.After(l.current) {
+		// the keys idle for two windows are the same as new ones
+		for k, w := range l.windows {
+			if start.Sub(w.start) > l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.current = start
+	}
+
+	w := l.windows[key]
+	if w == nil {
+		w = &slidingWindow{start: start}
+		l.windows[key] = w
+	}
+	switch d := start.Sub(w.start); {
+	case d == l.window:
+		w.prev, w.count = w.count, 0
+		w.start = start
+	case d > l.window:
+		w.prev, w.count = 0, 0
+		w.start = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(l.window)
+	if float64(w.prev)*weight+float64(w.count) < float64(l.limit) {
+		w.count++
+		return true, 0
+	}
+
+	// wait until the weight of the previous window has dropped enough,
+	// or until the weight of the current window has dropped enough in the next one.
+	wait := l.window - elapsed
+	if w.count < l.limit {
+		need := 1 - float64(l.limit-w.count)/float64(w.prev)
+		wait = time.Duration(need*float64(l.window)) - elapsed
+	} else if w.count > 0 {
+		wait += time.Duration((1 - float64(l.limit)/float64(w.count)) * float64(l.window))
+	}
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return false, wait
+}
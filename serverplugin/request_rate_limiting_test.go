@@ -0,0 +1,109 @@
+package serverplugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rerrors "github.com/halokid/rpcx-plus/errors"
+	"github.com/halokid/rpcx-plus/protocol"
+	"github.com/halokid/rpcx-plus/share"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := NewTokenBucketLimiter(10, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Fatalf("expect the burst to be allowed")
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok {
+		t.Fatalf("expect the request after the burst to be limited")
+	}
+	if wait != 100*time.Millisecond {
+		t.Fatalf("expect to wait 100ms but got %v", wait)
+	}
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Fatalf("expect another key to have its own bucket")
+	}
+	if ok, _ := l.Allow("a", now.Add(wait)); !ok {
+		t.Fatalf("expect a request to be allowed after the wait")
+	}
+
+	// idle buckets are dropped
+	l.Allow("c", now.Add(time.Second))
+	if len(l.buckets) != 1 {
+		t.Fatalf("expect 1 bucket but got %d", len(l.buckets))
+	}
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	l := NewSlidingWindowLimiter(4, time.Second)
+	start := time.Now().Truncate(time.Second)
+
+	for i := 0; i < 4; i++ {
+		if ok, _ := l.Allow("a", start.Add(100*time.Millisecond)); !ok {
+			t.Fatalf("expect %d requests to be allowed", i+1)
+		}
+	}
+	if ok, wait := l.Allow("a", start.Add(500*time.Millisecond)); ok || wait != 500*time.Millisecond {
+		t.Fatalf("expect to wait 500ms for the next window but got %v, %v", ok, wait)
+	}
+
+	// 90% of the previous window still counts 3.6 requests
+	next := start.Add(1100 * time.Millisecond)
+	if ok, _ := l.Allow("a", next); !ok {
+		t.Fatalf("expect a request to be allowed in the next window")
+	}
+	ok, wait := l.Allow("a", next)
+	if ok {
+		t.Fatalf("expect the sliding window to limit the request")
+	}
+	if wait != 150*time.Millisecond {
+		t.Fatalf("expect to wait 150ms but got %v", wait)
+	}
+}
+
+func TestRequestRateLimitingPlugin(t *testing.T) {
+	p := NewRequestRateLimitingPlugin(&RateLimitRule{
+		Service: "Arith",
+		Key:     RateLimitBy(RateLimitByToken, RateLimitByMethod),
+		Limiter: NewTokenBucketLimiter(1, 1),
+	})
+	call := func(method, token string) error {
+		req := protocol.NewMessage()
+		req.ServicePath = "Arith"
+		req.ServiceMethod = method
+		req.Metadata = map[string]string{share.AuthKey: token}
+		return p.Authorize(context.Background(), req)
+	}
+
+	if err := call("Mul", "alice"); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	err := call("Mul", "alice")
+	if rerrors.CodeOf(err) != rerrors.ResourceExhausted {
+		t.Fatalf("expect a ResourceExhausted error but got %v", err)
+	}
+	if delay, ok := rerrors.RetryDelay(err); !ok || delay <= 0 || delay > time.Second {
+		t.Fatalf("expect a retry delay of up to 1s but got %v", delay)
+	}
+
+	if err := call("Add", "alice"); err != nil {
+		t.Fatalf("expect another method to have its own limit but got %v", err)
+	}
+	if err := call("Mul", "bob"); err != nil {
+		t.Fatalf("expect another caller to have its own limit but got %v", err)
+	}
+
+	heartbeat := protocol.NewMessage()
+	heartbeat.SetHeartbeat(true)
+	for i := 0; i < 3; i++ {
+		if err := p.Authorize(context.Background(), heartbeat); err != nil {
+			t.Fatalf("expect heartbeats not to be limited but got %v", err)
+		}
+	}
+}
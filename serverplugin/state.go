@@ -0,0 +1,19 @@
+package serverplugin
+
+import (
+	"errors"
+	"net/url"
+)
+
+var errRegistryNotStarted = errors.New("registry has not been started")
+
+// withState returns metadata with its state set to state. An empty state removes it.
+func withState(metadata, state string) string {
+	v, _ := url.ParseQuery(metadata)
+	if state == "" {
+		v.Del("state")
+	} else {
+		v.Set("state", state)
+	}
+	return v.Encode()
+}
@@ -0,0 +1,20 @@
+package serverplugin
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestWithState(t *testing.T) {
+	meta := withState("group=test&tps=10", "inactive")
+	v, _ := url.ParseQuery(meta)
+	if v.Get("state") != "inactive" || v.Get("group") != "test" {
+		t.Fatalf("unexpected metadata: %s", meta)
+	}
+
+	meta = withState(meta, "")
+	v, _ = url.ParseQuery(meta)
+	if _, ok := v["state"]; ok || v.Get("tps") != "10" {
+		t.Fatalf("unexpected metadata: %s", meta)
+	}
+}
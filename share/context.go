@@ -36,6 +36,22 @@ func (c *Context) SetValue(key, val interface{}) {
 	c.tags[key] = val
 }
 
+// Clone returns a copy of c with its own values, whose parent is parent.
+func (c *Context) Clone(parent context.Context) *Context {
+	tags := make(map[interface{}]interface{}, len(c.tags))
+	for k, v := range c.tags {
+		tags[k] = v
+	}
+	return &Context{Context: parent, tags: tags}
+}
+
+// Merge sets the values of from to c.
+func (c *Context) Merge(from *Context) {
+	for k, v := range from.tags {
+		c.tags[k] = v
+	}
+}
+
 func (c *Context) String() string {
 	return fmt.Sprintf("%v.WithValue(%v)", c.Context, c.tags)
 }
@@ -0,0 +1,36 @@
+package share
+
+import (
+	"context"
+	"time"
+)
+
+// WithDeadlineMetadata returns metadata that carries the time left before the deadline of ctx.
+// The remaining time is sent instead of the deadline itself so that the clocks of clients
+// and servers need not be synchronized. m is copied and never changed.
+func WithDeadlineMetadata(ctx context.Context, m map[string]string) map[string]string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return m
+	}
+
+	meta := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		meta[k] = v
+	}
+	meta[DeadlineKey] = time.Until(deadline).String()
+	return meta
+}
+
+// DeadlineFromMetadata returns the deadline carried in metadata, measured from now.
+func DeadlineFromMetadata(m map[string]string) (time.Time, bool) {
+	v := m[DeadlineKey]
+	if v == "" {
+		return time.Time{}, false
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Now().Add(d), true
+}
@@ -0,0 +1,61 @@
+package share
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// SerializersKey is used in the registry metadata of services to advertise the serializers of the server.
+const SerializersKey = "serializers"
+
+// SupportedSerializers returns the serializers of Codecs in ascending order.
+func SupportedSerializers() []protocol.SerializeType {
+	serializers := make([]protocol.SerializeType, 0, len(Codecs))
+	for st := range Codecs {
+		serializers = append(serializers, st)
+	}
+	sort.Slice(serializers, func(i, j int) bool { return serializers[i] < serializers[j] })
+	return serializers
+}
+
+// WithSerializersMetadata adds the serializers of Codecs to the registry metadata of a service.
+// Metadata that already advertises serializers is returned unchanged.
+func WithSerializersMetadata(metadata string) string {
+	if v, err := url.ParseQuery(metadata); err == nil && v.Get(SerializersKey) != "" {
+		return metadata
+	}
+
+	serializers := SupportedSerializers()
+	values := make([]string, 0, len(serializers))
+	for _, st := range serializers {
+		values = append(values, strconv.Itoa(int(st)))
+	}
+	kv := SerializersKey + "=" + strings.Join(values, ",")
+	if metadata == "" {
+		return kv
+	}
+	return metadata + "&" + kv
+}
+
+// SerializersFromMetadata returns the serializers advertised in the registry metadata of a service.
+// It returns nil if the server does not advertise them.
+func SerializersFromMetadata(metadata string) []protocol.SerializeType {
+	v, err := url.ParseQuery(metadata)
+	if err != nil || v.Get(SerializersKey) == "" {
+		return nil
+	}
+
+	var serializers []protocol.SerializeType
+	for _, s := range strings.Split(v.Get(SerializersKey), ",") {
+		st, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			continue
+		}
+		serializers = append(serializers, protocol.SerializeType(st))
+	}
+	return serializers
+}
@@ -12,6 +12,12 @@ const (
 	// AuthKey is used in metadata.
 	AuthKey = "__AUTH"
 
+	// DeadlineKey is used in metadata to carry the time left before the deadline of the caller.
+	DeadlineKey = "__rpcx_deadline"
+
+	// IdempotencyKey is used in metadata to carry the key that is the same for all the retries of a call.
+	IdempotencyKey = "__rpcx_idempotency_key"
+
 	// OpentracingSpanServerKey key in service context
 	OpentracingSpanServerKey = "opentracing_span_server_key"
 	// OpentracingSpanClientKey key in client context
@@ -33,6 +39,9 @@ var (
 		protocol.ProtoBuffer:   &codec.PBCodec{},
 		protocol.MsgPack:       &codec.MsgpackCodec{},
 		protocol.Thrift:        &codec.ThriftCodec{},
+		protocol.CBOR:          &codec.CBORCodec{},
+		protocol.BSON:          &codec.BSONCodec{},
+		protocol.Gob:           &codec.GobCodec{},
 	}
 )
 
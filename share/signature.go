@@ -0,0 +1,93 @@
+package share
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/halokid/rpcx-plus/protocol"
+)
+
+// Metadata keys of HMAC signed requests.
+const (
+	// SignatureKey carries the base64 HMAC-SHA256 signature of the request.
+	SignatureKey = "__rpcx_sig"
+	// SignatureKeyIDKey carries the id of the key the request is signed with.
+	SignatureKeyIDKey = "__rpcx_sig_kid"
+	// SignatureTimestampKey carries the unix time in milliseconds when the request was signed.
+	SignatureTimestampKey = "__rpcx_sig_ts"
+	// SignatureNonceKey carries a random value that is used once.
+	SignatureNonceKey = "__rpcx_sig_nonce"
+	// SignatureMetadataKey carries the comma separated keys of the signed metadata.
+	SignatureMetadataKey = "__rpcx_sig_meta"
+)
+
+// SignatureBase returns the bytes signed by the HMAC signature of req: the service path and method,
+// the key id, timestamp and nonce, the SHA-256 digest of the uncompressed payload
+// and the keys and values of the metadata listed in SignatureMetadataKey.
+// Every field is prefixed with its length, so that fields containing separators
+// cannot make different requests sign the same bytes.
+func SignatureBase(req *protocol.Message) []byte {
+	digest := sha256.Sum256(req.Payload)
+
+	var buf bytes.Buffer
+	buf.WriteString("rpcx-hmac-sha256\n")
+	for _, s := range []string{
+		req.ServicePath,
+		req.ServiceMethod,
+		req.Metadata[SignatureKeyIDKey],
+		req.Metadata[SignatureTimestampKey],
+		req.Metadata[SignatureNonceKey],
+		hex.EncodeToString(digest[:]),
+	} {
+		writeSignatureField(&buf, s)
+	}
+
+	for _, k := range SignedMetadata(req) {
+		writeSignatureField(&buf, k)
+		writeSignatureField(&buf, req.Metadata[k])
+	}
+	return buf.Bytes()
+}
+
+// writeSignatureField writes s to buf as its length, a colon, s and a newline.
+func writeSignatureField(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+	buf.WriteByte('\n')
+}
+
+// SignedMetadata returns the sorted keys of the signed metadata of req.
+func SignedMetadata(req *protocol.Message) []string {
+	list := req.Metadata[SignatureMetadataKey]
+	if list == "" {
+		return nil
+	}
+	keys := strings.Split(list, ",")
+	sort.Strings(keys)
+	return keys
+}
+
+// Sign returns the signature of base with secret.
+func Sign(secret, base []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(base)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the signature of base with secret, in constant time.
+func VerifySignature(secret, base []byte, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(base)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
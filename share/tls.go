@@ -0,0 +1,242 @@
+package share
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	logs "github.com/halokid/rpcx-plus/log"
+)
+
+// DefaultCertReloadInterval is how often a CertReloader checks its files by default.
+const DefaultCertReloadInterval = 10 * time.Second
+
+// CertReloader keeps a certificate, and optionally a CA bundle, loaded from files
+// and reloads them when the files change, so that certificates are rotated without restarts.
+// The tls.Configs returned by ServerConfig and ClientConfig always use the last loaded files.
+// Files that fail to load are logged and the previous certificate is kept.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime map[string]time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCertReloader loads the certificate and key of certFile and keyFile and the CA bundle of caFile,
+// which may be empty, and checks the files for changes every interval.
+// DefaultCertReloadInterval is used if interval is 0.
+func NewCertReloader(certFile, keyFile, caFile string, interval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		modTime:  make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = DefaultCertReloadInterval
+	}
+	go r.watch(interval)
+	return r, nil
+}
+
+// Reload loads the files again.
+func (r *CertReloader) Reload() error {
+	modTime := make(map[string]time.Time)
+	for _, file := range r.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTime[file] = fi.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("rpcx: no certificates in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *CertReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+// changed returns true if a file has been modified since it was loaded.
+func (r *CertReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, file := range r.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			// the file may be being replaced
+			continue
+		}
+		if !fi.ModTime().Equal(r.modTime[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !r.changed() {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			logs.Errorf("rpcx: failed to reload certificate %s: %v", r.certFile, err)
+			continue
+		}
+		logs.Infof("rpcx: reloaded certificate %s", r.certFile)
+	}
+}
+
+// Close stops checking the files.
+func (r *CertReloader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+	return nil
+}
+
+// Certificate returns the last loaded certificate.
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// CertPool returns the last loaded CA bundle, it is nil if the reloader has no CA file.
+func (r *CertReloader) CertPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// ServerConfig returns a copy of base, which may be nil, that presents the reloaded certificate.
+// If the reloader has a CA file, client certificates are required and verified with it
+// unless base sets another ClientAuth. The configs of each client are then made by GetConfigForClient
+// from the returned one as it is now, so later changes of its NextProtos must be applied to them too.
+func (r *CertReloader) ServerConfig(base *tls.Config) *tls.Config {
+	cfg := cloneTLSConfig(base)
+	cfg.Certificates = nil
+	cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	if r.caFile == "" {
+		return cfg
+	}
+
+	if cfg.ClientAuth == tls.NoClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := cfg.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = r.CertPool()
+		return c, nil
+	}
+	return cfg
+}
+
+// ClientConfig returns a copy of base, which may be nil, that presents the reloaded certificate
+// to servers that ask for one. If the reloader has a CA file, servers are verified with it
+// instead of the system roots. Servers are verified against the name sent to them, or the ServerName
+// of the returned config, so servers dialed by IP address need a ServerName. Connections without
+// either are refused.
+func (r *CertReloader) ClientConfig(base *tls.Config) *tls.Config {
+	cfg := cloneTLSConfig(base)
+	cfg.Certificates = nil
+	cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	if r.caFile == "" || cfg.InsecureSkipVerify {
+		return cfg
+	}
+
+	// RootCAs is fixed once the config is used, so the chain is verified against the reloaded bundle here.
+	cfg.InsecureSkipVerify = true
+	verifyConnection := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("rpcx: server has no certificate")
+		}
+		// no name is sent to servers dialed by IP address.
+		serverName := cs.ServerName
+		if serverName == "" {
+			serverName = cfg.ServerName
+		}
+		if serverName == "" {
+			return errors.New("rpcx: either ServerName or InsecureSkipVerify must be specified in the tls.Config")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         r.CertPool(),
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+			return err
+		}
+		if verifyConnection != nil {
+			return verifyConnection(cs)
+		}
+		return nil
+	}
+	return cfg
+}
+
+func cloneTLSConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		return &tls.Config{}
+	}
+	return cfg.Clone()
+}